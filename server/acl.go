@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Rights of a user over a collection, each level includes the previous ones
+type AccessLevel int
+
+const (
+	AccessNone  AccessLevel = iota // Collection is not visible
+	AccessRead                     // Browse albums and photos
+	AccessWrite                    // Upload and organize photos, edit pseudo albums
+	AccessAdmin                    // Manage the collection (e.g. delete albums, maintenance)
+)
+
+// Access control list of a collection, keys are usernames, groups prefixed by @ or * for any user
+type ACL map[string]AccessLevel
+
+func (level AccessLevel) String() string {
+	switch level {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+func ParseAccessLevel(level string) (AccessLevel, error) {
+	switch level {
+	case "none":
+		return AccessNone, nil
+	case "read":
+		return AccessRead, nil
+	case "write":
+		return AccessWrite, nil
+	case "admin":
+		return AccessAdmin, nil
+	}
+	return AccessNone, errors.New("invalid access level: " + level)
+}
+
+// Parse an access control list formatted as: alice:admin;@family:read;*:none
+func ParseACL(value string) (ACL, error) {
+	acl := make(ACL)
+	for _, entry := range strings.Split(value, ";") {
+		if entry == "" {
+			continue
+		}
+		split := strings.SplitN(entry, ":", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, errors.New(entry + " must be formatted as user:level")
+		}
+		level, err := ParseAccessLevel(split[1])
+		if err != nil {
+			return nil, err
+		}
+		acl[split[0]] = level
+	}
+	return acl, nil
+}
+
+// Access level of the user for the collection. Without authentication (user is nil)
+// or without an access control list for the collection, there are no restrictions.
+func (c *Collection) Access(user *User) AccessLevel {
+	if user == nil || c.ACL == nil {
+		return AccessAdmin
+	}
+	// Rights set for the user take precedence
+	if level, ok := c.ACL[user.Name]; ok {
+		return level
+	}
+	// Highest rights from the groups of the user
+	level, found := AccessNone, false
+	for _, group := range user.Groups {
+		if l, ok := c.ACL["@"+group]; ok {
+			found = true
+			if l > level {
+				level = l
+			}
+		}
+	}
+	if found {
+		return level
+	}
+	// Rights for any user
+	return c.ACL["*"]
+}
+
+// Check if the user has at least the access level required for the collection
+func (c *Collection) Allows(user *User, level AccessLevel) bool {
+	return c.Access(user) >= level
+}
+
+// Collections the user can see, hidden ones are not included
+func visibleCollections(user *User) []*Collection {
+	var collections []*Collection
+	for _, collection := range config.collections {
+		if !collection.Hide && collection.Allows(user, AccessRead) {
+			collections = append(collections, collection)
+		}
+	}
+	return collections
+}
+
+// Get collection from the request parameters, only if the user has the required access level
+func CollectionWithAccess(c echo.Context, level AccessLevel) (*Collection, error) {
+	return collectionWithAccess(CurrentUser(c), c.Param("collection"), level)
+}
+
+// Get collection from the request parameters for maintenance (e.g. jobs), read-only collections
+// are allowed since only the cache and thumbnails are modified
+func CollectionToMaintain(c echo.Context) (*Collection, error) {
+	return collectionAllowing(CurrentUser(c), c.Param("collection"), AccessAdmin)
+}
+
+func collectionWithAccess(user *User, name string, level AccessLevel) (*Collection, error) {
+	collection, err := collectionAllowing(user, name, level)
+	if err != nil {
+		return nil, err
+	}
+	// Modifications are not allowed in read-only collections
+	if level >= AccessWrite {
+		if err := collection.CheckWritable(); err != nil {
+			return nil, echo.NewHTTPError(http.StatusForbidden, err.Error()+": "+name)
+		}
+	}
+	return collection, nil
+}
+
+// Collection of the original photo, which in pseudo albums can be another collection that
+// the user must be able to read as well
+func photoCollection(user *User, collection *Collection, album *Album, photo *Photo) (*Collection, error) {
+	if !album.IsPseudo {
+		return collection, nil
+	}
+	return collectionWithAccess(user, photo.Collection, AccessRead)
+}
+
+// Collection only if the user has the required access level, regardless of being read-only
+func collectionAllowing(user *User, name string, level AccessLevel) (*Collection, error) {
+	collection, err := GetCollection(name)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	access := collection.Access(user)
+	// Collections that cannot be seen do not exist for the user
+	if access < AccessRead {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "invalid collection: "+name)
+	}
+	if access < level {
+		return nil, echo.NewHTTPError(http.StatusForbidden, level.String()+" access to collection "+name+" is required")
+	}
+	return collection, nil
+}
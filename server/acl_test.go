@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCollectionAccess(t *testing.T) {
+	acl, err := ParseACL("alice:admin;@family:read;@friends:write;bob:none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := &Collection{Name: "Photos", ACL: acl}
+
+	tests := []struct {
+		user     *User
+		expected AccessLevel
+	}{
+		{nil, AccessAdmin}, // Authentication disabled
+		{&User{Name: "alice"}, AccessAdmin},
+		{&User{Name: "bob", Groups: []string{"family"}}, AccessNone}, // User takes precedence over groups
+		{&User{Name: "carol", Groups: []string{"family"}}, AccessRead},
+		{&User{Name: "dave", Groups: []string{"family", "friends"}}, AccessWrite}, // Highest from groups
+		{&User{Name: "eve"}, AccessNone},
+	}
+	for _, test := range tests {
+		if access := collection.Access(test.user); access != test.expected {
+			t.Errorf("Access for %v: expected %s, got %s", test.user, test.expected, access)
+		}
+	}
+
+	// Any user
+	collection.ACL["*"] = AccessRead
+	if access := collection.Access(&User{Name: "eve"}); access != AccessRead {
+		t.Errorf("Access for any user: expected read, got %s", access)
+	}
+	// No restrictions without ACL
+	collection.ACL = nil
+	if access := collection.Access(&User{Name: "eve"}); access != AccessAdmin {
+		t.Errorf("Access without ACL: expected admin, got %s", access)
+	}
+
+	// Maintenance is allowed in read-only collections, modifications are not
+	collection.ReadOnly = true
+	config.collections = map[string]*Collection{collection.Name: collection}
+	if _, err := collectionWithAccess(nil, "Photos", AccessAdmin); err == nil {
+		t.Error("Modifications allowed in read-only collection")
+	}
+	if _, err := collectionAllowing(nil, "Photos", AccessAdmin); err != nil {
+		t.Error("Maintenance not allowed in read-only collection:", err)
+	}
+
+	if _, err := ParseACL("alice:owner"); err == nil {
+		t.Error("Invalid access level accepted")
+	}
+}
+
+func TestPseudoAlbumAccess(t *testing.T) {
+	private := newTestCollection(t, "Album")
+	private.Name = "Private"
+	private.ACL, _ = ParseACL("alice:read")
+	public := newTestCollection(t)
+	public.ACL, _ = ParseACL("*:read")
+	config.collections = map[string]*Collection{public.Name: public, private.Name: private}
+	os.WriteFile(filepath.Join(private.PhotosPath, "Album/IMG_0001.jpg"), iptcJPEG(t), 0644)
+	if _, err := private.GetAlbumWithPhotos("Album", false, false); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(public.PhotosPath, "Favorites"+PSEUDO_ALBUM_EXT), []byte("Private:Album:img_0001\n"), 0644)
+
+	request := func(handler echo.HandlerFunc, user string, names []string, values ...string) (*httptest.ResponseRecorder, error) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?size=100", nil), rec)
+		c.Set("user", &User{Name: user})
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		return rec, handler(c)
+	}
+	for user, count := range map[string]int{"alice": 1, "bob": 0} {
+		rec, err := request(album, user, []string{"collection", "album"}, "Photos", "Favorites")
+		var result struct{ Count int }
+		if err != nil || json.Unmarshal(rec.Body.Bytes(), &result) != nil || result.Count != count {
+			t.Errorf("Pseudo album for %s: expected %d photos, got %s %v", user, count, rec.Body, err)
+		}
+	}
+
+	// Photos cannot be requested directly either
+	names := []string{"collection", "album", "photo", "file"}
+	if _, err := request(file, "bob", names, "Photos", "Favorites", "img_0001", "IMG_0001.jpg"); err == nil {
+		t.Error("File of a collection without access was served")
+	}
+	defer func(sizes []int) { config.previewSizes = sizes }(config.previewSizes)
+	config.previewSizes = []int{100}
+	if _, err := request(preview, "bob", names[:3], "Photos", "Favorites", "img_0001"); err == nil {
+		t.Error("Preview of a collection without access was served")
+	}
+	if _, err := request(similarPhotos, "bob", names[:3], "Photos", "Favorites", "img_0001"); err == nil || err.(*echo.HTTPError).Code != http.StatusNotFound {
+		t.Error("Similar photos of a collection without access were found")
+	}
+	if rec, err := request(file, "alice", names, "Photos", "Favorites", "img_0001", "IMG_0001.jpg"); err != nil || rec.Code != http.StatusOK {
+		t.Error("File not served", rec.Code, err)
+	}
+}
+
+func TestReadOnlyCollection(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	collection.ReadOnly = true
+	e := echo.New()
+
+	handlers := map[string]echo.HandlerFunc{
+		"addAlbum":     addAlbum,
+		"renameAlbum":  renameAlbum,
+		"deleteAlbum":  deleteAlbum,
+		"saveToPseudo": saveToPseudo,
+		"upload":       upload,
+		"movePhotos":   movePhotos,
+		"ratePhotos":   ratePhotos,
+		"trash":        trash,
+	}
+	for name, handler := range handlers {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"New","rating":3,"photos":["img_0001"]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetParamNames("collection", "album")
+		c.SetParamValues("Photos", "Album")
+		err := handler(c)
+		if httpErr, ok := err.(*echo.HTTPError); !ok || httpErr.Code != http.StatusForbidden {
+			t.Errorf("%s in read-only collection: expected 403, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(collection.PhotosPath, "Album")); err != nil {
+		t.Error("Album of read-only collection changed:", err)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/fs"
 	"log"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+
+	"golang.org/x/exp/slices"
 )
 
 type Album struct {
@@ -18,6 +23,8 @@ type Album struct {
 	SubAlbums []string          `json:"subalbums"`
 	Photos    []*Photo          `json:"photos"` // used only when marshaling
 	photosMap map[string]*Photo `json:"-"`      // actual place where photos are stored
+	// Held while photos or sub-albums are read or changed, since the album is shared once cached
+	mux sync.RWMutex
 }
 
 type PhotoFile struct {
@@ -25,7 +32,9 @@ type PhotoFile struct {
 	file    *File
 }
 
-func (album *Album) GetPhotos(collection *Collection, runningInBackground bool, photosToLoad ...PseudoAlbumEntry) error {
+func (album *Album) GetPhotos(ctx context.Context, collection *Collection, runningInBackground bool, photosToLoad ...PseudoAlbumEntry) error {
+	album.mux.Lock()
+	defer album.mux.Unlock()
 	subAlbums := make(map[string]bool)
 	album.photosMap = make(map[string]*Photo)
 
@@ -58,71 +67,43 @@ func (album *Album) GetPhotos(collection *Collection, runningInBackground bool,
 		log.Printf("Scanning folder for album %s[%s]...", collection.Name, album.Name)
 		var updatedPhotos = make(map[string]int)
 		var updatedFiles []PhotoFile
+		var sidecars []string
 		dir := filepath.Join(collection.PhotosPath, album.Name)
 		err := filepath.WalkDir(dir, func(fileDir string, file fs.DirEntry, err error) error {
 			// Iterate over folder items
 			if err != nil {
 				return err
 			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			// Skip folders
 			if file.IsDir() {
 				return nil
 			}
-			// Get parameters
-			name, ext := file.Name(), filepath.Ext(file.Name())
-			removedDir := strings.TrimPrefix(fileDir, dir+string(filepath.Separator))
-			fileId := strings.ToLower(strings.ReplaceAll(strings.TrimSuffix(removedDir, ext), string(filepath.Separator), "|"))
+			// Sidecars are not files of the photo, they are attached after all photos are found
+			if IsSidecarFile(fileDir) {
+				sidecars = append(sidecars, fileDir)
+				return nil
+			}
 
 			// Load only the selected photos
 			if len(photosToLoad) > 0 {
-				keyToFind := collection.Name + ":" + album.Name + ":" + fileId
+				keyToFind := collection.Name + ":" + album.Name + ":" + photoIdFromPath(dir, fileDir)
 				if _, found := photosToLoadMap[keyToFind]; !found {
 					return nil // Entry not found in the map, skip
 				}
 			}
 
-			photo, photoExists := album.photosMap[fileId]
-			if !photoExists {
-				title := strings.TrimSuffix(name, ext)
-				subAlbum := strings.TrimSuffix(strings.TrimSuffix(removedDir, name), string(filepath.Separator))
-				// Retrive photo info from cache if present
-				photo, err = collection.cache.GetPhotoInfo(album.Name, fileId)
-				if err != nil || photo == nil || photo.Id != fileId || photo.Title != title || photo.SubAlbum != subAlbum {
-					// Create a new photo for photos not in cache or outdated data
-					photo = &Photo{
-						Id:         fileId,
-						Title:      title,
-						Collection: collection.Name,
-						Album:      album.Name,
-						SubAlbum:   subAlbum,
-						Favorite:   []PseudoAlbum{},
-					}
-				}
-				album.photosMap[fileId] = photo
-				// Map of sub-albums
-				if subAlbum != "" {
-					subAlbums[subAlbum] = true
-				}
-			}
-
-			// Find inconsistent state: filter entries for the file but different path
-			// TODO: modify the structure to make this case inheritably impossible
-			for i := len(photo.Files) - 1; i >= 0; i-- {
-				if photo.Files[i].Id == name && photo.Files[i].Path != fileDir {
-					photo.Files = append(photo.Files[:i], photo.Files[i+1:]...) // Remove
-				}
+			photo, photoFile := album.addFile(collection, dir, fileDir)
+			// Map of sub-albums
+			if photo.SubAlbum != "" {
+				subAlbums[photo.SubAlbum] = true
 			}
-
-			photoFile, err := photo.GetFile(name)
-			if err != nil || photoFile == nil {
-				photoFile = &File{
-					Path: fileDir,
-					Id:   name,
-				}
-				photo.Files = append(photo.Files, photoFile)
+			if photoFile != nil {
 				// Add photo to the list of updated photos
-				updatedPhotos[fileId]++
-				updatedFiles = append(updatedFiles, PhotoFile{fileId, photoFile})
+				updatedPhotos[photo.Id]++
+				updatedFiles = append(updatedFiles, PhotoFile{photo.Id, photoFile})
 			}
 			return nil
 		})
@@ -130,24 +111,12 @@ func (album *Album) GetPhotos(collection *Collection, runningInBackground bool,
 			return err
 		}
 
+		updatedFiles = album.skipPairedFiles(updatedPhotos, updatedFiles)
+		album.attachSidecars(collection, dir, sidecars, updatedPhotos, true)
 		// Extract missing file info
-		processedFiles := AddExtractInfoWork(collection, album, runningInBackground, updatedFiles...)
-
-		// Determine photo info after processing all files
-		for photoId := range processedFiles {
-			updatedPhotos[photoId]--
-			if updatedPhotos[photoId] <= 0 { // Files for this photo were processed
-				photo := album.photosMap[photoId]
-				// Fill photo info
-				photo.FillInfo(collection)
-				// Update cache
-				collection.cache.AddPhotoInfo(photo)
-			}
-		}
-		if runningInBackground {
-			collection.cache.FinishFlush()
-		} else {
-			collection.cache.FlushInfo()
+		album.extractInfo(ctx, collection, runningInBackground, updatedPhotos, updatedFiles)
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 	}
 
@@ -160,16 +129,276 @@ func (album *Album) GetPhotos(collection *Collection, runningInBackground bool,
 	return nil
 }
 
+// Identifier of the photo for a file inside the album folder
+func photoIdFromPath(dir string, fileDir string) string {
+	removedDir := strings.TrimPrefix(fileDir, dir+string(filepath.Separator))
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSuffix(removedDir, filepath.Ext(fileDir)), string(filepath.Separator), "|"))
+}
+
+// Get or create the photo for a file inside the album folder, the file is not added to it
+func (album *Album) photoForFile(collection *Collection, dir string, fileDir string) *Photo {
+	// Get parameters
+	name, ext := filepath.Base(fileDir), filepath.Ext(fileDir)
+	removedDir := strings.TrimPrefix(fileDir, dir+string(filepath.Separator))
+	fileId := photoIdFromPath(dir, fileDir)
+
+	photo, photoExists := album.photosMap[fileId]
+	if !photoExists {
+		var err error
+		title := strings.TrimSuffix(name, ext)
+		subAlbum := strings.TrimSuffix(strings.TrimSuffix(removedDir, name), string(filepath.Separator))
+		// Retrive photo info from cache if present
+		photo, err = collection.cache.GetPhotoInfo(album.Name, fileId)
+		if err != nil || photo == nil || photo.Id != fileId || photo.Title != title || photo.SubAlbum != subAlbum {
+			// Create a new photo for photos not in cache or outdated data
+			photo = &Photo{
+				Id:         fileId,
+				Title:      title,
+				Collection: collection.Name,
+				Album:      album.Name,
+				SubAlbum:   subAlbum,
+				Favorite:   []PseudoAlbum{},
+			}
+		}
+		album.photosMap[fileId] = photo
+	}
+	return photo
+}
+
+// Get or create the photo for a file inside the album folder and add the file to it.
+// The file is returned only when it is new or changed, meaning that its info must be extracted.
+func (album *Album) addFile(collection *Collection, dir string, fileDir string) (*Photo, *File) {
+	name := filepath.Base(fileDir)
+	photo := album.photoForFile(collection, dir, fileDir)
+
+	// Find inconsistent state: filter entries for the file but different path
+	// TODO: modify the structure to make this case inheritably impossible
+	for i := len(photo.Files) - 1; i >= 0; i-- {
+		if photo.Files[i].Id == name && photo.Files[i].Path != fileDir {
+			photo.Files = append(photo.Files[:i], photo.Files[i+1:]...) // Remove
+		}
+	}
+
+	photoFile, err := photo.GetFile(name)
+	if err == nil && photoFile != nil && photoFile.outdated() {
+		// Info extracted by older versions (without modification time) or before the file was modified
+		if !photoFile.ModTime.IsZero() {
+			photo.RemoveThumbnails(collection)
+		}
+		for i, f := range photo.Files {
+			if f == photoFile {
+				photo.Files = append(photo.Files[:i], photo.Files[i+1:]...)
+				break
+			}
+		}
+		photoFile = nil
+	}
+	if err != nil || photoFile == nil {
+		photoFile = &File{
+			Path: fileDir,
+			Id:   name,
+		}
+		photo.Files = append(photo.Files, photoFile)
+		return photo, photoFile
+	}
+	return photo, nil
+}
+
+// Extract info of updated files and then update the info of their photos in cache
+func (album *Album) extractInfo(ctx context.Context, collection *Collection, runningInBackground bool, updatedPhotos map[string]int, updatedFiles []PhotoFile) {
+	processedFiles := AddExtractInfoWork(ctx, collection, album, runningInBackground, updatedFiles...)
+
+	// Determine photo info after processing all files
+	for photoId := range processedFiles {
+		updatedPhotos[photoId]--
+		if updatedPhotos[photoId] <= 0 { // Files for this photo were processed
+			photo := album.photosMap[photoId]
+			// Fill photo info
+			photo.FillInfo(collection)
+			// Update cache
+			collection.cache.AddPhotoInfo(photo)
+		}
+	}
+	// Identifiers of Live Photos are known only after extracting info
+	album.pairLivePhotos(collection)
+	if runningInBackground {
+		collection.cache.FinishFlush()
+	} else {
+		collection.cache.FlushInfo()
+	}
+}
+
+// Add files that were written to the album folder (e.g. uploaded) without scanning the whole album.
+// Info is extracted right away and the photos updated are returned.
+func (album *Album) AddFiles(collection *Collection, paths ...string) []*Photo {
+	album.mux.Lock()
+	defer album.mux.Unlock()
+	var photos []*Photo
+	var updatedPhotos = make(map[string]int)
+	var updatedFiles []PhotoFile
+	var sidecars []string
+	dir := filepath.Join(collection.PhotosPath, album.Name)
+
+	for _, path := range paths {
+		if IsSidecarFile(path) {
+			sidecars = append(sidecars, path)
+			continue
+		}
+		photo, photoFile := album.addFile(collection, dir, path)
+		if photoFile == nil {
+			continue // Already in the album
+		}
+		if updatedPhotos[photo.Id] == 0 {
+			photos = append(photos, photo)
+		}
+		updatedPhotos[photo.Id]++
+		updatedFiles = append(updatedFiles, PhotoFile{photo.Id, photoFile})
+		// New sub-album
+		if photo.SubAlbum != "" && !slices.Contains(album.SubAlbums, photo.SubAlbum) {
+			album.SubAlbums = append(album.SubAlbums, photo.SubAlbum)
+			sort.Strings(album.SubAlbums)
+		}
+	}
+
+	photos = append(photos, album.attachSidecars(collection, dir, sidecars, updatedPhotos, false)...)
+	album.extractInfo(context.Background(), collection, false, updatedPhotos, updatedFiles)
+	return photos
+}
+
+// Remove files (or folders) deleted from the album folder without scanning the whole album.
+// Photos left without files are removed with their thumbnails. Returns the photos updated.
+func (album *Album) RemoveFiles(collection *Collection, paths ...string) []*Photo {
+	album.mux.Lock()
+	defer album.mux.Unlock()
+	removed := func(path string) bool {
+		for _, p := range paths {
+			if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var updated []*Photo
+	for id, photo := range album.photosMap {
+		files := make([]*File, 0, len(photo.Files))
+		for _, file := range photo.Files {
+			if !removed(file.Path) {
+				files = append(files, file)
+			}
+		}
+		sidecarRemoved := photo.Sidecar != nil && removed(photo.Sidecar.Path)
+		if len(files) == len(photo.Files) && !sidecarRemoved {
+			continue // Not changed
+		}
+
+		if len(files) == 0 {
+			photo.RemoveThumbnails(collection)
+			delete(album.photosMap, id)
+			collection.cache.DeletePhotoInfo(photo)
+			continue
+		}
+		if len(files) < len(photo.Files) {
+			photo.RemoveThumbnails(collection) // Main file might have changed
+		}
+		photo.Files = files
+		if sidecarRemoved {
+			photo.Sidecar = nil
+		}
+		photo.FillInfo(collection)
+		updated = append(updated, photo)
+	}
+	if len(updated) > 0 {
+		collection.cache.AddPhotoInfo(updated...)
+	}
+	collection.cache.FlushInfo()
+
+	// Sub-albums without photos left
+	album.SubAlbums = slices.DeleteFunc(album.SubAlbums, func(subAlbum string) bool {
+		for _, photo := range album.photosMap {
+			if photo.SubAlbum == subAlbum {
+				return false
+			}
+		}
+		return true
+	})
+	return updated
+}
+
+// Attach the sidecars to their photos, named as the photo (IMG_1234.xmp) or as the file
+// (IMG_1234.CR2.xmp). Photos with changed sidecars are updated, unless their files are still
+// to be extracted. When scanning the whole album, sidecars not found are detached.
+// Returns the photos updated.
+func (album *Album) attachSidecars(collection *Collection, dir string, paths []string, updatedPhotos map[string]int, scanned bool) []*Photo {
+	found := make(map[string]*Sidecar)
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		id := photoIdFromPath(dir, path)
+		if _, ok := album.photosMap[id]; !ok {
+			id = photoIdFromPath(dir, strings.TrimSuffix(path, filepath.Ext(path)))
+		}
+		if _, ok := album.photosMap[id]; ok {
+			found[id] = &Sidecar{path, stat.ModTime()}
+		}
+	}
+
+	var changed []*Photo
+	for id, photo := range album.photosMap {
+		sidecar, ok := found[id]
+		if !ok && !scanned {
+			continue
+		}
+		if sidecar == nil && photo.Sidecar == nil ||
+			sidecar != nil && photo.Sidecar != nil && sidecar.Path == photo.Sidecar.Path && sidecar.ModTime.Equal(photo.Sidecar.ModTime) {
+			continue // Not changed
+		}
+		photo.Sidecar = sidecar
+		if updatedPhotos[id] == 0 {
+			photo.FillInfo(collection)
+			changed = append(changed, photo)
+		}
+	}
+	if len(changed) > 0 {
+		collection.cache.AddPhotoInfo(changed...)
+	}
+	return changed
+}
+
+// Remove the photo from the album, its files and cached info are handled by the caller
+func (album *Album) removePhoto(id string) {
+	album.mux.Lock()
+	delete(album.photosMap, id)
+	album.mux.Unlock()
+}
+
 func (album *Album) GetPhoto(photoName string) (photo *Photo, err error) {
+	album.mux.RLock()
 	photo, ok := album.photosMap[strings.ToLower(photoName)]
+	album.mux.RUnlock()
 	if !ok {
 		return nil, errors.New("photo not found in album: [" + album.Name + "] " + photoName)
 	}
 	return photo, nil
 }
 
+// Photos of the album, the list can be used while the album is changed
+func (album *Album) PhotoList() []*Photo {
+	album.mux.RLock()
+	defer album.mux.RUnlock()
+	photos := make([]*Photo, 0, len(album.photosMap))
+	for _, photo := range album.photosMap {
+		photos = append(photos, photo)
+	}
+	return photos
+}
+
 // Custom marshaler in order to transform photo map into a slice
-func (album Album) MarshalJSON() ([]byte, error) {
+func (album *Album) MarshalJSON() ([]byte, error) {
+	album.mux.RLock()
+	defer album.mux.RUnlock()
 	var photos []*Photo
 	// Convert map to slice, strip invalid photos
 	for _, photo := range album.photosMap {
@@ -188,11 +417,13 @@ func (album Album) MarshalJSON() ([]byte, error) {
 		return photos[i].Date.Sub(photos[j].Date) < 0
 	})
 
-	// Avoid cyclic marshaling
+	// Avoid cyclic marshaling, the album is not copied along with its lock
 	type AlbumAlias Album
-	alias := AlbumAlias(album)
-	alias.Photos = photos
-	alias.Count = len(photos)
+	alias := struct {
+		*AlbumAlias
+		Count  int      `json:"count"`
+		Photos []*Photo `json:"photos"`
+	}{(*AlbumAlias)(album), len(photos), photos}
 
 	// Marshal the preprocessed struct to JSON
 	return json.Marshal(alias)
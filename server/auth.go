@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const SessionCookieName = "pg-session"
+
+type User struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+	hash   []byte   // bcrypt hash of the password
+}
+
+// Users indexed by name, nil when authentication is disabled
+type Users map[string]*User
+
+type UserInfo struct {
+	Name        string `json:"name"`
+	AuthEnabled bool   `json:"auth"`
+}
+
+type LoginQuery struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type Session struct {
+	Token   string
+	User    *User
+	Expires time.Time
+}
+
+type SessionStore struct {
+	mux      sync.Mutex
+	sessions map[string]*Session
+	timeout  time.Duration
+}
+
+type userContextKey struct{}
+
+var (
+	sessions = SessionStore{sessions: make(map[string]*Session)}
+	// Used to compare passwords of unknown users, so it takes the same time as for known users
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+)
+
+// Load users from a file with the same format as htpasswd using bcrypt, one user per line
+// optionally followed by the list of groups the user belongs to:
+//
+//	username:$2y$10$...:group1,group2
+//
+// Such lines can be generated with: htpasswd -nB username
+func LoadUsers(filename string) (Users, error) {
+	if filename == "" {
+		return nil, nil // Authentication disabled
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(Users)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		// Skip empty lines and comments
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		split := strings.SplitN(line, ":", 3)
+		if len(split) < 2 || split[0] == "" {
+			return nil, fmt.Errorf("%s:%d: line must be formatted as username:hash[:groups]", filename, n)
+		}
+		if _, err := bcrypt.Cost([]byte(split[1])); err != nil {
+			return nil, fmt.Errorf("%s:%d: password of %s must be a bcrypt hash: %v", filename, n, split[0], err)
+		}
+		user := &User{Name: split[0], Groups: []string{}, hash: []byte(split[1])}
+		if len(split) == 3 && split[2] != "" {
+			user.Groups = strings.Split(split[2], ",")
+		}
+		users[user.Name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New("no users defined in " + filename)
+	}
+	return users, nil
+}
+
+// Generate a bcrypt hash to be used in the users file
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Validate user credentials, returns the user if they are valid
+func (users Users) Authenticate(username string, password string) (*User, error) {
+	user, ok := users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errors.New("invalid username or password")
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil, errors.New("invalid username or password")
+	}
+	return user, nil
+}
+
+// Create a new session for the user
+func (s *SessionStore) Create(user *User) (*Session, error) {
+	token, err := randomId(32)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Token:   token,
+		User:    user,
+		Expires: time.Now().Add(s.timeout),
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	// Drop expired sessions
+	for token, old := range s.sessions {
+		if time.Now().After(old.Expires) {
+			delete(s.sessions, token)
+		}
+	}
+	s.sessions[session.Token] = session
+	return session, nil
+}
+
+// Find a valid session by its token
+func (s *SessionStore) Get(token string) (*Session, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	session, ok := s.sessions[token]
+	if ok && time.Now().After(session.Expires) {
+		delete(s.sessions, token)
+		return nil, false
+	}
+	return session, ok
+}
+
+func (s *SessionStore) Delete(token string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.sessions, token)
+}
+
+// Find the session token in the cookie or in the Authorization header (Bearer)
+func sessionToken(r *http.Request) string {
+	if auth := r.Header.Get(echo.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// Identify the user making the request, either by a session or HTTP Basic credentials
+func (users Users) AuthenticateRequest(r *http.Request) (*User, error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		return users.Authenticate(username, password)
+	}
+	if token := sessionToken(r); token != "" {
+		if session, ok := sessions.Get(token); ok {
+			return session.User, nil
+		}
+		return nil, errors.New("session is invalid or has expired")
+	}
+	return nil, errors.New("authentication required")
+}
+
+// Get the user authenticated in the context, nil if authentication is disabled
+func CurrentUser(c echo.Context) *User {
+	user, _ := c.Get("user").(*User)
+	return user
+}
+
+// Get the user authenticated for the request (used by WebDAV)
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey{}).(*User)
+	return user
+}
+
+// Middleware that rejects requests without a valid user, except for the paths in skip
+func Authentication(users Users, skip ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// Authentication disabled
+			if users == nil {
+				return next(c)
+			}
+			for _, p := range skip {
+				if c.Path() == p {
+					return next(c)
+				}
+			}
+			user, err := users.AuthenticateRequest(c.Request())
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			c.Set("user", user)
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), userContextKey{}, user)))
+			return next(c)
+		}
+	}
+}
+
+func login(c echo.Context) error {
+	var query LoginQuery
+
+	if config.users == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "authentication is disabled")
+	}
+	// Decode body
+	if err := c.Bind(&query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := config.users.Authenticate(query.Username, query.Password)
+	if err != nil {
+		log.Printf("Failed login for %s (%s)", query.Username, c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	session, err := sessions.Create(user)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.Expires,
+		Secure:   c.IsTLS(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.JSON(http.StatusOK, map[string]string{"token": session.Token, "name": user.Name})
+}
+
+func logout(c echo.Context) error {
+	if token := sessionToken(c.Request()); token != "" {
+		sessions.Delete(token)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return c.JSON(http.StatusOK, map[string]bool{"ok": true})
+}
+
+func currentUser(c echo.Context) error {
+	info := UserInfo{AuthEnabled: config.users != nil}
+	if user := CurrentUser(c); user != nil {
+		info.Name = user.Name
+	}
+	return c.JSON(http.StatusOK, info)
+}
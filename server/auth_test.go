@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateUsers(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "users")
+	os.WriteFile(filename, []byte("# Users\nalice:"+hash+"\n\n"), 0600)
+
+	users, err := LoadUsers(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Authenticate("alice", "secret"); err != nil {
+		t.Errorf("Valid credentials rejected: %s", err)
+	}
+	if _, err := users.Authenticate("alice", "wrong"); err == nil {
+		t.Error("Invalid password accepted")
+	}
+	if _, err := users.Authenticate("bob", "secret"); err == nil {
+		t.Error("Unknown user accepted")
+	}
+
+	// Sessions
+	sessions.timeout = -time.Second
+	session, err := sessions.Create(users["alice"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := sessions.Get(session.Token); ok {
+		t.Error("Expired session returned", s)
+	}
+	sessions.timeout = time.Hour
+	session, _ = sessions.Create(users["alice"])
+	if s, ok := sessions.Get(session.Token); !ok || s.User.Name != "alice" {
+		t.Error("Session not found")
+	}
+	sessions.Delete(session.Token)
+	if _, ok := sessions.Get(session.Token); ok {
+		t.Error("Session not deleted")
+	}
+}
+
+func TestLoadUsersInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users")
+	os.WriteFile(filename, []byte("alice:plaintext\n"), 0600)
+	if _, err := LoadUsers(filename); err == nil {
+		t.Error("Passwords not hashed with bcrypt must be rejected")
+	}
+	if users, err := LoadUsers(""); users != nil || err != nil {
+		t.Error("Authentication must be disabled without users file")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -13,7 +14,19 @@ import (
 )
 
 var dbInfo = DbInfo{
-	Version: 10,
+	Version: 11,
+}
+
+// Upgrades of the DB, by the version they upgrade from
+var dbMigrations = map[int]func(tx *bolt.Tx) error{
+	// New info of files (e.g. modification time, metadata, RAW previews, Live Photos and sidecars)
+	// is only extracted when scanned, albums are scanned again to update the files without it
+	10: func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte("AlbumSaved")); err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	},
 }
 
 type DbInfo struct {
@@ -26,9 +39,14 @@ type Cache struct {
 	albums    *sync.Map
 	mem       gcache.Cache
 	store     *bolthold.Store
+	muxStore  sync.RWMutex // Held while the store is used, it is replaced when compacted
 	addInfoCh chan *Photo
 	delInfoCh chan *Photo
 	wgFlush   sync.WaitGroup
+	// Photos sorted by date, built when requested
+	timeline           []TimelineEntry
+	timelineCollection string
+	muxTimeline        sync.Mutex
 }
 
 // Flag album as fully scanned
@@ -67,6 +85,13 @@ func (c *Cache) Init(collection *Collection, rebuildCache bool) (err error) {
 	// Check DB version
 	var current DbInfo
 	err = c.store.Get("DbInfo", &current)
+	if err == nil && current.Version != dbInfo.Version && !rebuildCache {
+		err = c.migrate(current.Version)
+		if err == nil {
+			log.Printf("Cache DB for collection %s upgraded from v%d to v%d", collection.Name, current.Version, dbInfo.Version)
+			current = dbInfo
+		}
+	}
 	if err != nil || current.Version != dbInfo.Version {
 		log.Printf("Current DB version v%d is different than required v%d\n", current.Version, dbInfo.Version)
 		if rebuildCache || err == bolthold.ErrNotFound {
@@ -79,6 +104,8 @@ func (c *Cache) Init(collection *Collection, rebuildCache bool) (err error) {
 				tx.DeleteBucket([]byte("_index:Photo:Date"))
 				tx.DeleteBucket([]byte("_index:Photo:Location"))
 				tx.DeleteBucket([]byte("_index:Photo:Size"))
+				tx.DeleteBucket([]byte(GeoIndexBucket))
+				tx.DeleteBucket([]byte(GeoIndexKeysBucket))
 				return c.store.TxInsert(tx, "DbInfo", dbInfo)
 			})
 			if err != nil {
@@ -90,6 +117,11 @@ func (c *Cache) Init(collection *Collection, rebuildCache bool) (err error) {
 		}
 	}
 
+	// Spatial index of photos, created if missing
+	if err = c.initGeoIndex(); err != nil {
+		return
+	}
+
 	// In-memory cache gcache
 	c.mem = gcache.New(50).ARC().Build()
 
@@ -104,10 +136,85 @@ func (c *Cache) Init(collection *Collection, rebuildCache bool) (err error) {
 	return nil
 }
 
+// Upgrade the DB from the version, all migrations are applied at once or none
+func (c *Cache) migrate(version int) error {
+	return c.store.Bolt().Update(func(tx *bolt.Tx) error {
+		for ; version < dbInfo.Version; version++ {
+			migration, ok := dbMigrations[version]
+			if !ok {
+				return fmt.Errorf("cannot upgrade cache DB from v%d", version)
+			}
+			if err := migration(tx); err != nil {
+				return err
+			}
+		}
+		return c.store.TxUpsert(tx, "DbInfo", dbInfo)
+	})
+}
+
+// Use the DB where the info is stored, it is not replaced (e.g. compacted) meanwhile.
+// Other methods of the cache using the DB must not be called from fn.
+func (c *Cache) WithStore(fn func(store *bolthold.Store) error) error {
+	c.muxStore.RLock()
+	defer c.muxStore.RUnlock()
+	return fn(c.store)
+}
+
+// Rewrite the DB to reclaim the space of deleted entries, returns the size before and after.
+// The DB is replaced by the compacted copy, any use of the DB waits until it is done.
+func (c *Cache) Compact() (before int64, after int64, err error) {
+	c.FinishFlush()
+	c.muxStore.Lock()
+	defer c.muxStore.Unlock()
+	path := c.store.Bolt().Path()
+	tmp := path + ".compact"
+	os.Remove(tmp)
+
+	c.store.Bolt().View(func(tx *bolt.Tx) error {
+		before = tx.Size()
+		return nil
+	})
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: 1})
+	if err != nil {
+		return before, before, err
+	}
+	err = bolt.Compact(dst, c.store.Bolt(), 64<<20)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return before, before, err
+	}
+
+	// Nobody is using the DB, it can be closed and replaced
+	if err = c.store.Close(); err != nil {
+		os.Remove(tmp)
+		return before, before, err
+	}
+	renameErr := os.Rename(tmp, path)
+	store, err := bolthold.Open(path, 0600, &bolthold.Options{Options: &bolt.Options{Timeout: 1}})
+	if err != nil {
+		return before, before, err
+	}
+	c.store = store
+	if renameErr != nil {
+		os.Remove(tmp)
+		return before, before, renameErr
+	}
+	store.Bolt().View(func(tx *bolt.Tx) error {
+		after = tx.Size()
+		return nil
+	})
+	return before, after, nil
+}
+
 // Release all caching resources
 func (c *Cache) End() error {
 	c.FinishFlush()
 	c.mem.Purge()
+	c.muxStore.Lock()
+	defer c.muxStore.Unlock()
 	return c.store.Close()
 }
 
@@ -160,36 +267,119 @@ func (c *Cache) SaveAlbum(album *Album) error {
 	// Cache album in memory
 	return c.mem.Set(album.Name, album)
 }
+func (c *Cache) RemoveAlbum(albumName string) bool {
+	// Album will be loaded again when requested
+	return c.mem.Remove(albumName)
+}
 
 // Album Fully Scanned
 
 func (c *Cache) SetAlbumFullyScanned(album *Album) error {
-	return c.store.Upsert(album.Name, AlbumSaved{})
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Upsert(album.Name, AlbumSaved{})
+	})
 }
 func (c *Cache) IsAlbumFullyScanned(album *Album) bool {
 	var a AlbumSaved
-	return c.store.Get(album.Name, &a) == nil
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Get(album.Name, &a)
+	}) == nil
 }
 func (c *Cache) UnsetAlbumFullyScanned(albumName string) error {
-	return c.store.Delete(albumName, AlbumSaved{})
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Delete(albumName, AlbumSaved{})
+	})
 }
 func (c *Cache) ResetAlbumsFullyScanned() error {
-	return c.store.DeleteMatching(AlbumSaved{}, nil) // Delete all
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.DeleteMatching(AlbumSaved{}, nil) // Delete all
+	})
 }
 
 // Album Thumbnail queue
 
 func (c *Cache) SetAlbumToThumbQueue(album string) error {
-	return c.store.Upsert(album, AlbumThumbs{album})
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Upsert(album, AlbumThumbs{album})
+	})
 }
 func (c *Cache) TxSetAlbumToThumbQueue(tx *bolt.Tx, album string) error {
-	return c.store.TxUpsert(tx, album, AlbumThumbs{album})
+	return c.store.TxUpsert(tx, album, AlbumThumbs{album}) // Store in use by the transaction
 }
 func (c *Cache) UnsetAlbumFromThumbQueue(albumName string) bool {
-	return c.store.Delete(albumName, AlbumThumbs{}) == nil
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Delete(albumName, AlbumThumbs{})
+	}) == nil
 }
 func (c *Cache) ResetAlbumsInThumbQueue() error {
-	return c.store.DeleteMatching(AlbumThumbs{}, nil) // Delete all
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.DeleteMatching(AlbumThumbs{}, nil) // Delete all
+	})
+}
+
+// Scheduled jobs
+
+func (c *Cache) GetJobRun(job string) (*JobRun, error) {
+	var run JobRun
+	err := c.WithStore(func(store *bolthold.Store) error {
+		return store.Get(job, &run)
+	})
+	return &run, err
+}
+func (c *Cache) SaveJobRun(run *JobRun) error {
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Upsert(run.Job, run)
+	})
+}
+
+// Move the cached info of the album to a new name. Update is called for each
+// photo before being saved, so the remaining fields can be changed as well.
+func (c *Cache) RenameAlbumInfo(oldName string, newName string, update func(photo *Photo)) error {
+	// Pending changes must be saved first
+	c.FinishFlush()
+	defer c.ResetTimeline()
+	return c.WithStore(func(store *bolthold.Store) error {
+		return store.Bolt().Update(func(tx *bolt.Tx) error {
+			var photos []*Photo
+			err := store.TxFind(tx, &photos, bolthold.Where("Album").Eq(oldName).Index("Album"))
+			if err != nil {
+				return err
+			}
+			for _, photo := range photos {
+				if err := store.TxDelete(tx, photo.Key(), photo); err != nil {
+					return err
+				}
+				if err := c.txUpdateGeoIndex(tx, photo, true); err != nil {
+					return err
+				}
+				update(photo)
+				photo.Album = newName
+				if err := store.TxUpsert(tx, photo.Key(), photo); err != nil {
+					return err
+				}
+				if err := c.txUpdateGeoIndex(tx, photo, false); err != nil {
+					return err
+				}
+			}
+
+			// Flags of the album
+			var saved AlbumSaved
+			if store.TxGet(tx, oldName, &saved) == nil {
+				store.TxDelete(tx, oldName, AlbumSaved{})
+				if err := store.TxUpsert(tx, newName, AlbumSaved{}); err != nil {
+					return err
+				}
+			}
+			var thumbs AlbumThumbs
+			if store.TxGet(tx, oldName, &thumbs) == nil {
+				store.TxDelete(tx, oldName, AlbumThumbs{})
+				if err := c.TxSetAlbumToThumbQueue(tx, newName); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
 }
 
 // Cache data
@@ -205,7 +395,9 @@ func PhotoKey(album string, id string) string {
 // Fill photos with info in cache (e.g. height and width)
 func (c *Cache) GetPhotoInfo(album string, id string) (*Photo, error) {
 	var photo Photo
-	err := c.store.Get(PhotoKey(album, id), &photo)
+	err := c.WithStore(func(store *bolthold.Store) error {
+		return store.Get(PhotoKey(album, id), &photo)
+	})
 	return &photo, err
 }
 
@@ -215,27 +407,36 @@ func (c *Cache) addInfoBatcher() {
 	go func() {
 		for batch := range batches {
 			log.Printf("Updating cache info (%d items)", len(batch))
+			var saved []*Photo
 			c.wgFlush.Add(1)
-			c.store.Bolt().Update(func(tx *bolt.Tx) error {
-				// Add or update info for photos
-				for _, photo := range batch {
-					err := c.store.TxUpsert(tx, photo.Key(), photo)
-					if err != nil {
-						log.Println(err)
-					}
-
-					// Add album to the thumbnail queue
-					if !photo.HasThumb {
-						err = c.TxSetAlbumToThumbQueue(tx, photo.Album)
+			c.WithStore(func(store *bolthold.Store) error {
+				return store.Bolt().Update(func(tx *bolt.Tx) error {
+					// Add or update info for photos
+					for _, photo := range batch {
+						err := store.TxUpsert(tx, photo.Key(), photo)
 						if err != nil {
 							log.Println(err)
+						} else {
+							saved = append(saved, photo)
+						}
+						if err = c.txUpdateGeoIndex(tx, photo, false); err != nil {
+							log.Println(err)
 						}
-					}
 
-					c.wgFlush.Done()
-				}
-				return nil
+						// Add album to the thumbnail queue
+						if !photo.HasThumb {
+							err = c.TxSetAlbumToThumbQueue(tx, photo.Album)
+							if err != nil {
+								log.Println(err)
+							}
+						}
+
+						c.wgFlush.Done()
+					}
+					return nil
+				})
 			})
+			c.updateTimeline(saved, false)
 			c.wgFlush.Done()
 		}
 	}()
@@ -247,19 +448,28 @@ func (c *Cache) delInfoBatcher() {
 	go func() {
 		for batch := range batches {
 			log.Printf("Deleting cache info (%d items)", len(batch))
+			var deleted []*Photo
 			c.wgFlush.Add(1)
-			c.store.Bolt().Update(func(tx *bolt.Tx) error {
-				// Delete photo info
-				for _, photo := range batch {
-					// Delete entry
-					err := c.store.TxDelete(tx, photo.Key(), photo)
-					if err != nil {
-						log.Println(err)
+			c.WithStore(func(store *bolthold.Store) error {
+				return store.Bolt().Update(func(tx *bolt.Tx) error {
+					// Delete photo info
+					for _, photo := range batch {
+						// Delete entry
+						err := store.TxDelete(tx, photo.Key(), photo)
+						if err != nil {
+							log.Println(err)
+						} else {
+							deleted = append(deleted, photo)
+						}
+						if err = c.txUpdateGeoIndex(tx, photo, true); err != nil {
+							log.Println(err)
+						}
+						c.wgFlush.Done()
 					}
-					c.wgFlush.Done()
-				}
-				return nil
+					return nil
+				})
 			})
+			c.updateTimeline(deleted, true)
 			c.wgFlush.Done()
 		}
 	}()
@@ -281,7 +491,8 @@ func (c *Cache) FinishFlush() {
 func (c *Cache) AddPhotoInfo(photos ...*Photo) {
 	c.wgFlush.Add(len(photos))
 	for _, photo := range photos {
-		c.addInfoCh <- photo
+		// Stored later in batches, meanwhile the photo can be changed
+		c.addInfoCh <- photo.snapshot()
 	}
 }
 
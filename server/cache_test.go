@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/disintegration/imaging"
 	"github.com/timshannon/bolthold"
 )
 
@@ -40,3 +43,60 @@ func TestPrintPhotoInfoEntries(t *testing.T) {
 		return nil
 	})
 }
+
+func TestCacheMigration(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	if err := imaging.Save(gradient(300, 200, false), filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	album, err := collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection.cache.FinishFlush()
+
+	// Info of files stored by the previous version, without modification time
+	photo, err := collection.cache.GetPhotoInfo("Album", "img_0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo.Files[0].ModTime = time.Time{}
+	photo.Files[0].Width = 0
+	collection.cache.store.Upsert(photo.Key(), photo)
+	collection.cache.store.Upsert("DbInfo", DbInfo{Version: 10})
+	collection.cache.End()
+
+	if err := collection.cache.Init(collection, false); err != nil {
+		t.Fatal("Cache DB not upgraded:", err)
+	}
+	var current DbInfo
+	if collection.cache.store.Get("DbInfo", &current); current.Version != dbInfo.Version {
+		t.Error("Version not updated:", current.Version)
+	}
+	if collection.cache.IsAlbumFullyScanned(album) {
+		t.Error("Album not scanned again after upgrade")
+	}
+	// Outdated files have their info extracted again
+	album, err = collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err = album.GetPhoto("img_0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photo.Files) != 1 || photo.Files[0].ModTime.IsZero() || photo.Files[0].Width != 300 {
+		t.Error("Info of outdated file not extracted again:", photo.Files)
+	}
+
+	// Versions without upgrades must be recreated
+	collection.cache.store.Upsert("DbInfo", DbInfo{Version: 5})
+	collection.cache.End()
+	if err := collection.cache.Init(collection, false); err == nil {
+		t.Error("Cache DB upgraded from unsupported version")
+	}
+	collection.cache.store.Close()
+	if err := collection.cache.Init(collection, true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"log"
+	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zulucmd/zflag"
 )
@@ -15,11 +20,20 @@ type CmdArgs struct {
 	cacheThumbnails bool
 	disableScan     bool
 	fullScan        bool
+	watch           bool
 	recreateCacheDB bool
 	webdavDisabled  bool
 	debug           bool
 	thumbsPath      string
+	usersFile       string
+	sessionTimeout  time.Duration
+	trashRetention  time.Duration
+	previewSizes    []int
+	convertOnScan   bool
+	convertLimit    int64
+	transcodeHEVC   bool
 	collections     map[string]*Collection
+	users           Users
 	port            int
 	host            string
 	nWorkersInfo    int
@@ -42,7 +56,7 @@ func parseCollectionOptions(collectionOption string, index int, defaultThumbsPat
 	for _, pair := range ss {
 		kv := strings.SplitN(pair, "=", 2)
 		if len(kv) != 2 {
-			log.Printf("%s must be formatted as key=value\n", pair)
+			return nil, errors.New(pair + " must be formatted as key=value, values with commas must be quoted")
 		}
 		switch kv[0] {
 		case "name":
@@ -63,6 +77,16 @@ func parseCollectionOptions(collectionOption string, index int, defaultThumbsPat
 			if err != nil {
 				return
 			}
+		case "acl":
+			collection.ACL, err = ParseACL(kv[1])
+			if err != nil {
+				return
+			}
+		case "schedule":
+			collection.Schedule, err = ParseSchedule(kv[1])
+			if err != nil {
+				return
+			}
 		case "hide":
 			collection.Hide, err = strconv.ParseBool(kv[1])
 			if err != nil {
@@ -83,6 +107,7 @@ func parseCollectionOptions(collectionOption string, index int, defaultThumbsPat
 
 func ParseCmdArgs() (cmdArgs CmdArgs) {
 	var collectionArgs []string
+	var convertCacheMB int64
 	zflag.StringSliceVar(&collectionArgs, "collection", collectionArgs, `Define a new collection. The order used will will be the same used in the interface.
 Example: -c name=Photos,path=/photos,thumbs=/tmp
 List of possible options:
@@ -92,20 +117,66 @@ List of possible options:
   db             Path to cache DB, if a filename is provided it will be located in thumbnails directory
   hide=false     Hide the collection from the list (does not affect webdav)
   rename=true    Rename files instead of overwriting them
-  readonly=false`, zflag.OptShorthand('c'))
+  readonly=false Do not allow changes to the collection (upload, edit albums, etc.)
+  acl            Access for each user (@ for groups, * for anyone), e.g. acl=alice:admin;@family:read
+                 Levels: none, read, write or admin. By default everyone has full access
+  schedule       Jobs to run periodically with cron expressions, e.g. schedule=full-scan:0 3 * * 0;compact-db:@monthly
+                 Jobs: quick-scan, full-scan, cleanup-thumbs, create-thumbs, duplicates or compact-db
+Options with commas (e.g. lists in cron expressions) must be quoted as in CSV:
+  -c 'name=Photos,path=/photos,"schedule=full-scan:0 3 * * 1,3"'`, zflag.OptShorthand('c'))
 	zflag.BoolVar(&cmdArgs.cacheThumbnails, "cache-thumbnails", true, "Generate missing thumbnails while scanning", zflag.OptAddNegative(), zflag.OptShorthand('b'))
 	zflag.BoolVar(&cmdArgs.disableScan, "disable-scan", false, "Disable scans on start, by default will run a quick scan (cache info of new albums)")
+	zflag.BoolVar(&cmdArgs.convertOnScan, "convert-on-scan", false, "Convert files not supported by browsers (e.g. HEIC or AVI videos) while scanning, by default they are converted when requested")
+	zflag.BoolVar(&cmdArgs.transcodeHEVC, "transcode-hevc", true, "Transcode HEVC videos to H.264, HEVC is not supported by all browsers", zflag.OptAddNegative())
+	zflag.Int64Var(&convertCacheMB, "convert-cache-size", 2048, "Maximum size in MB of converted files kept for each collection (0 is unlimited)")
 	zflag.BoolVar(&cmdArgs.fullScan, "full-scan", false, "Perform a full scan on start (validates if all cached data is up to date)")
+	zflag.BoolVar(&cmdArgs.watch, "watch", true, "Watch collections for changes made outside of the application and update the cache", zflag.OptAddNegative())
 	zflag.BoolVar(&cmdArgs.recreateCacheDB, "recreate-cache", false, "Recreate cache DB, required after DB version upgrade", zflag.OptShorthand('r'))
 	zflag.BoolVar(&cmdArgs.webdavDisabled, "disable-webdav", false, "Disable WebDAV")
 	zflag.BoolVar(&cmdArgs.debug, "debug", false, "Enable debug")
 	zflag.StringVar(&cmdArgs.thumbsPath, "thumbs", "", "Default path to store thumbnails", zflag.OptShorthand('t'))
+	zflag.StringVar(&cmdArgs.usersFile, "users", "", "File with users allowed to login, formatted as username:bcrypt-hash per line (authentication is disabled if not set)", zflag.OptShorthand('u'))
+	zflag.DurationVar(&cmdArgs.sessionTimeout, "session-timeout", 30*24*time.Hour, "Time until a login session expires")
+	zflag.DurationVar(&cmdArgs.trashRetention, "trash-retention", 30*24*time.Hour, "Time to keep deleted photos in the trash before they are permanently deleted (0 keeps them forever)")
+	hashPassword := zflag.Bool("hash-password", false, "Read a password from stdin and print its hash to be used in the users file")
 	zflag.StringVar(&cmdArgs.host, "host", "localhost", "Specify a host", zflag.OptShorthand('H'))
 	zflag.IntVar(&cmdArgs.port, "port", 3080, "Specify a port", zflag.OptShorthand('p'))
+	zflag.IntSliceVar(&cmdArgs.previewSizes, "preview-sizes", PreviewDefaultSizes, "Sizes in pixels of the previews served to fit the screen, generated when requested")
 	zflag.IntVar(&cmdArgs.nWorkersInfo, "workers-info", 2, "Number of concurrent workers to extract photos info")
 	zflag.IntVar(&cmdArgs.nWorkersThumb, "workers-thumb", runtime.NumCPU(), "Number of concurrent workers to generate thumbnails, by default number of CPUs")
 	zflag.Parse()
 
+	if *hashPassword {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Fatal(err)
+		}
+		hash, err := HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hash)
+		os.Exit(0)
+	}
+
+	if len(cmdArgs.previewSizes) == 0 {
+		log.Fatal("at least one preview size is required")
+	}
+	for _, size := range cmdArgs.previewSizes {
+		if size < 1 {
+			log.Fatal("preview sizes must be positive, found ", size)
+		}
+	}
+	sort.Ints(cmdArgs.previewSizes)
+	cmdArgs.convertLimit = convertCacheMB * 1024 * 1024
+
+	users, err := LoadUsers(cmdArgs.usersFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmdArgs.users = users
+	sessions.timeout = cmdArgs.sessionTimeout
+
 	cmdArgs.collections = make(map[string]*Collection)
 	for i, c := range collectionArgs {
 		collection, err := parseCollectionOptions(c, i, cmdArgs.thumbsPath)
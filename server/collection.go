@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -8,6 +9,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -24,14 +26,19 @@ type Collection struct {
 	Hide            bool
 	ReadOnly        bool
 	RenameOnReplace bool
+	ACL             ACL
+	Schedule        Schedule
 	cache           Cache
 	muxAlbumMap     sync.Mutex
 	muxsAlbums      map[string]*sync.Mutex
+	watcher         *Watcher
 }
 
 type CollectionInfo struct {
-	Name    string            `json:"name"`
-	Storage CollectionStorage `json:"storage"`
+	Name     string            `json:"name"`
+	Access   string            `json:"access"`
+	ReadOnly bool              `json:"readonly"`
+	Storage  CollectionStorage `json:"storage"`
 }
 type CollectionStorage struct {
 	Size       string `json:"size"`
@@ -40,27 +47,40 @@ type CollectionStorage struct {
 	Percentage int    `json:"percentage"`
 }
 
+var ErrReadOnly = errors.New("collection is read-only")
+
 type AddAlbumQuery struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
 }
 
+type RenameAlbumQuery struct {
+	Name string `json:"name"`
+}
+
 func NewCollection() *Collection {
 	return &Collection{
 		muxsAlbums: make(map[string]*sync.Mutex),
 	}
 }
 
-// List all collections
-func GetCollections(collections map[string]*Collection) []CollectionInfo {
-	list := make([]CollectionInfo, len(collections))
-
+// List all collections visible for the user
+func GetCollections(collections map[string]*Collection, user *User) []CollectionInfo {
+	visible := make([]*Collection, 0, len(collections))
 	for _, c := range collections {
-		if !c.Hide {
-			list[c.Index] = c.Info()
+		if !c.Hide && c.Allows(user, AccessRead) {
+			visible = append(visible, c)
 		}
 	}
+	// Keep the same order as they were defined
+	sort.Slice(visible, func(i, j int) bool {
+		return visible[i].Index < visible[j].Index
+	})
 
+	list := make([]CollectionInfo, len(visible))
+	for i, c := range visible {
+		list[i] = c.Info(user)
+	}
 	return list
 }
 
@@ -69,13 +89,21 @@ func (c *Collection) String() string {
 	return fmt.Sprintf("%s (%s)", c.Name, c.PhotosPath)
 }
 
-// Info about the collection
-func (c *Collection) Info() CollectionInfo {
+// Info about the collection as seen by the user
+func (c *Collection) Info(user *User) CollectionInfo {
 	st, err := c.StorageUsage()
 	if err != nil {
 		log.Println("Cannot retrieve storage usage for " + c.Name + ": " + err.Error())
 	}
-	return CollectionInfo{Name: c.Name, Storage: st}
+	return CollectionInfo{Name: c.Name, Access: c.Access(user).String(), ReadOnly: c.ReadOnly, Storage: st}
+}
+
+// Guard for every operation that modifies the collection
+func (c *Collection) CheckWritable() error {
+	if c.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
 }
 
 // Lists all albums, however photos are not loaded together.
@@ -156,6 +184,11 @@ func readAlbum(file fs.FileInfo) (*Album, error) {
 
 // Get album with photos
 func (c *Collection) GetAlbumWithPhotos(albumName string, forceUpdate bool, runningInBackground bool, photosToLoad ...PseudoAlbumEntry) (*Album, error) {
+	return c.GetAlbumWithPhotosContext(context.Background(), albumName, forceUpdate, runningInBackground, photosToLoad...)
+}
+
+// Same as GetAlbumWithPhotos, the scan stops when the context is cancelled (the album is then not cached)
+func (c *Collection) GetAlbumWithPhotosContext(ctx context.Context, albumName string, forceUpdate bool, runningInBackground bool, photosToLoad ...PseudoAlbumEntry) (*Album, error) {
 	if !forceUpdate {
 		// Check if album is in cache
 		cachedAlbum, err := c.cache.GetAlbum(albumName)
@@ -185,7 +218,9 @@ func (c *Collection) GetAlbumWithPhotos(albumName string, forceUpdate bool, runn
 	}
 
 	// Get photos from the disk
-	album.GetPhotos(c, runningInBackground, photosToLoad...)
+	if err := album.GetPhotos(ctx, c, runningInBackground, photosToLoad...); err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	// ...and save to cache
 	c.cache.SaveAlbum(album)
 	// Set album as fully scanned
@@ -197,6 +232,9 @@ func (c *Collection) GetAlbumWithPhotos(albumName string, forceUpdate bool, runn
 }
 
 func (c *Collection) AddAlbum(info AddAlbumQuery) error {
+	if err := c.CheckWritable(); err != nil {
+		return err
+	}
 	name := info.Name
 	if info.Type == "pseudo" {
 		name += PSEUDO_ALBUM_EXT
@@ -232,6 +270,155 @@ func (c *Collection) AddAlbum(info AddAlbumQuery) error {
 	return nil
 }
 
+// Album names are used as folder names and in references of pseudo albums
+func validAlbumName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, ":/\\") {
+		return errors.New("invalid album name: " + name)
+	}
+	return nil
+}
+
+// Location of the album in the disk, a folder or a file for pseudo albums
+func (c *Collection) albumPath(album *Album) string {
+	if album.IsPseudo {
+		return filepath.Join(c.PhotosPath, album.Name+PSEUDO_ALBUM_EXT)
+	}
+	return filepath.Join(c.PhotosPath, album.Name)
+}
+
+// Rename the album updating cached info, thumbnails and references in pseudo albums
+func (c *Collection) RenameAlbum(album *Album, newName string) error {
+	if err := c.CheckWritable(); err != nil {
+		return err
+	}
+	if err := validAlbumName(newName); err != nil {
+		return err
+	}
+	oldName := album.Name
+	renamed := &Album{Name: newName, IsPseudo: album.IsPseudo}
+	oldPath, newPath := c.albumPath(album), c.albumPath(renamed)
+	if c.IsAlbum(newName) {
+		return errors.New("album already exists: " + newName)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		return errors.New("album already exists: " + newName)
+	}
+
+	// Photos referenced by the pseudo album keep a link to it
+	var entries []PseudoAlbumEntry
+	if album.IsPseudo {
+		var err error
+		if entries, err = readPseudoAlbum(c, album); err != nil {
+			return err
+		}
+		album.GetPhotosForPseudo(c, false, false, entries...)
+	}
+
+	unlock := lockAlbums(c, oldName, c, newName)
+	err := os.Rename(oldPath, newPath)
+	if err == nil && !album.IsPseudo {
+		// Photos are keyed by album and thumbnails and previews are located according to it
+		err = c.cache.RenameAlbumInfo(oldName, newName, func(photo *Photo) {
+			moved := &Photo{Id: photo.Id, Album: newName}
+			if hasThumb, thumbPath := photo.ThumbnailPresent(c); hasThumb {
+				newThumbPath := moved.ThumbnailPath(c)
+				if err := os.MkdirAll(filepath.Dir(newThumbPath), os.ModePerm); err != nil {
+					log.Println("Thumbnail not moved:", err)
+				} else if err := moveFile(thumbPath, newThumbPath); err != nil {
+					log.Println("Thumbnail not moved:", err)
+				}
+			}
+			photo.MovePreviews(c, moved)
+			for _, file := range photo.Files {
+				if rel, err := filepath.Rel(oldPath, file.Path); err == nil {
+					file.Path = filepath.Join(newPath, rel)
+				}
+			}
+			if photo.Sidecar != nil {
+				if rel, err := filepath.Rel(oldPath, photo.Sidecar.Path); err == nil {
+					photo.Sidecar.Path = filepath.Join(newPath, rel)
+				}
+			}
+		})
+		if err != nil {
+			log.Printf("Cannot update cache of album %s[%s]: %v", c.Name, newName, err)
+			err = nil // Album will be scanned again
+		}
+	}
+	if err == nil {
+		log.Printf("Renamed album %s[%s] to %s", c.Name, oldName, newName)
+		c.cache.RemoveAlbum(oldName)
+		c.cache.RemoveFromListAlbums(oldName)
+		c.cache.AddToListAlbums(renamed)
+	}
+	unlock()
+
+	if album.IsPseudo {
+		// Restore links, to the new name if renamed
+		if err == nil {
+			album = renamed
+		}
+		album.GetPhotosForPseudo(c, true, false, entries...)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update references in pseudo albums
+	RewritePseudoAlbums(config.collections, func(_ PseudoAlbum, entry PseudoAlbumEntry) (PseudoAlbumEntry, bool) {
+		if entry.Collection == c.Name && entry.Album == oldName {
+			entry.Album = newName
+		}
+		return entry, true
+	})
+	return nil
+}
+
+// Delete the album. Photos of regular albums are moved to the trash and
+// the folder is removed only if no other files are left.
+func (c *Collection) DeleteAlbum(album *Album, user *User) error {
+	if err := c.CheckWritable(); err != nil {
+		return err
+	}
+
+	if album.IsPseudo {
+		entries, err := readPseudoAlbum(c, album)
+		if err != nil {
+			return err
+		}
+		c.LockAlbum(album.Name)
+		err = os.Remove(c.albumPath(album))
+		c.UnlockAlbum(album.Name)
+		if err != nil {
+			return err
+		}
+		// Remove links from the photos
+		album.GetPhotosForPseudo(c, false, false, entries...)
+	} else {
+		album, err := c.GetAlbumWithPhotos(album.Name, false, false)
+		if err != nil {
+			return err
+		}
+		if _, err := c.DeletePhotos(album, user, album.PhotoList()...); err != nil {
+			return err
+		}
+		c.LockAlbum(album.Name)
+		err = removeEmptyDirs(c.albumPath(album))
+		c.UnlockAlbum(album.Name)
+		if err != nil {
+			return fmt.Errorf("photos were moved to trash, but the album still has other files: %v", err)
+		}
+		c.cache.UnsetAlbumFullyScanned(album.Name)
+		c.cache.UnsetAlbumFromThumbQueue(album.Name)
+	}
+
+	log.Printf("Deleted album %s[%s]", c.Name, album.Name)
+	c.cache.RemoveAlbum(album.Name)
+	c.cache.RemoveFromListAlbums(album.Name)
+	return nil
+}
+
 func (collection *Collection) StorageUsage() (CollectionStorage, error) {
 	di, err := disk.Usage(collection.PhotosPath)
 	if err != nil {
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameAndDeleteAlbum(t *testing.T) {
+	collection := newTestCollection(t, "Album 1")
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album 1/IMG_0001.JPG"), []byte("data"), 0644)
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album 1/IMG_0001.xmp"), []byte(emptySidecar), 0644)
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Favorites"+PSEUDO_ALBUM_EXT), []byte("Photos:Album 1:img_0001\n"), 0644)
+
+	album, err := collection.GetAlbumWithPhotos("Album 1", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection.cache.FinishFlush()
+	preview := (&Photo{Id: "img_0001", Album: "Album 1"}).PreviewPath(collection, 720)
+	os.MkdirAll(filepath.Dir(preview), os.ModePerm)
+	os.WriteFile(preview, []byte("preview"), 0644)
+
+	// Rename regular album
+	if err := collection.RenameAlbum(album, "Album:2"); err == nil {
+		t.Error("Invalid name accepted")
+	}
+	if err := collection.RenameAlbum(album, "Album 2"); err != nil {
+		t.Fatal(err)
+	}
+	if collection.IsAlbum("Album 1") || !collection.IsAlbum("Album 2") {
+		t.Error("List of albums not updated")
+	}
+	photo, err := collection.cache.GetPhotoInfo("Album 2", "img_0001")
+	if err != nil || photo.Album != "Album 2" || photo.Files[0].Path != filepath.Join(collection.PhotosPath, "Album 2/IMG_0001.JPG") {
+		t.Error("Cached info not renamed", photo, err)
+	}
+	if photo.Sidecar == nil || photo.Sidecar.Path != filepath.Join(collection.PhotosPath, "Album 2/IMG_0001.xmp") {
+		t.Error("Sidecar not renamed", photo.Sidecar)
+	}
+	if _, err := os.Stat(photo.PreviewPath(collection, 720)); err != nil {
+		t.Error("Preview not moved", err)
+	}
+	if _, err := os.Stat(preview); !os.IsNotExist(err) {
+		t.Error("Preview left in the old location", err)
+	}
+	favorites := &Album{Name: "Favorites", IsPseudo: true}
+	entries, _ := readPseudoAlbum(collection, favorites)
+	expected := PseudoAlbumEntry{Collection: "Photos", Album: "Album 2", Photo: "img_0001"}
+	if len(entries) != 1 || entries[0] != expected {
+		t.Errorf("Pseudo album not updated: %v", entries)
+	}
+
+	// Rename pseudo album
+	if err := collection.RenameAlbum(favorites, "Album 2"); err == nil {
+		t.Error("Renamed to an existing album")
+	}
+	if err := collection.RenameAlbum(favorites, "Best"); err != nil {
+		t.Fatal(err)
+	}
+	album, _ = collection.GetAlbumWithPhotos("Album 2", false, false)
+	photo, _ = album.GetPhoto("img_0001")
+	if len(photo.Favorite) != 1 || photo.Favorite[0].Album != "Best" {
+		t.Errorf("Favorites not updated: %v", photo.Favorite)
+	}
+
+	// Delete albums
+	best, _ := collection.GetAlbum("Best")
+	if err := collection.DeleteAlbum(best, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(photo.Favorite) != 0 {
+		t.Errorf("Favorites not updated: %v", photo.Favorite)
+	}
+	if err := collection.DeleteAlbum(album, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(collection.PhotosPath, "Album 2")); !os.IsNotExist(err) {
+		t.Error("Album folder not deleted")
+	}
+	if trash, _ := collection.ListTrash(); len(trash) != 1 {
+		t.Error("Photo not moved to trash", trash)
+	}
+}
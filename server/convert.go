@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
+)
+
+// Files converted for the browser are kept in a cache on disk, bounded by the size set with
+// --convert-cache-size. The least recently served conversions are removed first.
+
+// Failed conversions are not tried again during this time, unless the file changes
+var ConversionRetryDelay = time.Hour
+
+type ConversionCache struct {
+	mux     sync.Mutex
+	running map[string]*Conversion // Conversions in progress, by location
+	failed  map[string]*Conversion // Conversions that failed recently, by location
+	videos  chan struct{}          // Videos are transcoded one at a time
+}
+
+// Conversion running in background
+type Conversion struct {
+	mux      sync.Mutex
+	done     chan struct{}
+	progress float64
+	err      error
+	finished time.Time
+}
+
+var conversions = ConversionCache{
+	running: make(map[string]*Conversion),
+	failed:  make(map[string]*Conversion),
+	videos:  make(chan struct{}, 1),
+}
+
+// Location of the converted files of the collection
+func (c *Collection) ConversionsPath() string {
+	return filepath.Join(c.ThumbsPath, c.Name+"-converted")
+}
+
+// Extension of the files converted for the browser
+func (file *File) ConvertedExt() string {
+	if file.Type == "video" {
+		return ".mp4"
+	}
+	return ".jpg"
+}
+
+// Key for files derived from the file. It includes the size and modification time
+// of the file, so changed files are converted again.
+func (file *File) cacheKey() (string, os.FileInfo, error) {
+	stat, err := os.Stat(file.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	key := file.Path + ":" + strconv.FormatInt(stat.Size(), 10) + ":" + strconv.FormatInt(stat.ModTime().UnixNano(), 10)
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:]), stat, nil
+}
+
+// Location of the conversion for the file
+func (file *File) ConversionPath(collection *Collection) (string, os.FileInfo, error) {
+	name, stat, err := file.cacheKey()
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(collection.ConversionsPath(), name[:2], name+file.ConvertedExt()), stat, nil
+}
+
+// Get the converted file from the cache, it is converted if not cached yet.
+// Returns its location and the info of the original file.
+func (file *File) GetConverted(collection *Collection) (string, os.FileInfo, error) {
+	conversion, path, stat, err := file.StartConversion(collection)
+	if err != nil {
+		return "", nil, err
+	}
+	if conversion != nil {
+		if err := conversion.Wait(); err != nil {
+			return "", nil, err
+		}
+	}
+	return path, stat, nil
+}
+
+// Start converting the file in background, unless it is already cached or being converted.
+// Returns the conversion in progress, nil when the converted file is ready.
+func (file *File) StartConversion(collection *Collection) (*Conversion, string, os.FileInfo, error) {
+	path, stat, err := file.ConversionPath(collection)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	conversions.mux.Lock()
+	defer conversions.mux.Unlock()
+	if conversion := conversions.get(path); conversion != nil {
+		return conversion, path, stat, nil
+	}
+	// Cached conversion, mark as recently used
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil, path, stat, nil
+	}
+
+	return conversions.start(collection, path, func(progress func(done float64)) error {
+		return file.convertTo(path, progress)
+	}), path, stat, nil
+}
+
+// Conversion to the path in progress or failed recently, nil if none. The lock must be held by the caller.
+func (cc *ConversionCache) get(path string) *Conversion {
+	if conversion, ok := cc.running[path]; ok {
+		return conversion
+	}
+	if conversion, ok := cc.failed[path]; ok {
+		if time.Since(conversion.finished) < ConversionRetryDelay {
+			return conversion
+		}
+		delete(cc.failed, path)
+	}
+	return nil
+}
+
+// Run the conversion to the path in background, the lock must be held by the caller
+func (cc *ConversionCache) start(collection *Collection, path string, convert func(progress func(done float64)) error) *Conversion {
+	conversion := &Conversion{done: make(chan struct{})}
+	cc.running[path] = conversion
+	go func() {
+		err := convert(conversion.setProgress)
+		if err != nil {
+			log.Printf("Error converting %s: %v\n", path, err)
+		} else {
+			cc.Evict(collection, config.convertLimit)
+		}
+		conversion.mux.Lock()
+		conversion.err = err
+		conversion.finished = time.Now()
+		conversion.mux.Unlock()
+		cc.mux.Lock()
+		delete(cc.running, path)
+		if err != nil {
+			// Remembered to not convert again on every request
+			for p, failed := range cc.failed {
+				if time.Since(failed.finished) >= ConversionRetryDelay {
+					delete(cc.failed, p)
+				}
+			}
+			cc.failed[path] = conversion
+		}
+		cc.mux.Unlock()
+		close(conversion.done)
+	}()
+	return conversion
+}
+
+func (c *Conversion) setProgress(done float64) {
+	c.mux.Lock()
+	c.progress = done
+	c.mux.Unlock()
+}
+
+// Fraction of the file converted, from 0 to 1
+func (c *Conversion) Progress() float64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.progress
+}
+
+// Check if the conversion finished, successfully or not
+func (c *Conversion) Finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait for the conversion to finish
+func (c *Conversion) Wait() error {
+	<-c.done
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.err
+}
+
+// Convert the file to a temporary file first, so a conversion is never served incomplete
+func (file *File) convertTo(path string, progress func(done float64)) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	fout, err := os.CreateTemp(filepath.Dir(path), ".convert-*"+file.ConvertedExt())
+	if err != nil {
+		return err
+	}
+	fout.Close()
+	defer os.Remove(fout.Name())
+
+	if file.Type == "video" {
+		defer conversions.acquireVideo()()
+	}
+	if err := file.Convert(fout.Name(), progress); err != nil {
+		return err
+	}
+	return os.Rename(fout.Name(), path)
+}
+
+// Wait for the turn to transcode a video, returns the function to release it
+func (cc *ConversionCache) acquireVideo() func() {
+	cc.videos <- struct{}{}
+	return func() { <-cc.videos }
+}
+
+// Remove the least recently used conversions until the cache fits the size in bytes, 0 is unlimited
+func (cc *ConversionCache) Evict(collection *Collection, maxSize int64) {
+	if maxSize <= 0 {
+		return
+	}
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var entries []entry
+	var total int64
+	err := filepath.WalkDir(collection.ConversionsPath(), func(path string, d os.DirEntry, err error) error {
+		// HLS streams are removed as a whole
+		if err == nil && d.IsDir() && strings.HasSuffix(d.Name(), HLSExt) {
+			size, used := hlsUsage(path)
+			entries = append(entries, entry{path, size, used})
+			total += size
+			return filepath.SkipDir
+		}
+		// Skip temporary files of conversions in progress
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+		if info, err := d.Info(); err == nil {
+			entries = append(entries, entry{path, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println(err)
+		return
+	}
+	if total <= maxSize {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+	for _, e := range entries {
+		if total <= maxSize {
+			break
+		}
+		if cc.isRunning(e.path) {
+			continue // Just converted
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			log.Println(err)
+			continue
+		}
+		total -= e.size
+	}
+}
+
+// Check if the path or any file inside is being converted, the lock must be held by the caller
+func (cc *ConversionCache) isRunning(path string) bool {
+	for running := range cc.running {
+		if running == path || strings.HasPrefix(running, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert in advance the files of the collection that require conversion
+func (collection *Collection) CreateConversions() {
+	log.Printf("Converting files for %s...\n", collection.Name)
+
+	var files []*File
+	err := collection.cache.WithStore(func(store *bolthold.Store) error {
+		return store.ForEach(&bolthold.Query{}, func(photo *Photo) error {
+			for _, file := range photo.Files {
+				if file.RequiresConvertion() {
+					files = append(files, file)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for i, file := range files {
+		WaitBackgroundWork(true)
+		log.Printf("Background conversion %s %d/%d: %s", collection.Name, i+1, len(files), file.Path)
+		if _, _, err := file.GetConverted(collection); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Serve the file converted for the browser. Videos are transcoded in background and are
+// unavailable until ready, players follow the progress with the status of the conversion.
+func serveConverted(c echo.Context, collection *Collection, file *File) error {
+	conversion, path, stat, err := file.StartConversion(collection)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if conversion != nil && file.Type == "video" && !conversion.Finished() {
+		c.Response().Header().Set("Retry-After", "5")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "video is being converted")
+	}
+	if conversion != nil {
+		if err := conversion.Wait(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	converted, err := os.Open(path)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer converted.Close()
+	name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())) + file.ConvertedExt()
+	mime := "image/jpeg"
+	if file.Type == "video" {
+		mime = "video/mp4"
+	}
+	c.Response().Header().Set(echo.HeaderContentType, mime)
+	c.Response().Header().Set(echo.HeaderContentDisposition, "inline; filename=\""+name+"\"")
+	c.Response().Header().Set(echo.HeaderCacheControl, HeaderCacheControl)
+	// Modification time of the original, the conversion is touched when used
+	http.ServeContent(c.Response(), c.Request(), name, stat.ModTime(), converted)
+	return nil
+}
+
+// Status of the conversion of the file for the browser, it is started if not converted yet.
+// Files that do not require conversion are always ready.
+func conversionStatus(c echo.Context) error {
+	collection, file, err := requestedFile(c)
+	if err != nil {
+		return err
+	}
+	if !file.RequiresConvertion() {
+		return c.JSON(http.StatusOK, TranscodeStatus{Ready: true, Progress: 1})
+	}
+	conversion, _, _, err := file.StartConversion(collection)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if conversion != nil && !conversion.Finished() {
+		c.Response().Header().Set("Retry-After", "5")
+		return c.JSON(http.StatusOK, TranscodeStatus{Progress: conversion.Progress()})
+	}
+	if conversion != nil {
+		if err := conversion.Wait(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	return c.JSON(http.StatusOK, TranscodeStatus{Ready: true, Progress: 1})
+}
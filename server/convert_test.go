@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/labstack/echo/v4"
+)
+
+func TestConversionCache(t *testing.T) {
+	collection := newTestCollection(t)
+	var files []*File
+	for _, name := range []string{"IMG_0001.HEIC", "IMG_0002.HEIC"} {
+		path := filepath.Join(collection.PhotosPath, name)
+		// Decoder is selected by the contents
+		if err := imaging.Save(gradient(300, 200, false), path+".jpg"); err != nil {
+			t.Fatal(err)
+		}
+		os.Rename(path+".jpg", path)
+		files = append(files, &File{Type: "image", Path: path})
+	}
+	if !files[0].RequiresConvertion() {
+		t.Fatal("HEIC file must be converted")
+	}
+
+	path, stat, err := files[0].GetConverted(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Name() != "IMG_0001.HEIC" {
+		t.Error("Info of the original file not returned", stat.Name())
+	}
+	img, err := imaging.Open(path)
+	if err != nil || img.Bounds().Dx() != 300 {
+		t.Fatal("Invalid conversion", err)
+	}
+
+	// Cached conversion is used
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+	if again, _, _ := files[0].GetConverted(collection); again != path {
+		t.Error("Conversion not cached", again)
+	}
+	if info, _ := os.Stat(path); !info.ModTime().After(old) {
+		t.Error("Conversion not marked as used")
+	}
+
+	// Changed files are converted again
+	changed := time.Now().Add(time.Minute)
+	os.Chtimes(files[0].Path, changed, changed)
+	newPath, _, err := files[0].GetConverted(collection)
+	if err != nil || newPath == path {
+		t.Error("Changed file not converted again", err)
+	}
+
+	// Least recently used are evicted
+	other, _, err := files[1].GetConverted(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, old, old)
+	info, _ := os.Stat(other)
+	conversions.Evict(collection, 2*info.Size())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Least recently used conversion not evicted")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("Recent conversion evicted", err)
+	}
+}
+
+func TestFailedConversion(t *testing.T) {
+	collection := newTestCollection(t)
+	path := filepath.Join(collection.PhotosPath, "IMG_0001.HEIC")
+	os.WriteFile(path, []byte("invalid"), 0644)
+	file := &File{Type: "image", Path: path}
+
+	if _, _, err := file.GetConverted(collection); err == nil {
+		t.Fatal("Expected conversion to fail")
+	}
+	// Failure is remembered
+	failed, _, _, err := file.StartConversion(collection)
+	if err != nil || failed == nil || !failed.Finished() || failed.Wait() == nil {
+		t.Fatal("Failed conversion not remembered", err)
+	}
+	if again, _, _, _ := file.StartConversion(collection); again != failed {
+		t.Error("Failed conversion started again")
+	}
+
+	// Tried again after the delay
+	defer func(delay time.Duration) { ConversionRetryDelay = delay }(ConversionRetryDelay)
+	ConversionRetryDelay = 0
+	again, _, _, _ := file.StartConversion(collection)
+	if again == nil || again == failed {
+		t.Error("Failed conversion not tried again")
+	} else {
+		again.Wait()
+	}
+}
+
+func TestConversionStatus(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	if err := os.WriteFile(filepath.Join(collection.PhotosPath, "Album", "clip.avi"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	album, err := collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := album.GetPhoto("clip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := photo.GetFile("clip.avi")
+	if err != nil || !video.RequiresConvertion() {
+		t.Fatal("Video must be converted", err)
+	}
+	request := func(handler echo.HandlerFunc) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("collection", "album", "photo", "file")
+		c.SetParamValues("Photos", "Album", "clip", "clip.avi")
+		return rec, handler(c)
+	}
+
+	// Video being transcoded
+	path, _, err := video.ConversionPath(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversion := &Conversion{done: make(chan struct{})}
+	conversion.setProgress(0.5)
+	conversions.mux.Lock()
+	conversions.running[path] = conversion
+	conversions.mux.Unlock()
+
+	rec, err := request(conversionStatus)
+	var status TranscodeStatus
+	if err != nil || json.Unmarshal(rec.Body.Bytes(), &status) != nil || status != (TranscodeStatus{false, 0.5}) {
+		t.Errorf("Unexpected status while converting: %v %s", err, rec.Body)
+	}
+	// Only the video is served on its URL
+	rec, err = request(file)
+	if httpErr, ok := err.(*echo.HTTPError); !ok || httpErr.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Video served while converting: %v", err)
+	}
+
+	// Converted
+	conversions.mux.Lock()
+	delete(conversions.running, path)
+	conversions.mux.Unlock()
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err := os.WriteFile(path, []byte("converted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = request(conversionStatus)
+	if err != nil || json.Unmarshal(rec.Body.Bytes(), &status) != nil || !status.Ready {
+		t.Errorf("Converted video not ready: %v %s", err, rec.Body)
+	}
+	if rec, err = request(file); err != nil || rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "video/mp4" {
+		t.Errorf("Converted video not served: %v %d", err, rec.Code)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
+)
+
+// Photos with the same content, in any album or collection
+type DuplicateGroup struct {
+	Hash   string   `json:"hash"`
+	Size   int64    `json:"size"` // Size of each copy
+	Photos []*Photo `json:"photos"`
+}
+
+type DuplicatesStatus struct {
+	Running  bool              `json:"running"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Checked  int               `json:"checked"` // Photos hashed so far
+	Total    int               `json:"total"`   // Photos to be hashed
+	Groups   []*DuplicateGroup `json:"groups"`
+}
+
+type DuplicateKeepQuery struct {
+	Collection string `json:"collection"`
+	Album      string `json:"album"`
+	Photo      string `json:"photo"`
+}
+
+// Last search for duplicates, shared by all users
+type DuplicateFinder struct {
+	mux    sync.Mutex
+	status DuplicatesStatus
+}
+
+var duplicates DuplicateFinder
+
+// Photos that can be the same have the same sizes for all their files
+func sizesSignature(photo *Photo) string {
+	sizes := append([]int64{}, photo.FileSizes...)
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	signature := make([]string, len(sizes))
+	for i, size := range sizes {
+		signature[i] = strconv.FormatInt(size, 10)
+	}
+	return strings.Join(signature, ",")
+}
+
+func totalSize(photo *Photo) (total int64) {
+	for _, size := range photo.FileSizes {
+		total += size
+	}
+	return total
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Hash of the contents of all files of the photo, regardless of their names
+func hashPhoto(photo *Photo) (string, error) {
+	hashes := make([]string, len(photo.Files))
+	for i, file := range photo.Files {
+		hash, err := hashFile(file.Path)
+		if err != nil {
+			return "", err
+		}
+		hashes[i] = hash
+	}
+	sort.Strings(hashes)
+	sum := sha256.Sum256([]byte(strings.Join(hashes, ",")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Find duplicated photos in the collections. Sizes of the files are compared first
+// and only photos with the same sizes have their contents hashed. Stops when the context is cancelled.
+func FindDuplicates(ctx context.Context, collections []*Collection, progress func(checked int, total int)) ([]*DuplicateGroup, error) {
+	jobProgress := ProgressFromContext(ctx)
+	// First pass: group by file sizes
+	candidates := make(map[string][]*Photo)
+	for _, collection := range collections {
+		err := collection.cache.WithStore(func(store *bolthold.Store) error {
+			return store.ForEach(&bolthold.Query{}, func(photo *Photo) error {
+				if photo.Type != "" && totalSize(photo) > 0 {
+					photo.Collection = collection.Name
+					signature := sizesSignature(photo)
+					candidates[signature] = append(candidates[signature], photo)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	total := 0
+	for signature, photos := range candidates {
+		if len(photos) < 2 {
+			delete(candidates, signature)
+		} else {
+			total += len(photos)
+		}
+	}
+
+	// Second pass: confirm by hashing the contents
+	groups := make([]*DuplicateGroup, 0)
+	checked := 0
+	for _, photos := range candidates {
+		byHash := make(map[string][]*Photo)
+		for _, photo := range photos {
+			WaitBackgroundWork(true)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			hash, err := hashPhoto(photo)
+			checked++
+			progress(checked, total)
+			if err != nil {
+				log.Println(err)
+				jobProgress.AddErrors(1)
+				continue
+			}
+			jobProgress.AddFiles(len(photo.Files))
+			byHash[hash] = append(byHash[hash], photo)
+		}
+		for hash, photos := range byHash {
+			if len(photos) > 1 {
+				sortDuplicates(photos)
+				groups = append(groups, &DuplicateGroup{Hash: hash, Size: totalSize(photos[0]), Photos: photos})
+			}
+		}
+	}
+
+	// Largest first, those release more space
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Size != groups[j].Size {
+			return groups[i].Size > groups[j].Size
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+	return groups, nil
+}
+
+func sortDuplicates(photos []*Photo) {
+	sort.Slice(photos, func(i, j int) bool {
+		return photos[i].Collection+":"+photos[i].Key() < photos[j].Collection+":"+photos[j].Key()
+	})
+}
+
+// Start searching for duplicates in the collections as a job
+func (d *DuplicateFinder) Start(collections []*Collection) (*Job, error) {
+	return jobManager.StartFunc("duplicates", collections, func(ctx context.Context) (string, error) {
+		groups, err := d.Run(ctx, collections)
+		return fmt.Sprintf("%d groups of duplicates", groups), err
+	})
+}
+
+// Search for duplicates and wait for the result, returns the number of groups found
+func (d *DuplicateFinder) Run(ctx context.Context, collections []*Collection) (int, error) {
+	if !d.begin() {
+		return 0, errors.New("search for duplicates already running")
+	}
+	return d.search(ctx, collections)
+}
+
+// Flag the search as running, unless it is already
+func (d *DuplicateFinder) begin() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.status.Running {
+		return false
+	}
+	d.status = DuplicatesStatus{Running: true, Started: time.Now(), Groups: d.status.Groups}
+	return true
+}
+
+func (d *DuplicateFinder) search(ctx context.Context, collections []*Collection) (int, error) {
+	log.Println("Searching for duplicates...")
+	groups, err := FindDuplicates(ctx, collections, func(checked int, total int) {
+		d.mux.Lock()
+		d.status.Checked, d.status.Total = checked, total
+		d.mux.Unlock()
+	})
+	if err != nil {
+		log.Println("Search for duplicates failed:", err)
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.status.Running = false
+	d.status.Finished = time.Now()
+	if err != nil {
+		return 0, err
+	}
+	d.status.Groups = groups
+	log.Printf("Found %d groups of duplicates", len(groups))
+	return len(groups), nil
+}
+
+// Status of the search with the duplicates that the user can see
+func (d *DuplicateFinder) Status(user *User) DuplicatesStatus {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	status := d.status
+	status.Groups = make([]*DuplicateGroup, 0)
+	for _, group := range d.status.Groups {
+		visible := &DuplicateGroup{Hash: group.Hash, Size: group.Size}
+		for _, photo := range group.Photos {
+			if collection, err := GetCollection(photo.Collection); err == nil && collection.Allows(user, AccessRead) {
+				visible.Photos = append(visible.Photos, photo)
+			}
+		}
+		if len(visible.Photos) > 1 {
+			status.Groups = append(status.Groups, visible)
+		}
+	}
+	return status
+}
+
+// Keep one photo of the group and move the other copies to the trash
+func (d *DuplicateFinder) Keep(hash string, keep DuplicateKeepQuery, user *User) ([]*TrashEntry, error) {
+	d.mux.Lock()
+	var group *DuplicateGroup
+	index := -1
+	for i, g := range d.status.Groups {
+		if g.Hash == hash {
+			group, index = g, i
+		}
+	}
+	d.mux.Unlock()
+	if group == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "group of duplicates not found")
+	}
+
+	var kept *Photo
+	for _, photo := range group.Photos {
+		if photo.Collection == keep.Collection && photo.Album == keep.Album && photo.Id == keep.Photo {
+			kept = photo
+		}
+	}
+	if kept == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "photo to keep is not in the group")
+	}
+	if _, err := collectionWithAccess(user, kept.Collection, AccessRead); err != nil {
+		return nil, err
+	}
+	// All copies must be accessible before deleting any
+	type albumRef struct{ collection, album string }
+	remove := make(map[albumRef][]string)
+	for _, photo := range group.Photos {
+		if photo == kept {
+			continue
+		}
+		if _, err := collectionWithAccess(user, photo.Collection, AccessWrite); err != nil {
+			return nil, err
+		}
+		ref := albumRef{photo.Collection, photo.Album}
+		remove[ref] = append(remove[ref], photo.Id)
+	}
+
+	var trashed []*TrashEntry
+	var errs []string
+	for ref, ids := range remove {
+		collection, _ := GetCollection(ref.collection)
+		album, err := collection.GetAlbumWithPhotos(ref.album, false, false)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		var photos []*Photo
+		for _, id := range ids {
+			photo, err := album.GetPhoto(id)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			photos = append(photos, photo)
+		}
+		entries, err := collection.DeletePhotos(album, user, photos...)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		trashed = append(trashed, entries...)
+	}
+
+	// Group is solved
+	if len(errs) == 0 {
+		d.mux.Lock()
+		if index < len(d.status.Groups) && d.status.Groups[index] == group {
+			d.status.Groups = append(d.status.Groups[:index], d.status.Groups[index+1:]...)
+		}
+		d.mux.Unlock()
+		return trashed, nil
+	}
+	return trashed, errors.New(strings.Join(errs, "; "))
+}
+
+func duplicatesStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, duplicates.Status(CurrentUser(c)))
+}
+
+// Search only in the collections that the user administers
+func findDuplicates(c echo.Context) error {
+	user := CurrentUser(c)
+	var collections []*Collection
+	for _, collection := range config.collections {
+		if collection.Allows(user, AccessAdmin) {
+			collections = append(collections, collection)
+		}
+	}
+	if len(collections) == 0 {
+		return echo.NewHTTPError(http.StatusForbidden, AccessAdmin.String()+" access to a collection is required")
+	}
+	started, err := duplicates.Start(collections)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	job, _ := jobManager.Get(started.Id)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func keepDuplicate(c echo.Context) error {
+	var query DuplicateKeepQuery
+
+	// Decode body
+	if err := c.Bind(&query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	trashed, err := duplicates.Keep(c.Param("hash"), query, CurrentUser(c))
+	if _, ok := err.(*echo.HTTPError); ok {
+		return err
+	}
+	if err != nil && len(trashed) == 0 {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusMultiStatus, trashed)
+	}
+	return c.JSON(http.StatusOK, trashed)
+}
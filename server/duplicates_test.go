@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	collection := newTestCollection(t, "Album 1", "Album 2")
+	files := map[string]string{
+		"Album 1/IMG_0001.JPG": "same",
+		"Album 2/Copy.JPG":     "same",
+		"Album 2/IMG_0002.JPG": "diff", // Same size, different content
+	}
+	for file, content := range files {
+		os.WriteFile(filepath.Join(collection.PhotosPath, file), []byte(content), 0644)
+	}
+	collection.GetAlbumWithPhotos("Album 1", false, false)
+	collection.GetAlbumWithPhotos("Album 2", false, false)
+	collection.cache.FinishFlush()
+
+	groups, err := FindDuplicates(context.Background(), []*Collection{collection}, func(int, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Photos) != 2 {
+		t.Fatalf("Expected one group with two photos: %v", groups)
+	}
+	if groups[0].Photos[0].Album != "Album 1" || groups[0].Photos[1].Id != "copy" {
+		t.Errorf("Unexpected duplicates: %v", groups[0].Photos)
+	}
+
+	// Keep the original and trash the copy
+	finder := DuplicateFinder{status: DuplicatesStatus{Groups: groups}}
+	keep := DuplicateKeepQuery{Collection: "Photos", Album: "Album 1", Photo: "img_0001"}
+	trashed, err := finder.Keep(groups[0].Hash, keep, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].Photo != "copy" {
+		t.Errorf("Copy not moved to trash: %v", trashed)
+	}
+	if _, err := os.Stat(filepath.Join(collection.PhotosPath, "Album 1/IMG_0001.JPG")); err != nil {
+		t.Error("Original was removed", err)
+	}
+	if len(finder.Status(nil).Groups) != 0 {
+		t.Error("Group still listed after being solved")
+	}
+}
+
+func TestFindDuplicatesJob(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album/IMG_0001.JPG"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album/Copy.JPG"), []byte("same"), 0644)
+	collection.GetAlbumWithPhotos("Album", false, false)
+	collection.cache.FinishFlush()
+
+	// Only admins can search
+	acl, _ := ParseACL("alice:write")
+	collection.ACL = acl
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/duplicates", nil), httptest.NewRecorder())
+	c.Set("user", &User{Name: "alice"})
+	if err, ok := findDuplicates(c).(*echo.HTTPError); !ok || err.Code != http.StatusForbidden {
+		t.Error("Expected search to be forbidden", err)
+	}
+
+	started, err := duplicates.Start([]*Collection{collection})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started.done
+	job, _ := jobManager.Get(started.Id)
+	if job.State != JobCompleted || job.Result != "1 groups of duplicates" || job.Progress.Files != 2 {
+		t.Error("Unexpected job", job)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FindDuplicates(ctx, []*Collection{collection}, func(int, int) {}); err != context.Canceled {
+		t.Error("Expected search to be cancelled", err)
+	}
+}
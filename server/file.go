@@ -27,6 +27,14 @@ type File struct {
 	Location    GPSLocation `json:"-"`      // Image location
 	Orientation Orientation `json:"-"`      // Image orientation
 	Size        int64       `json:"-"`      // Image file size
+	ModTime     time.Time   `json:"-"`      // File modification time, to find changed files
+	VideoCodec  string      `json:"-"`      // Video codec, empty for images
+	AudioCodec  string      `json:"-"`      // Audio codec, empty if the video has no sound
+	Duration    float64     `json:"-"`      // Video duration in seconds
+	Bitrate     int64       `json:"-"`      // Video bits per second
+	FrameRate   float64     `json:"-"`      // Video frames per second
+	ContentID   string      `json:"-"`      // Identifier shared by the image and video of Live Photos
+	Metadata    *Metadata   `json:"-"`      // Embedded XMP and IPTC metadata
 }
 
 type FileExtendedInfo struct {
@@ -47,6 +55,7 @@ type FileExtendedInfo struct {
 		Location GPSLocation `json:"location"` // Image location
 		Exif     *exif.Exif  `json:"exif"`     // Image EXIF data
 	} `json:"imageinfo"`
+	VideoInfo VideoInfo `json:"videoinfo"`
 }
 
 type GPSLocation struct {
@@ -63,6 +72,12 @@ func (file *File) Ext() string {
 }
 
 // Find which type (image or video) and MIME-type of the file
+// Check if the file on disk changed since its info was extracted
+func (file *File) outdated() bool {
+	stat, err := os.Stat(file.Path)
+	return err == nil && (stat.Size() != file.Size || !stat.ModTime().Equal(file.ModTime))
+}
+
 func (file *File) ExtractInfo() error {
 	f, err := os.Open(file.Path)
 	if err != nil {
@@ -81,6 +96,10 @@ func (file *File) ExtractInfo() error {
 	// Use the net/http package's handy DectectContentType function. Always returns a valid
 	// content-type by returning "application/octet-stream" if no others seemed to match.
 	file.MIME = http.DetectContentType(buffer)
+	// RAW files are detected as TIFF or not at all
+	if mime, ok := RawFormats[file.Ext()]; ok {
+		file.MIME = mime
+	}
 
 	switch {
 	case strings.HasPrefix(file.MIME, "image/"):
@@ -110,10 +129,15 @@ func (file *File) ExtractInfo() error {
 	if err == nil {
 		file.Date = fileInfo.ModTime()
 		file.Size = fileInfo.Size()
+		file.ModTime = fileInfo.ModTime()
 	}
 
 	switch file.Type {
 	case "image":
+		file.Metadata, err = ExtractMetadata(file.Path)
+		if err != nil {
+			log.Printf("Could not read metadata of %s: %v\n", file.Path, err)
+		}
 		_, cfg, exifInfo, _ := ExtractImageInfoOpened(f)
 		file.Width = cfg.Width
 		file.Height = cfg.Height
@@ -140,11 +164,31 @@ func (file *File) ExtractInfo() error {
 			} else {
 				file.Orientation = orientationUnspecified // tag not present
 			}
+			file.ContentID = AppleContentID(exifInfo)
 		}
 	case "video":
-		// TODO: extract info for video
 		file.Width = 1920
 		file.Height = 1080
+		videoInfo, err := ExtractVideoInfo(file.Path)
+		if err != nil {
+			log.Printf("Could not read video info of %s: %v\n", file.Path, err)
+			break
+		}
+		if videoInfo.Width > 0 && videoInfo.Height > 0 {
+			file.Width = videoInfo.Width
+			file.Height = videoInfo.Height
+		}
+		if !videoInfo.Date.IsZero() {
+			file.Date = videoInfo.Date
+		}
+		file.Location = videoInfo.Location
+		// Codecs tell if the video must be transcoded
+		file.VideoCodec = videoInfo.VideoCodec
+		file.AudioCodec = videoInfo.AudioCodec
+		file.Duration = videoInfo.Duration
+		file.Bitrate = videoInfo.Bitrate
+		file.FrameRate = videoInfo.FrameRate
+		file.ContentID = videoInfo.ContentID
 	}
 
 	return nil
@@ -180,7 +224,23 @@ func (file *File) ExtractExtendedInfo() (info FileExtendedInfo, err error) {
 			log.Println("error while extracting image info", err)
 		}
 	case "video":
-		log.Println("video info extraction not yet implemented")
+		vi, err := ExtractVideoInfo(file.Path)
+		if err != nil {
+			log.Println("error while extracting video info", err)
+			// Copy the data known from File
+			vi = &VideoInfo{
+				Width:      file.Width,
+				Height:     file.Height,
+				Duration:   file.Duration,
+				VideoCodec: file.VideoCodec,
+				AudioCodec: file.AudioCodec,
+				Bitrate:    file.Bitrate,
+				FrameRate:  file.FrameRate,
+				Date:       file.Date,
+				Location:   file.Location,
+			}
+		}
+		info.VideoInfo = *vi
 		return info, nil
 	default:
 		return info, errors.New("invalid info extraction")
@@ -190,20 +250,25 @@ func (file *File) ExtractExtendedInfo() (info FileExtendedInfo, err error) {
 
 // If the file requires transcoding like files that are not supported by the browser
 func (file *File) RequiresConvertion() bool {
-	if file.Type == "image" && file.Ext() == ".heic" {
+	if file.Type == "image" && (file.Ext() == ".heic" || IsRawFile(file.Path)) {
+		return true
+	}
+	if file.Type == "video" && !file.BrowserPlayable() {
 		return true
 	}
 
 	return false
 }
 
-func (file *File) Convert(w io.Writer) error {
+// Convert the file to a format supported by the browser, saved in the path
+func (file *File) Convert(path string, progress func(done float64)) error {
 	switch file.Type {
 	case "image":
 		// Check for EXIF
 		_, _, exifInfo, _ := ExtractImageInfo(file.Path)
 		var exifData []byte
-		if exifInfo != nil {
+		// EXIF of RAW files is the whole container
+		if exifInfo != nil && !IsRawFile(file.Path) {
 			exifData = exifInfo.Raw
 		}
 
@@ -213,20 +278,24 @@ func (file *File) Convert(w io.Writer) error {
 			return err
 		}
 
-		// Encode thumbnail
-		err = EncodeImage(w, img, exifData)
+		// Encode converted image
+		fout, err := os.Create(path)
 		if err != nil {
 			return err
 		}
+		err = EncodeImage(fout, img, exifData)
+		if cerr := fout.Close(); err == nil {
+			err = cerr
+		}
+		return err
 	case "video":
-		return errors.New("conversion not yet implemented")
+		return TranscodeVideo(file.Path, path, progress)
 	}
 	return errors.New("invalid conversion")
 }
 
-func (file File) CreateThumbnail(thumbpath string, w io.Writer) (err error) {
-	var img image.Image
-
+// Decode the image of the file, a frame is used for videos
+func (file File) DecodeImage() (img image.Image, err error) {
 	switch file.Type {
 	case "image":
 		// Decode original image
@@ -238,8 +307,14 @@ func (file File) CreateThumbnail(thumbpath string, w io.Writer) (err error) {
 
 	// Error decoding image from source
 	if img == nil {
-		return errors.New("invalid image")
+		return nil, errors.New("invalid image")
 	}
+	return
+}
+
+// Create the thumbnail for the file, returns the perceptual hash of the image
+func (file File) CreateThumbnail(thumbpath string, w io.Writer) (hash uint64, err error) {
+	img, err := file.DecodeImage()
 	if err != nil {
 		return
 	}
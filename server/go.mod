@@ -8,13 +8,16 @@ require (
 	github.com/bluele/gcache v0.0.2
 	github.com/disintegration/imaging v1.6.2
 	github.com/dustin/go-humanize v1.0.1
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/labstack/echo/v4 v4.11.1
 	github.com/mholt/goexif2 v0.0.0-20230302025153-4d89d35092b2
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/shirou/gopsutil v3.21.11+incompatible
 	github.com/timshannon/bolthold v0.0.0-20210913165410-232392fc8a6a
 	github.com/zulucmd/zflag v1.1.2
 	gitlab.com/golang-utils/image2 v0.0.1
 	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.12.0
 	golang.org/x/exp v0.0.0-20230809150735-7b3493d9a819
 	golang.org/x/image v0.11.0
 	golang.org/x/net v0.14.0
@@ -30,7 +33,6 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	github.com/yusufpapurcu/wmi v1.2.3 // indirect
-	golang.org/x/crypto v0.12.0 // indirect
 	golang.org/x/sys v0.11.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 	golang.org/x/time v0.3.0 // indirect
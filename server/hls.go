@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Videos are streamed with HLS in several renditions. Each rendition is segmented
+// when first requested and kept with the other conversions of the collection, in a
+// folder with all files of the video.
+
+const (
+	HLSExt          = ".hls"
+	HLSMaster       = "master.m3u8"
+	HLSPlaylist     = "index.m3u8"
+	HLSAudioBitrate = 128000
+	hlsDoneMarker   = ".done"
+	hlsWaitTimeout  = 60 * time.Second
+)
+
+type HLSRendition struct {
+	Name    string
+	Height  int
+	Bitrate int // Bits per second of the video
+}
+
+var HLSRenditions = []HLSRendition{
+	{"360p", 360, 800000},
+	{"720p", 720, 2800000},
+	{"1080p", 1080, 5000000},
+}
+
+var (
+	hlsFileRegexp  = regexp.MustCompile(`^(?:` + regexp.QuoteMeta(HLSMaster) + `|(\d+p)/(` + regexp.QuoteMeta(HLSPlaylist) + `|segment\d+\.ts))$`)
+	ErrHLSNotFound = errors.New("HLS file not found")
+	ErrHLSNotReady = errors.New("HLS rendition is not ready yet")
+)
+
+// Location of the HLS files of the video
+func (file *File) HLSPath(collection *Collection) (string, error) {
+	name, _, err := file.cacheKey()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(collection.ConversionsPath(), name[:2], name+HLSExt), nil
+}
+
+// Renditions for a video with the height, larger than the source are skipped
+func hlsRenditions(height int) []HLSRendition {
+	var renditions []HLSRendition
+	for _, rendition := range HLSRenditions {
+		if rendition.Height <= height {
+			renditions = append(renditions, rendition)
+		}
+	}
+	// Small videos are only available in the size of the source
+	if len(renditions) == 0 {
+		renditions = append(renditions, HLSRenditions[0])
+	}
+	return renditions
+}
+
+// Create the master playlist of the video with its renditions
+func (file *File) createHLSMaster(dir string) error {
+	width, height, err := GetVideoSize(file.Path)
+	if err != nil {
+		return err
+	}
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, rendition := range hlsRenditions(height) {
+		// Same scaling as the transcoder
+		w, h := width, height
+		if rendition.Height < height {
+			w, h = (width*rendition.Height/height+1)&^1, rendition.Height
+		}
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/%s\n",
+			rendition.Bitrate+HLSAudioBitrate, w, h, rendition.Name, HLSPlaylist)
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+HLSMaster)
+	if err := os.WriteFile(tmp, []byte(master.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, HLSMaster))
+}
+
+// Start segmenting the rendition in background, unless it is done or already running
+func (file *File) startSegmenting(collection *Collection, dir string, rendition HLSRendition) *Conversion {
+	renditionDir := filepath.Join(dir, rendition.Name)
+	playlist := filepath.Join(renditionDir, HLSPlaylist)
+
+	conversions.mux.Lock()
+	defer conversions.mux.Unlock()
+	if conversion := conversions.get(playlist); conversion != nil {
+		return conversion
+	}
+	if _, err := os.Stat(filepath.Join(renditionDir, hlsDoneMarker)); err == nil {
+		return nil
+	}
+
+	return conversions.start(collection, playlist, func(progress func(done float64)) error {
+		defer conversions.acquireVideo()()
+		// Files from an interrupted run
+		os.RemoveAll(renditionDir)
+		if err := os.MkdirAll(renditionDir, os.ModePerm); err != nil {
+			return err
+		}
+		if err := SegmentVideo(file.Path, playlist, rendition.Height, rendition.Bitrate, progress); err != nil {
+			os.RemoveAll(renditionDir)
+			return err
+		}
+		return os.WriteFile(filepath.Join(renditionDir, hlsDoneMarker), nil, 0644)
+	})
+}
+
+// Get the location of the file of the HLS stream (master playlist, rendition playlist or segment).
+// Playlists are created when requested and returned as soon as the first segment is ready.
+func (file *File) GetHLSFile(collection *Collection, name string) (string, error) {
+	match := hlsFileRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return "", ErrHLSNotFound
+	}
+	dir, err := file.HLSPath(collection)
+	if err != nil {
+		return "", err
+	}
+
+	// Master playlist, marked as recently used for the cache
+	if match[1] == "" {
+		master := filepath.Join(dir, HLSMaster)
+		now := time.Now()
+		if err := os.Chtimes(master, now, now); err == nil {
+			return master, nil
+		}
+		return master, file.createHLSMaster(dir)
+	}
+
+	var rendition *HLSRendition
+	for i := range HLSRenditions {
+		if HLSRenditions[i].Name == match[1] {
+			rendition = &HLSRenditions[i]
+		}
+	}
+	if rendition == nil {
+		return "", ErrHLSNotFound
+	}
+	path := filepath.Join(dir, match[1], match[2])
+	if match[2] != HLSPlaylist {
+		return path, nil // Segments listed in the playlist already exist
+	}
+
+	conversion := file.startSegmenting(collection, dir, *rendition)
+	if conversion == nil {
+		return path, nil
+	}
+	// Wait until the playlist is written with the first segment
+	timeout := time.After(hlsWaitTimeout)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		select {
+		case <-conversion.done:
+			if err := conversion.Wait(); err != nil {
+				return "", err
+			}
+		case <-timeout:
+			return "", ErrHLSNotReady
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// Size of the files of the HLS stream and when it was last used
+func hlsUsage(dir string) (size int64, used time.Time) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	if info, err := os.Stat(filepath.Join(dir, HLSMaster)); err == nil {
+		used = info.ModTime()
+	}
+	return size, used
+}
+
+func hlsFile(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessRead)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	photo, err := album.GetPhoto(c.Param("photo"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if _, err := photoCollection(CurrentUser(c), collection, album, photo); err != nil {
+		return err
+	}
+	file, err := photo.GetFile(c.Param("file"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if file.Type != "video" {
+		return echo.NewHTTPError(http.StatusBadRequest, "only videos can be streamed")
+	}
+
+	path, err := file.GetHLSFile(collection, c.Param("*"))
+	if errors.Is(err, ErrHLSNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, ErrHLSNotReady) {
+		c.Response().Header().Set("Retry-After", "5")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if strings.HasSuffix(path, ".m3u8") {
+		// Playlists change while the rendition is segmented
+		c.Response().Header().Set(echo.HeaderContentType, "application/vnd.apple.mpegurl")
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, "video/mp2t")
+		c.Response().Header().Set(echo.HeaderCacheControl, HeaderCacheControl)
+	}
+	return c.File(path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHLSRenditions(t *testing.T) {
+	if r := hlsRenditions(1080); len(r) != 3 {
+		t.Error("Expected all renditions for 1080p", r)
+	}
+	if r := hlsRenditions(720); len(r) != 2 || r[1].Name != "720p" {
+		t.Error("Expected renditions up to 720p", r)
+	}
+	if r := hlsRenditions(240); len(r) != 1 || r[0].Name != "360p" {
+		t.Error("Expected only the smallest rendition", r)
+	}
+}
+
+func TestHLSFileNames(t *testing.T) {
+	valid := []string{"master.m3u8", "720p/index.m3u8", "360p/segment000.ts", "1080p/segment1234.ts"}
+	invalid := []string{"", "index.m3u8", "720p/master.m3u8", "../720p/index.m3u8", "720p/../../x.ts", "720p/segment.ts", "720p/.done"}
+	for _, name := range valid {
+		if !hlsFileRegexp.MatchString(name) {
+			t.Error("Valid name not accepted:", name)
+		}
+	}
+	for _, name := range invalid {
+		if hlsFileRegexp.MatchString(name) {
+			t.Error("Invalid name accepted:", name)
+		}
+	}
+	file := &File{Type: "video", Path: filepath.Join(t.TempDir(), "video.mov")}
+	os.WriteFile(file.Path, []byte("data"), 0644)
+	if _, err := file.GetHLSFile(newTestCollection(t), "4k/index.m3u8"); err != ErrHLSNotFound {
+		t.Error("Unknown rendition must not be found", err)
+	}
+}
+
+func TestEvictHLS(t *testing.T) {
+	collection := newTestCollection(t)
+	old := filepath.Join(collection.ConversionsPath(), "aa", "aaaa"+HLSExt)
+	recent := filepath.Join(collection.ConversionsPath(), "bb", "bbbb"+HLSExt)
+	for _, dir := range []string{old, recent} {
+		os.MkdirAll(filepath.Join(dir, "360p"), os.ModePerm)
+		os.WriteFile(filepath.Join(dir, HLSMaster), []byte("#EXTM3U\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "360p", "segment000.ts"), make([]byte, 1000), 0644)
+	}
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(old, HLSMaster), past, past)
+
+	conversions.Evict(collection, 1500)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Least recently used stream not evicted")
+	}
+	if _, err := os.Stat(filepath.Join(recent, "360p", "segment000.ts")); err != nil {
+		t.Error("Recent stream evicted", err)
+	}
+}
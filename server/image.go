@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -8,6 +9,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	// Fork from standard library "image/jpeg" that decodes corrupted images
 	// REMINDER: check for updates
@@ -104,8 +107,17 @@ func DecodeImage(filepath string, orientation Orientation) (image.Image, error)
 		return nil, err
 	}
 	defer fin.Close()
-	// Decode image
-	img, format, err := image.Decode(fin)
+	// Decode image, the embedded preview for RAW files
+	var img image.Image
+	var format string
+	if IsRawFile(filepath) {
+		var preview []byte
+		if preview, err = ExtractRawPreviewFile(fin); err == nil {
+			img, format, err = image.Decode(bytes.NewReader(preview))
+		}
+	} else {
+		img, format, err = image.Decode(fin)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error decoding image type %s: %v", format, err)
 	}
@@ -148,9 +160,17 @@ func ExtractImageInfoOpened(fin *os.File) (format string, config image.Config, e
 	// Rewind to the start
 	fin.Seek(0, io.SeekStart)
 
-	// Decode image configuration
-	config, format, err = image.DecodeConfig(fin)
-	if err != nil {
+	// Decode image configuration, the size of the embedded preview for RAW files
+	if IsRawFile(fin.Name()) {
+		var preview []byte
+		if preview, err = ExtractRawPreviewFile(fin); err != nil {
+			return
+		}
+		if config, err = jpeg.DecodeConfig(bytes.NewReader(preview)); err != nil {
+			return
+		}
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fin.Name())), ".")
+	} else if config, format, err = image.DecodeConfig(fin); err != nil {
 		return
 	}
 
@@ -166,11 +186,12 @@ func ExtractImageInfoOpened(fin *os.File) (format string, config image.Config, e
 	return
 }
 
-func CreateThumbnailFromImage(img image.Image, thumbpath string, w io.Writer) error {
+// Create the thumbnail from the decoded image, returns the perceptual hash of the image
+func CreateThumbnailFromImage(img image.Image, thumbpath string, w io.Writer) (uint64, error) {
 	// Open output file thumbnail
 	fout, err := os.OpenFile(thumbpath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer fout.Close()
 
@@ -183,9 +204,47 @@ func CreateThumbnailFromImage(img image.Image, thumbpath string, w io.Writer) er
 		mw = io.MultiWriter(w, fout)
 	}
 	err = EncodeImage(mw, img, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// The thumbnail has enough detail for the hash
+	return PerceptualHash(img), nil
+}
+
+// Resize the image to fit the size in both dimensions, smaller images are not enlarged.
+// It is written to a temporary file first, so the preview is never served incomplete.
+func CreatePreviewFromImage(img image.Image, previewpath string, size int) error {
+	fout, err := os.CreateTemp(filepath.Dir(previewpath), ".preview-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fout.Name())
+
+	img = imaging.Fit(img, size, size, imaging.Lanczos)
+	err = EncodeImage(fout, img, nil)
+	if cerr := fout.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return err
 	}
+	return os.Rename(fout.Name(), previewpath)
+}
 
-	return nil // No error
+// Difference hash (dHash) of the image: the image is reduced to 9x8 pixels in grayscale
+// and each bit tells if the brightness increases between adjacent pixels in a row.
+// Similar images have hashes with a small Hamming distance.
+func PerceptualHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.Pix[small.PixOffset(x, y)] < small.Pix[small.PixOffset(x+1, y)] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
 }
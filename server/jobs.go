@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
+)
+
+// Long running tasks of the collections (scans, thumbnails, cleanups...) run as jobs, which can be
+// followed and cancelled. Jobs of the same collection run one at a time, the others are queued.
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+const MaxFinishedJobs = 100 // Finished jobs kept to be queried
+
+// Counters updated while the job runs
+type JobProgress struct {
+	Albums      int64 `json:"albums"`      // Albums processed
+	TotalAlbums int64 `json:"totalalbums"` // Albums to be processed
+	Files       int64 `json:"files"`       // Files processed
+	Errors      int64 `json:"errors"`
+}
+
+type Job struct {
+	Id          string      `json:"id"`
+	Name        string      `json:"name"`
+	Collections []string    `json:"collections"` // Collections where the job runs
+	State       JobState    `json:"state"`
+	Created     time.Time   `json:"created"`
+	Started     time.Time   `json:"started"`
+	Finished    time.Time   `json:"finished"`
+	Progress    JobProgress `json:"progress"`
+	Result      string      `json:"result,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// Result of the last run of the job, kept in the cache DB
+type JobRun struct {
+	Job      string    `json:"job"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Jobs that can be run, returning a short description of the result
+var Jobs = map[string]func(ctx context.Context, c *Collection) (string, error){
+	"quick-scan": func(ctx context.Context, c *Collection) (string, error) {
+		return "", c.Scan(ctx, false)
+	},
+	"full-scan": func(ctx context.Context, c *Collection) (string, error) {
+		return "", c.Scan(ctx, true)
+	},
+	"cleanup-thumbs": func(ctx context.Context, c *Collection) (string, error) {
+		return "", c.CleanupThumbnails(ctx)
+	},
+	"create-thumbs": func(ctx context.Context, c *Collection) (string, error) {
+		return "", c.CreateThumbnails(ctx)
+	},
+	"duplicates": func(ctx context.Context, c *Collection) (string, error) {
+		groups, err := duplicates.Run(ctx, []*Collection{c})
+		return fmt.Sprintf("%d groups of duplicates", groups), err
+	},
+	"compact-db": func(ctx context.Context, c *Collection) (string, error) {
+		before, after, err := c.cache.Compact()
+		return humanize.IBytes(uint64(before)) + " to " + humanize.IBytes(uint64(after)), err
+	},
+}
+
+type progressKey struct{}
+
+// Context where the progress of the job is counted
+func WithProgress(ctx context.Context, progress *JobProgress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// Progress of the job running with the context, nil if none (counting is then skipped)
+func ProgressFromContext(ctx context.Context) *JobProgress {
+	progress, _ := ctx.Value(progressKey{}).(*JobProgress)
+	return progress
+}
+
+func (p *JobProgress) AddAlbums(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.Albums, int64(n))
+	}
+}
+func (p *JobProgress) AddTotalAlbums(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.TotalAlbums, int64(n))
+	}
+}
+func (p *JobProgress) AddFiles(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.Files, int64(n))
+	}
+}
+func (p *JobProgress) AddErrors(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.Errors, int64(n))
+	}
+}
+
+// Copy of the counters
+func (p *JobProgress) Load() JobProgress {
+	return JobProgress{
+		Albums:      atomic.LoadInt64(&p.Albums),
+		TotalAlbums: atomic.LoadInt64(&p.TotalAlbums),
+		Files:       atomic.LoadInt64(&p.Files),
+		Errors:      atomic.LoadInt64(&p.Errors),
+	}
+}
+
+type JobManager struct {
+	mux   sync.Mutex
+	jobs  map[string]*Job
+	locks map[string]chan struct{} // By collection, holds a value while a job runs
+}
+
+var jobManager JobManager
+
+// Start the job for the collection in background, unless it is already queued or running
+func (m *JobManager) Start(collection *Collection, name string) (*Job, error) {
+	fn, ok := Jobs[name]
+	if !ok {
+		return nil, errors.New("invalid job: " + name)
+	}
+	return m.StartFunc(name, []*Collection{collection}, func(ctx context.Context) (string, error) {
+		return fn(ctx, collection)
+	})
+}
+
+// Start a job running over several collections in background, it waits for the jobs of all of them.
+// The job is not started if another job with the same name is queued or running for any of them.
+func (m *JobManager) StartFunc(name string, collections []*Collection, fn func(ctx context.Context) (string, error)) (*Job, error) {
+	id, err := randomId(8)
+	if err != nil {
+		return nil, err
+	}
+	collections = append([]*Collection{}, collections...)
+	sort.Slice(collections, func(i, j int) bool { // Locked always in the same order
+		return collections[i].Name < collections[j].Name
+	})
+	names := make([]string, len(collections))
+	for i, collection := range collections {
+		names[i] = collection.Name
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.jobs == nil {
+		m.jobs = make(map[string]*Job)
+		m.locks = make(map[string]chan struct{})
+	}
+	for _, job := range m.jobs {
+		if job.Name == name && job.active() && slices.ContainsFunc(job.Collections, func(c string) bool { return slices.Contains(names, c) }) {
+			return nil, errors.New("job already running: " + name)
+		}
+	}
+	locks := make([]chan struct{}, len(names))
+	for i, name := range names {
+		if locks[i] = m.locks[name]; locks[i] == nil {
+			locks[i] = make(chan struct{}, 1)
+			m.locks[name] = locks[i]
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		Id:          id,
+		Name:        name,
+		Collections: names,
+		State:       JobQueued,
+		Created:     time.Now(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	m.jobs[id] = job
+	m.prune()
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		// Wait for the jobs of the collections, unless cancelled meanwhile
+		for _, lock := range locks {
+			select {
+			case lock <- struct{}{}:
+				defer func(lock chan struct{}) { <-lock }(lock)
+			case <-ctx.Done():
+				m.finish(collections, job, "", ctx.Err())
+				return
+			}
+		}
+		m.mux.Lock()
+		if ctx.Err() != nil {
+			m.mux.Unlock()
+			m.finish(collections, job, "", ctx.Err())
+			return
+		}
+		job.State, job.Started = JobRunning, time.Now()
+		m.mux.Unlock()
+		log.Printf("Running job %s for %s (%s)...", name, strings.Join(names, ", "), id)
+
+		result, err := fn(WithProgress(ctx, &job.Progress))
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err() // Stopped earlier
+		}
+		m.finish(collections, job, result, err)
+	}()
+	return job, nil
+}
+
+// Start the job and wait for it to finish
+func (m *JobManager) Run(collection *Collection, name string) (Job, error) {
+	job, err := m.Start(collection, name)
+	if err != nil {
+		return Job{}, err
+	}
+	<-job.done
+	return m.Get(job.Id)
+}
+
+func (m *JobManager) finish(collections []*Collection, job *Job, result string, err error) {
+	m.mux.Lock()
+	if !job.Finished.IsZero() { // Cancelled while queued
+		m.mux.Unlock()
+		return
+	}
+	job.Finished = time.Now()
+	job.Result = result
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.State = JobCancelled
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+	default:
+		job.State = JobCompleted
+	}
+	state := job.State
+	run := &JobRun{Job: job.Name, Started: job.Started, Finished: job.Finished, Result: job.Result, Error: job.Error}
+	m.mux.Unlock()
+	log.Printf("Job %s for %s (%s) %s", job.Name, strings.Join(job.Collections, ", "), job.Id, state)
+
+	if state != JobCancelled {
+		for _, collection := range collections {
+			if err := collection.cache.SaveJobRun(run); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Forget the oldest finished jobs, must be called with the lock held
+func (m *JobManager) prune() {
+	var finished []*Job
+	for _, job := range m.jobs {
+		if !job.Finished.IsZero() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= MaxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Finished.Before(finished[j].Finished)
+	})
+	for _, job := range finished[:len(finished)-MaxFinishedJobs] {
+		delete(m.jobs, job.Id)
+	}
+}
+
+// Current state of the job
+func (m *JobManager) Get(id string) (Job, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errors.New("job not found: " + id)
+	}
+	return job.snapshot(), nil
+}
+
+// Queued or running, must be called with the lock held
+func (job *Job) active() bool {
+	return job.State == JobQueued || job.State == JobRunning
+}
+
+// Copy of the job, must be called with the lock held
+func (job *Job) snapshot() Job {
+	return Job{
+		Id:          job.Id,
+		Name:        job.Name,
+		Collections: job.Collections,
+		State:       job.State,
+		Created:     job.Created,
+		Started:     job.Started,
+		Finished:    job.Finished,
+		Progress:    job.Progress.Load(),
+		Result:      job.Result,
+		Error:       job.Error,
+	}
+}
+
+// Jobs running only in the collections given, sorted by creation
+func (m *JobManager) List(collections map[string]*Collection) []Job {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	list := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if !slices.ContainsFunc(job.Collections, func(c string) bool { return collections[c] == nil }) {
+			list = append(list, job.snapshot())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// Job queued or running for the collection with the name, if any
+func (m *JobManager) Active(collection *Collection, name string) (Job, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, job := range m.jobs {
+		if job.Name == name && job.active() && slices.Contains(job.Collections, collection.Name) {
+			return job.snapshot(), true
+		}
+	}
+	return Job{}, false
+}
+
+// Stop the job, it finishes as soon as possible
+func (m *JobManager) Cancel(id string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return errors.New("job not found: " + id)
+	}
+	if !job.Finished.IsZero() {
+		return errors.New("job already finished: " + id)
+	}
+	job.cancel()
+	// Queued jobs have nothing to stop, they finish right away
+	if job.State == JobQueued {
+		job.State, job.Finished = JobCancelled, time.Now()
+		log.Printf("Job %s for %s (%s) %s", job.Name, strings.Join(job.Collections, ", "), job.Id, job.State)
+	}
+	return nil
+}
+
+// Find the job, the user must be admin of all its collections
+func jobWithAccess(c echo.Context) (Job, error) {
+	job, err := jobManager.Get(c.Param("job"))
+	if err != nil {
+		return job, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	for _, name := range job.Collections {
+		if _, err := collectionAllowing(CurrentUser(c), name, AccessAdmin); err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
+
+func listJobs(c echo.Context) error {
+	user := CurrentUser(c)
+	collections := make(map[string]*Collection)
+	for name, collection := range config.collections {
+		if collection.Allows(user, AccessAdmin) {
+			collections[name] = collection
+		}
+	}
+	return c.JSON(http.StatusOK, jobManager.List(collections))
+}
+
+func startJob(c echo.Context) error {
+	collection, err := CollectionToMaintain(c)
+	if err != nil {
+		return err
+	}
+	name := c.Param("job")
+	if _, ok := Jobs[name]; !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "invalid job: "+name)
+	}
+	started, err := jobManager.Start(collection, name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	job, _ := jobManager.Get(started.Id)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func getJob(c echo.Context) error {
+	job, err := jobWithAccess(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func cancelJob(c echo.Context) error {
+	job, err := jobWithAccess(c)
+	if err != nil {
+		return err
+	}
+	if err := jobManager.Cancel(job.Id); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"ok": true})
+}
+
+// Stream the state of the job with Server-Sent Events until it finishes
+func jobEvents(c echo.Context) error {
+	job, err := jobWithAccess(c)
+	if err != nil {
+		return err
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	var last []byte
+	for {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if string(data) != string(last) {
+			event := "progress"
+			if !job.Finished.IsZero() {
+				event = string(job.State)
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return nil // Client is gone
+			}
+			res.Flush()
+			last = data
+		}
+		if !job.Finished.IsZero() {
+			return nil
+		}
+
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+		if job, err = jobManager.Get(job.Id); err != nil {
+			return nil
+		}
+	}
+}
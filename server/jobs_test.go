@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobProgress(t *testing.T) {
+	collection := newTestCollection(t, "Album", "Other")
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg"), iptcJPEG(t), 0644)
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album", "IMG_0002.jpg"), iptcJPEG(t), 0644)
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Other", "IMG_0003.jpg"), iptcJPEG(t), 0644)
+
+	job, err := jobManager.Run(collection, "quick-scan")
+	if err != nil || job.State != JobCompleted {
+		t.Fatal("Scan failed", job, err)
+	}
+	if job.Progress != (JobProgress{Albums: 2, TotalAlbums: 2, Files: 3}) {
+		t.Error("Unexpected progress", job.Progress)
+	}
+	if photo, err := collection.cache.GetPhotoInfo("Other", "img_0003"); err != nil || photo.Metadata.Title != "Mountains" {
+		t.Error("Photo not scanned", err)
+	}
+	// Albums already scanned are skipped
+	if job, _ = jobManager.Run(collection, "quick-scan"); job.Progress.Files != 0 {
+		t.Error("Unexpected progress", job.Progress)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	Jobs["test-wait"] = func(ctx context.Context, c *Collection) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	defer delete(Jobs, "test-wait")
+
+	running, err := jobManager.Start(collection, "test-wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.cancel()
+	if _, err := jobManager.Start(collection, "test-wait"); err == nil {
+		t.Error("Expected error starting the job twice")
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if job, _ := jobManager.Get(running.Id); job.State == JobRunning {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("Job not running")
+		}
+	}
+	// Waits for the running job
+	queued, err := jobManager.Start(collection, "full-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := jobManager.Get(queued.Id); job.State != JobQueued {
+		t.Error("Job not queued", job.State)
+	}
+
+	for _, job := range []*Job{queued, running} {
+		if err := jobManager.Cancel(job.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cancelled := range []*Job{queued, running} {
+		<-cancelled.done
+		job, _ := jobManager.Get(cancelled.Id)
+		if job.State != JobCancelled || job.Finished.IsZero() {
+			t.Error("Job not cancelled", job)
+		}
+		if err := jobManager.Cancel(job.Id); err == nil {
+			t.Error("Expected error cancelling a finished job")
+		}
+	}
+	if _, ok := jobManager.Active(collection, "test-wait"); ok {
+		t.Error("Cancelled job still active")
+	}
+	if run, err := collection.cache.GetJobRun("full-scan"); err == nil && run != nil {
+		t.Error("Cancelled job saved as run", run)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	release := make(chan struct{})
+	Jobs["test-wait"] = func(ctx context.Context, c *Collection) (string, error) {
+		<-release
+		return "", nil
+	}
+	Jobs["test-queued"] = func(ctx context.Context, c *Collection) (string, error) {
+		return "done", nil
+	}
+	defer delete(Jobs, "test-wait")
+	defer delete(Jobs, "test-queued")
+
+	running, err := jobManager.Start(collection, "test-wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := jobManager.Start(collection, "test-queued")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Finished right away, without waiting for the running job
+	if err := jobManager.Cancel(queued.Id); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := jobManager.Get(queued.Id); job.State != JobCancelled || job.Finished.IsZero() {
+		t.Error("Queued job not cancelled", job)
+	}
+	select {
+	case <-queued.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Cancelled job still waiting")
+	}
+	if job, _ := jobManager.Get(queued.Id); job.State != JobCancelled {
+		t.Error("State of cancelled job changed", job.State)
+	}
+
+	// Can be started again
+	again, err := jobManager.Start(collection, "test-queued")
+	if err != nil {
+		t.Fatal("Cancelled job not started again:", err)
+	}
+	close(release)
+	<-running.done
+	<-again.done
+	if job, _ := jobManager.Get(again.Id); job.State != JobCompleted || job.Result != "done" {
+		t.Error("Job not run after the running one", job)
+	}
+}
+
+func TestCancelledScan(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg"), iptcJPEG(t), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := collection.Scan(ctx, true); err != context.Canceled {
+		t.Error("Expected scan to be cancelled", err)
+	}
+	album, err := collection.GetAlbum("Album")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collection.cache.IsAlbumFullyScanned(album) {
+		t.Error("Album of cancelled scan marked as fully scanned")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	"github.com/mholt/goexif2/exif"
+	"golang.org/x/exp/slices"
+)
+
+// The image and the video of Live Photos share a content identifier set by the camera.
+// Files are grouped in photos by their name, so Live Photos with files renamed on import
+// (e.g. IMG_1234.HEIC and IMG_1234(1).MOV) are paired afterwards by that identifier.
+
+const (
+	appleMakerNoteHeader   = "Apple iOS\x00"
+	appleMakerNoteIFD      = 14 // After the header, version and byte order
+	appleContentIdentifier = 0x11
+)
+
+// Content identifier from the Apple MakerNote of the image, empty if not present
+func AppleContentID(exifInfo *exif.Exif) string {
+	tag, err := exifInfo.Get(exif.MakerNote)
+	if err != nil {
+		return ""
+	}
+	note := tag.Val
+	if len(note) < appleMakerNoteIFD+2 || !bytes.HasPrefix(note, []byte(appleMakerNoteHeader)) {
+		return ""
+	}
+	// Offsets are relative to the start of the MakerNote
+	order := binary.ByteOrder(binary.BigEndian)
+	if string(note[12:14]) == "II" {
+		order = binary.LittleEndian
+	}
+	count := int(order.Uint16(note[appleMakerNoteIFD:]))
+	for i := 0; i < count; i++ {
+		entry := appleMakerNoteIFD + 2 + i*12
+		if entry+12 > len(note) {
+			break
+		}
+		if order.Uint16(note[entry:]) != appleContentIdentifier || order.Uint16(note[entry+2:]) != 2 {
+			continue
+		}
+		size := int(order.Uint32(note[entry+4:]))
+		value := note[entry+8 : entry+12]
+		if size > 4 {
+			offset := int(order.Uint32(note[entry+8:]))
+			if offset < 0 || size < 0 || offset+size > len(note) {
+				return ""
+			}
+			value = note[offset : offset+size]
+		} else {
+			value = value[:size]
+		}
+		return strings.TrimRight(string(value), "\x00")
+	}
+	return ""
+}
+
+// Content identifier of the photo, from the first file that has one
+func (photo *Photo) ContentID() string {
+	for _, file := range photo.Files {
+		if file.ContentID != "" {
+			return file.ContentID
+		}
+	}
+	return ""
+}
+
+// Merge the photos of the album with only videos into the photo with the image that shares
+// their content identifier. Merged photos are removed from the album and the cache.
+func (album *Album) pairLivePhotos(collection *Collection) {
+	byContentID := make(map[string][]*Photo)
+	for _, photo := range album.photosMap {
+		if id := photo.ContentID(); id != "" {
+			byContentID[id] = append(byContentID[id], photo)
+		}
+	}
+
+	flushed := false
+	for _, photos := range byContentID {
+		// Photos with the image first, the name breaks ties
+		sort.Slice(photos, func(i, j int) bool {
+			imageI, imageJ := photos[i].hasImage(), photos[j].hasImage()
+			if imageI != imageJ {
+				return imageI
+			}
+			return photos[i].Id < photos[j].Id
+		})
+		// Only videos are paired, copies of the image are kept apart
+		target, videos := photos[0], photos[1:]
+		for len(videos) > 0 && videos[0].hasImage() {
+			videos = videos[1:]
+		}
+		if !target.hasImage() || len(videos) == 0 {
+			continue
+		}
+		// Pending updates of the photos must be written before they are deleted
+		if !flushed {
+			collection.cache.FinishFlush()
+			flushed = true
+		}
+
+		for _, photo := range videos {
+			for _, file := range photo.Files {
+				if existing, err := target.GetFile(file.Id); err != nil || existing == nil {
+					target.Files = append(target.Files, file)
+				}
+			}
+			delete(album.photosMap, photo.Id)
+			collection.cache.DeletePhotoInfo(photo)
+		}
+		target.FillInfo(collection)
+		collection.cache.AddPhotoInfo(target)
+	}
+}
+
+// Skip the new photos whose files are already in other photos of the album (i.e. videos paired
+// with Live Photos), so they are not created and paired again on every scan. Returns the files left.
+func (album *Album) skipPairedFiles(updatedPhotos map[string]int, updatedFiles []PhotoFile) []PhotoFile {
+	isNew := make(map[*File]bool, len(updatedFiles))
+	for _, updated := range updatedFiles {
+		isNew[updated.file] = true
+	}
+	paired := make(map[string]string) // Photo of the files already known, by path
+	for _, photo := range album.photosMap {
+		for _, file := range photo.Files {
+			if !isNew[file] {
+				paired[file.Path] = photo.Id
+			}
+		}
+	}
+
+	skipped := make(map[string]bool)
+	for id, photo := range album.photosMap {
+		skip := len(photo.Files) > 0
+		for _, file := range photo.Files {
+			if owner, ok := paired[file.Path]; !isNew[file] || !ok || owner == id {
+				skip = false
+			}
+		}
+		if skip {
+			skipped[id] = true
+			delete(album.photosMap, id)
+			delete(updatedPhotos, id)
+		}
+	}
+	if len(skipped) == 0 {
+		return updatedFiles
+	}
+	return slices.DeleteFunc(updatedFiles, func(updated PhotoFile) bool {
+		return skipped[updated.photoId]
+	})
+}
+
+func (photo *Photo) hasImage() bool {
+	for _, file := range photo.Files {
+		if file.Type == "image" {
+			return true
+		}
+	}
+	return false
+}
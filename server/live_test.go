@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testContentID = "0C9A4D4B-6E1D-4F5B-9C6A-2F6F1B8E7A10"
+
+// EXIF with the Apple MakerNote holding the content identifier
+func appleExif() []byte {
+	note := []byte(appleMakerNoteHeader + "\x00\x01MM")
+	note = append(note, 0, 1) // One entry
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry, appleContentIdentifier)
+	binary.BigEndian.PutUint16(entry[2:], 2)
+	binary.BigEndian.PutUint32(entry[4:], uint32(len(testContentID)+1))
+	binary.BigEndian.PutUint32(entry[8:], uint32(len(note)+12+4))
+	note = append(append(note, entry...), 0, 0, 0, 0)
+	note = append(note, testContentID+"\x00"...)
+
+	ifd0Size := uint32(2 + 12 + 4)
+	exifIFD := 8 + ifd0Size
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = append(tiff, ifd(0, ifdEntry(0x8769, 4, 1, exifIFD))...)
+	tiff = append(tiff, ifd(0, ifdEntry(0x927c, 7, uint32(len(note)), exifIFD+ifd0Size))...)
+	return append([]byte("Exif\x00\x00"), append(tiff, note...)...)
+}
+
+func TestLivePhotos(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	dir := filepath.Join(collection.PhotosPath, "Album")
+
+	// Files renamed on import, the video does not share the name of the image
+	fout, err := os.Create(filepath.Join(dir, "IMG_1234.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeImage(fout, gradient(300, 200, false), appleExif()); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	keys := box("keys", be32(0, 1), box("mdta", []byte("com.apple.quicktime.content.identifier")))
+	ilst := box("ilst", box("\x00\x00\x00\x01", box("data", be32(1, 0), []byte(testContentID))))
+	video := append(box("ftyp", []byte("qt  "), be32(0)), box("moov", box("meta", box("hdlr", be32(0, 0, 0, 0, 0, 0)), keys, ilst))...)
+	if err := os.WriteFile(filepath.Join(dir, "IMG_1234(1).MOV"), video, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Videos without identifier are not paired
+	if err := os.WriteFile(filepath.Join(dir, "IMG_1234 copy.MOV"), box("ftyp", []byte("qt  ")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	album, err := collection.GetAlbumWithPhotos("Album", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := album.GetPhoto("img_1234(1)"); err == nil {
+		t.Error("Video of the Live Photo not paired with the image")
+	}
+	photo, err := album.GetPhoto("img_1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Type != "live" || len(photo.Files) != 2 || photo.ContentID() != testContentID {
+		t.Error("Expected Live Photo with image and video", photo.Type, len(photo.Files), photo.ContentID())
+	}
+	if image, err := photo.GetFile("IMG_1234.jpg"); err != nil || image.ContentID != testContentID {
+		t.Error("Content identifier not read from the MakerNote", err)
+	}
+	if _, err := album.GetPhoto("img_1234 copy"); err != nil {
+		t.Error("Video without content identifier must be kept apart", err)
+	}
+
+	// Pairing is kept when the album is scanned again, without extracting the info of the video again
+	collection.cache.FinishFlush()
+	var progress JobProgress
+	album, err = collection.GetAlbumWithPhotosContext(WithProgress(context.Background(), &progress), "Album", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Files != 0 {
+		t.Error("Info of paired files extracted again", progress.Files)
+	}
+	if photo, err := album.GetPhoto("img_1234"); err != nil || len(photo.Files) != 2 {
+		t.Error("Live Photo not paired after scanning again", err)
+	}
+	if _, err := album.GetPhoto("img_1234(1)"); err == nil {
+		t.Error("Video of the Live Photo not paired after scanning again")
+	}
+}
@@ -30,17 +30,17 @@ func GetCollection(collection string) (*Collection, error) {
 }
 
 func collections(c echo.Context) error {
-	return c.JSON(http.StatusOK, GetCollections(config.collections))
+	return c.JSON(http.StatusOK, GetCollections(config.collections, CurrentUser(c)))
 }
 
 func pseudos(c echo.Context) error {
-	return c.JSON(http.StatusOK, GetPseudoAlbums(config.collections))
+	return c.JSON(http.StatusOK, GetPseudoAlbums(config.collections, CurrentUser(c)))
 }
 
 func albums(c echo.Context) error {
-	collection, err := GetCollection(c.Param("collection"))
+	collection, err := CollectionWithAccess(c, AccessRead)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return err
 	}
 
 	// Get all albums from the disk
@@ -53,12 +53,11 @@ func albums(c echo.Context) error {
 }
 
 func album(c echo.Context) error {
-	collectionName := c.Param("collection")
 	albumName := c.Param("album")
 
-	collection, err := GetCollection(collectionName)
+	collection, err := CollectionWithAccess(c, AccessRead)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return err
 	}
 
 	// Fetch album from disk
@@ -66,6 +65,10 @@ func album(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
+	// Photos in pseudo albums can be from collections the user cannot read
+	if album.IsPseudo {
+		album = album.visibleTo(CurrentUser(c))
+	}
 
 	return c.JSON(http.StatusOK, album)
 }
@@ -73,9 +76,9 @@ func album(c echo.Context) error {
 func addAlbum(c echo.Context) error {
 	var albumQuery AddAlbumQuery
 
-	collection, err := GetCollection(c.Param("collection"))
+	collection, err := CollectionWithAccess(c, AccessWrite)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return err
 	}
 	// Decode body
 	if err := c.Bind(&albumQuery); err != nil {
@@ -84,20 +87,70 @@ func addAlbum(c echo.Context) error {
 
 	// Add album
 	err = collection.AddAlbum(albumQuery)
+	if errors.Is(err, ErrReadOnly) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, err.Error())
 	}
 	return c.JSON(http.StatusCreated, map[string]bool{"ok": true})
 }
 
+func renameAlbum(c echo.Context) error {
+	var query RenameAlbumQuery
+
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	// Decode body
+	if err := c.Bind(&query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	album, err := collection.GetAlbum(c.Param("album"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	// Rename album
+	err = collection.RenameAlbum(album, query.Name)
+	if errors.Is(err, ErrReadOnly) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"ok": true})
+}
+
+func deleteAlbum(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessAdmin)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbum(c.Param("album"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	// Delete album
+	err = collection.DeleteAlbum(album, CurrentUser(c))
+	if errors.Is(err, ErrReadOnly) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"ok": true})
+}
+
 func thumb(c echo.Context) error {
-	collectionName := c.Param("collection")
 	albumName := c.Param("album")
 	photoName := c.Param("photo")
 
-	collection, err := GetCollection(collectionName)
+	collection, err := CollectionWithAccess(c, AccessRead)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return err
 	}
 
 	// Fetch album with photos including info
@@ -111,6 +164,9 @@ func thumb(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
+	if _, err := photoCollection(CurrentUser(c), collection, album, photo); err != nil {
+		return err
+	}
 
 	c.Response().Header().Set(echo.HeaderContentType, "image/jpeg")
 	c.Response().Header().Set(echo.HeaderCacheControl, HeaderCacheControl)
@@ -119,13 +175,12 @@ func thumb(c echo.Context) error {
 }
 
 func info(c echo.Context) error {
-	collectionName := c.Param("collection")
 	albumName := c.Param("album")
 	photoName := c.Param("photo")
 
-	collection, err := GetCollection(collectionName)
+	collection, err := CollectionWithAccess(c, AccessRead)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return err
 	}
 
 	// Fetch album with photos including info
@@ -139,6 +194,9 @@ func info(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
+	if _, err := photoCollection(CurrentUser(c), collection, album, photo); err != nil {
+		return err
+	}
 
 	info, err := photo.GetExtendedInfo()
 	if err != nil {
@@ -148,38 +206,49 @@ func info(c echo.Context) error {
 	return c.JSON(http.StatusOK, info)
 }
 
-func file(c echo.Context) error {
-	collectionName := c.Param("collection")
+// Find the file requested, from the collection, album, photo and file in the parameters
+func requestedFile(c echo.Context) (*Collection, *File, error) {
 	albumName := c.Param("album")
 	photoName := c.Param("photo")
 	fileName := c.Param("file")
 
-	collection, err := GetCollection(collectionName)
+	collection, err := CollectionWithAccess(c, AccessRead)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return nil, nil, err
 	}
 
 	// Fetch photo from cache
 	album, err := collection.GetAlbumWithPhotos(albumName, false, false)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 
 	// Find photo
 	photo, err := album.GetPhoto(photoName)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if _, err := photoCollection(CurrentUser(c), collection, album, photo); err != nil {
+		return nil, nil, err
 	}
 
 	// Get file
 	file, err := photo.GetFile(fileName)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return collection, file, nil
+}
+
+func file(c echo.Context) error {
+	collection, file, err := requestedFile(c)
+	if err != nil {
+		return err
 	}
 
 	// Convert files that require conversion
 	if file.RequiresConvertion() {
-		return file.Convert(c.Response())
+		return serveConverted(c, collection, file)
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, file.MIME)
@@ -196,9 +265,14 @@ func saveToPseudo(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	collection, err := GetCollection(c.Param("collection"))
+	collection, err := CollectionWithAccess(c, AccessWrite)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		return err
+	}
+
+	// Photos to add or remove must be visible for the user
+	if _, err := collectionWithAccess(CurrentUser(c), query.Collection, AccessRead); err != nil {
+		return err
 	}
 
 	album, err := collection.GetAlbum(c.Param("album"))
@@ -218,6 +292,9 @@ func saveToPseudo(c echo.Context) error {
 		// Remove photo from pseudo album
 		err = album.EditPseudoAlbum(collection, query, false)
 	}
+	if errors.Is(err, ErrReadOnly) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -229,6 +306,9 @@ func main() {
 	config = ParseCmdArgs()
 	serverAddr := config.host + ":" + strconv.Itoa(config.port)
 	log.Println("Collections:", config.collections)
+	if config.users == nil {
+		log.Println("Warning: authentication is disabled, use --users to enable it")
+	}
 
 	InitWorkers(config)
 	for _, collection := range config.collections {
@@ -237,26 +317,48 @@ func main() {
 			log.Fatal(err)
 		}
 		defer collection.cache.End()
+		if config.watch {
+			if err := collection.Watch(); err != nil {
+				log.Printf("Cannot watch collection %s: %v", collection.Name, err)
+			}
+			defer collection.StopWatching()
+		}
 	}
+	go PurgeTrashPeriodically(config.collections, config.trashRetention)
+	go CleanupUploadsPeriodically(config.collections)
+	if err := scheduler.Start(config.collections); err != nil {
+		log.Fatal(err)
+	}
+	defer scheduler.Stop()
 
 	// Cache albums and thumbnails in background
 	if !config.disableScan {
 		go func() {
 			log.Println("Start scanning for photos in background...")
 			// First cache all albums
+			scan := "quick-scan"
+			if config.fullScan {
+				scan = "full-scan"
+			}
 			for _, collection := range config.collections {
-				collection.Scan(config.fullScan)
+				jobManager.Run(collection, scan)
 			}
 			// Clean thumbnails of deleted photos
 			if config.fullScan {
 				for _, collection := range config.collections {
-					collection.CleanupThumbnails()
+					jobManager.Run(collection, "cleanup-thumbs")
 				}
 			}
 			// Then create thumbnails
 			if config.cacheThumbnails {
 				for _, collection := range config.collections {
-					collection.CreateThumbnails()
+					jobManager.Run(collection, "create-thumbs")
+				}
+			}
+			// Convert files not supported by browsers
+			if config.convertOnScan {
+				for _, collection := range config.collections {
+					collection.CreateConversions()
 				}
 			}
 			log.Println("Background scan complete!")
@@ -272,8 +374,11 @@ func main() {
 	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
 		Skipper: func(c echo.Context) bool {
 			skip := []string{
-				"/api/collections/*/albums/*/photos/*/thumb",   // Skip compressing thumbnails
-				"/api/collections/*/albums/*/photos/*/files/*", // Skip compressing files
+				"/api/collections/*/albums/*/photos/*/thumb",         // Skip compressing thumbnails
+				"/api/collections/*/albums/*/photos/*/preview",       // Skip compressing previews
+				"/api/collections/*/albums/*/photos/*/files/*",       // Skip compressing files
+				"/api/collections/*/albums/*/photos/*/files/*/hls/*", // Skip compressing video streams
+				"/api/jobs/*/events",                                 // Skip compressing event streams
 			}
 			for _, pattern := range skip {
 				if matched, _ := path.Match(pattern, c.Path()); matched {
@@ -318,19 +423,56 @@ func main() {
 
 	// Enable View Status interface
 	if config.debug {
-		ViewStatusInit(e.Group("/status"))
+		ViewStatusInit(e.Group("/status", Authentication(config.users)))
 	}
 
 	// API
-	api := e.Group("/api")
+	api := e.Group("/api", Authentication(config.users, "/api/login", "/api/health"))
+	api.POST("/login", login)
+	api.POST("/logout", logout)
+	api.GET("/user", currentUser)
 	api.GET("/pseudos", pseudos)
+	api.GET("/search", search)
+	api.GET("/timeline", timeline)
+	api.GET("/timeline/buckets", timelineBuckets)
+	api.GET("/map", mapPhotos)
+	api.GET("/duplicates", duplicatesStatus)
+	api.POST("/duplicates", findDuplicates)
+	api.POST("/duplicates/:hash/keep", keepDuplicate)
 	api.GET("/collections", collections)
 	api.GET("/collections/:collection/albums", albums)
 	api.PUT("/collections/:collection/albums", addAlbum)
 	api.GET("/collections/:collection/albums/:album", album)
+	api.DELETE("/collections/:collection/albums/:album", deleteAlbum)
+	api.POST("/collections/:collection/albums/:album/rename", renameAlbum)
+	api.POST("/collections/:collection/albums/:album/photos", upload)
+	api.DELETE("/collections/:collection/albums/:album/photos", deletePhotos)
+	api.POST("/collections/:collection/albums/:album/move", movePhotos)
+	api.POST("/collections/:collection/albums/:album/rate", ratePhotos)
+	api.GET("/collections/:collection/similar", similarClusters)
+	api.GET("/collections/:collection/trash", trash)
+	api.DELETE("/collections/:collection/trash", purgeTrash)
+	api.POST("/collections/:collection/trash/:entry/restore", restoreTrash)
+	api.DELETE("/collections/:collection/trash/:entry", purgeTrash)
+	api.GET("/collections/:collection/jobs", collectionJobs)
+	api.POST("/collections/:collection/jobs/:job", startJob)
+	api.GET("/jobs", listJobs)
+	api.GET("/jobs/:job", getJob)
+	api.DELETE("/jobs/:job", cancelJob)
+	api.GET("/jobs/:job/events", jobEvents)
+	uploads := api.Group("/collections/:collection/albums/:album/uploads", TusMiddleware)
+	uploads.OPTIONS("", tusOptions)
+	uploads.POST("", tusCreate)
+	uploads.HEAD("/:upload", tusHead)
+	uploads.PATCH("/:upload", tusPatch)
+	uploads.DELETE("/:upload", tusDelete)
 	api.GET("/collections/:collection/albums/:album/photos/:photo/thumb", thumb)
+	api.GET("/collections/:collection/albums/:album/photos/:photo/preview", preview)
 	api.GET("/collections/:collection/albums/:album/photos/:photo/info", info)
+	api.GET("/collections/:collection/albums/:album/photos/:photo/similar", similarPhotos)
 	api.GET("/collections/:collection/albums/:album/photos/:photo/files/:file", file)
+	api.GET("/collections/:collection/albums/:album/photos/:photo/files/:file/conversion", conversionStatus)
+	api.GET("/collections/:collection/albums/:album/photos/:photo/files/:file/hls/*", hlsFile)
 	api.PUT("/collections/:collection/albums/:album/pseudos", saveToPseudo)
 	api.DELETE("/collections/:collection/albums/:album/pseudos", saveToPseudo)
 	api.GET("/health", func(c echo.Context) error {
@@ -343,7 +485,7 @@ func main() {
 
 	// WebDAV
 	if !config.webdavDisabled {
-		e.Use(WebDAVWithConfig("/webdav", config.collections))
+		e.Use(WebDAVWithConfig("/webdav", config.collections, config.users))
 		log.Println("WebDAV will be available at http://" + serverAddr + "/webdav")
 	}
 
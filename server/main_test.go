@@ -1,18 +1,41 @@
 package main
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
+// Create an empty collection in a temporary folder with the albums given
+func newTestCollection(t *testing.T, albums ...string) *Collection {
+	collection := NewCollection()
+	collection.Name = "Photos"
+	collection.PhotosPath = t.TempDir()
+	collection.ThumbsPath = t.TempDir()
+	collection.RenameOnReplace = true
+	for _, album := range albums {
+		os.Mkdir(filepath.Join(collection.PhotosPath, album), os.ModePerm)
+	}
+	if err := collection.cache.Init(collection, true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { collection.cache.End() })
+	config.collections = map[string]*Collection{collection.Name: collection}
+	if chInfo == nil {
+		InitWorkers(CmdArgs{nWorkersInfo: 1, nWorkersThumb: 1})
+	}
+	return collection
+}
+
 func TestCreateThumbnails(t *testing.T) {
 	collection := &Collection{
 		Name:       "Photos",
 		PhotosPath: "tests/",
 		ThumbsPath: "tests/.thumbs/"}
 
-	collection.CreateThumbnails()
+	collection.CreateThumbnails(context.Background())
 	// Wait to thumbnails to finish
 	wgThumbs.Wait()
 }
@@ -29,7 +52,7 @@ func TestBenchmarkThumbnails(t *testing.T) {
 	var bytes = 0
 	albums, _ := collection.GetAlbums()
 	for _, album := range albums {
-		album.GetPhotos(collection, false, []PseudoAlbumEntry{}...)
+		album.GetPhotos(context.Background(), collection, false, []PseudoAlbumEntry{}...)
 
 		start := time.Now()
 		for _, photo := range album.photosMap {
@@ -53,7 +76,7 @@ func TestBenchmarkThumbnailAlbum(t *testing.T) {
 	var sum time.Duration = 0
 	var bytes = 0
 	album, _ := collection.GetAlbum("Album 1")
-	album.GetPhotos(collection, false, []PseudoAlbumEntry{}...)
+	album.GetPhotos(context.Background(), collection, false, []PseudoAlbumEntry{}...)
 
 	start := time.Now()
 	for _, photo := range album.photosMap {
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Spatial index of geotagged photos. Locations are converted to quadkeys of Web Mercator
+// tiles, so all photos inside a tile are stored next to each other and can be found by
+// seeking the prefix of the tile. Keys are formed by the quadkey followed by the photo key.
+
+const (
+	GeoIndexBucket     = "GeoIndex"
+	GeoIndexKeysBucket = "GeoIndexKeys" // Location in the index of each photo
+	GeoIndexZoom       = 23             // Precision of the quadkeys, about 5 meters
+	MapClusterZoom     = 2              // Clusters are tiles 2 levels below the map zoom (64 pixels)
+	MapMaxCells        = 4096
+	mercatorMaxLat     = 85.05112878
+)
+
+var ErrMapTooLarge = errors.New("bounding box is too large for the zoom level")
+
+type MapCluster struct {
+	Lat   float64 `json:"lat"` // Center of the photos in the cluster
+	Lng   float64 `json:"lng"`
+	Count int     `json:"count"`
+	Photo *Photo  `json:"photo"` // Most recent photo in the cluster
+	// Used while aggregating
+	cell       string
+	date       int64
+	collection *Collection
+	key        string
+}
+
+type geoPoint struct {
+	Lat  float64
+	Lng  float64
+	Date int64
+}
+
+// Coordinates of the tile for the location at the zoom level
+func tileXY(lat float64, lng float64, zoom int) (x int, y int) {
+	lat = math.Max(-mercatorMaxLat, math.Min(mercatorMaxLat, lat))
+	n := float64(int(1) << zoom)
+	x = int(math.Floor((lng + 180) / 360 * n))
+	rad := lat * math.Pi / 180
+	y = int(math.Floor((1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * n))
+	max := int(n) - 1
+	return int(math.Max(0, math.Min(float64(max), float64(x)))), int(math.Max(0, math.Min(float64(max), float64(y))))
+}
+
+func quadkey(x int, y int, zoom int) string {
+	key := make([]byte, zoom)
+	for i := zoom; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << (i - 1)
+		if x&mask != 0 {
+			digit++
+		}
+		if y&mask != 0 {
+			digit += 2
+		}
+		key[zoom-i] = digit
+	}
+	return string(key)
+}
+
+func geoKey(photo *Photo) []byte {
+	x, y := tileXY(photo.Location.Lat, photo.Location.Long, GeoIndexZoom)
+	return []byte(quadkey(x, y, GeoIndexZoom) + photo.Key())
+}
+
+func encodeGeoPoint(photo *Photo) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, geoPoint{photo.Location.Lat, photo.Location.Long, photo.Date.Unix()})
+	return buf.Bytes()
+}
+
+func decodeGeoPoint(value []byte) (point geoPoint) {
+	binary.Read(bytes.NewReader(value), binary.BigEndian, &point)
+	return
+}
+
+// Update the location of the photo in the spatial index
+func (c *Cache) txUpdateGeoIndex(tx *bolt.Tx, photo *Photo, deleted bool) error {
+	index, keys := tx.Bucket([]byte(GeoIndexBucket)), tx.Bucket([]byte(GeoIndexKeysBucket))
+	if index == nil || keys == nil {
+		return nil // Index is created at startup
+	}
+	photoKey := []byte(photo.Key())
+	if old := keys.Get(photoKey); old != nil {
+		if err := index.Delete(old); err != nil {
+			return err
+		}
+		if err := keys.Delete(photoKey); err != nil {
+			return err
+		}
+	}
+	if deleted || photo.Type == "" || !photo.Location.Present {
+		return nil
+	}
+	key := geoKey(photo)
+	if err := index.Put(key, encodeGeoPoint(photo)); err != nil {
+		return err
+	}
+	return keys.Put(photoKey, key)
+}
+
+// Create the spatial index with the photos in the cache, if it does not exist yet (at initialization)
+func (c *Cache) initGeoIndex() error {
+	return c.store.Bolt().Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(GeoIndexBucket)) != nil {
+			return nil
+		}
+		if _, err := tx.CreateBucket([]byte(GeoIndexBucket)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(GeoIndexKeysBucket)); err != nil {
+			return err
+		}
+		var photos []*Photo
+		if err := c.store.TxFind(tx, &photos, nil); err != nil {
+			return err
+		}
+		for _, photo := range photos {
+			if err := c.txUpdateGeoIndex(tx, photo, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Cluster the photos of the collection inside the tiles, each cluster is a tile at the zoom level
+func (c *Collection) mapClusters(tiles [][2]int, zoom int, bbox []float64, clusters map[string]*MapCluster) error {
+	return c.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Bolt().View(func(tx *bolt.Tx) error {
+			index := tx.Bucket([]byte(GeoIndexBucket))
+			if index == nil {
+				return errors.New("spatial index not found for collection " + c.Name)
+			}
+			cursor := index.Cursor()
+			for _, tile := range tiles {
+				prefix := []byte(quadkey(tile[0], tile[1], zoom))
+				for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+					point := decodeGeoPoint(v)
+					if !insideBBox(bbox, point.Lat, point.Lng) {
+						continue // Tiles on the edges are not completely inside
+					}
+					cluster, ok := clusters[string(prefix)]
+					if !ok {
+						cluster = &MapCluster{cell: string(prefix)}
+						clusters[cluster.cell] = cluster
+					}
+					// Running average of the locations
+					cluster.Count++
+					cluster.Lat += (point.Lat - cluster.Lat) / float64(cluster.Count)
+					cluster.Lng += (point.Lng - cluster.Lng) / float64(cluster.Count)
+					if cluster.collection == nil || point.Date > cluster.date {
+						cluster.date, cluster.collection = point.Date, c
+						cluster.key = string(k[GeoIndexZoom:])
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func insideBBox(bbox []float64, lat float64, lng float64) bool {
+	west, south, east, north := bbox[0], bbox[1], bbox[2], bbox[3]
+	if lat < south || lat > north {
+		return false
+	}
+	// Boxes crossing the antimeridian have west greater than east
+	if west <= east {
+		return lng >= west && lng <= east
+	}
+	return lng >= west || lng <= east
+}
+
+// Tiles at the zoom level covering the bounding box
+func bboxTiles(bbox []float64, zoom int) ([][2]int, error) {
+	west, south, east, north := bbox[0], bbox[1], bbox[2], bbox[3]
+	ranges := [][2]float64{{west, east}}
+	if west > east {
+		ranges = [][2]float64{{west, 180}, {-180, east}}
+	}
+	var tiles [][2]int
+	for _, r := range ranges {
+		x1, y1 := tileXY(north, r[0], zoom)
+		x2, y2 := tileXY(south, r[1], zoom)
+		if len(tiles)+(x2-x1+1)*(y2-y1+1) > MapMaxCells {
+			return nil, ErrMapTooLarge
+		}
+		for x := x1; x <= x2; x++ {
+			for y := y1; y <= y2; y++ {
+				tiles = append(tiles, [2]int{x, y})
+			}
+		}
+	}
+	return tiles, nil
+}
+
+// Clusters of geotagged photos inside the bounding box (west,south,east,north) for the map zoom level
+func MapClusters(collections []*Collection, bbox []float64, zoom int) ([]*MapCluster, error) {
+	cellZoom := zoom + MapClusterZoom
+	if cellZoom > GeoIndexZoom {
+		cellZoom = GeoIndexZoom
+	}
+	tiles, err := bboxTiles(bbox, cellZoom)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clusters from all collections are merged
+	merged := make(map[string]*MapCluster)
+	for _, collection := range collections {
+		clusters := make(map[string]*MapCluster)
+		if err := collection.mapClusters(tiles, cellZoom, bbox, clusters); err != nil {
+			return nil, err
+		}
+		for cell, cluster := range clusters {
+			m, ok := merged[cell]
+			if !ok {
+				merged[cell] = cluster
+				continue
+			}
+			total := float64(m.Count + cluster.Count)
+			m.Lat = (m.Lat*float64(m.Count) + cluster.Lat*float64(cluster.Count)) / total
+			m.Lng = (m.Lng*float64(m.Count) + cluster.Lng*float64(cluster.Count)) / total
+			m.Count += cluster.Count
+			if cluster.date > m.date {
+				m.date, m.collection, m.key = cluster.date, cluster.collection, cluster.key
+			}
+		}
+	}
+
+	result := make([]*MapCluster, 0, len(merged))
+	for _, cluster := range merged {
+		var photo Photo
+		err := cluster.collection.cache.WithStore(func(store *bolthold.Store) error {
+			return store.Get(cluster.key, &photo)
+		})
+		if err == nil {
+			photo.Collection = cluster.collection.Name
+			cluster.Photo = &photo
+		}
+		result = append(result, cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].cell < result[j].cell
+	})
+	return result, nil
+}
+
+func mapPhotos(c echo.Context) error {
+	var bbox []float64
+	zoom := -1
+
+	// Decode query parameters
+	err := echo.QueryParamsBinder(c).
+		BindWithDelimiter("bbox", &bbox, ",").
+		Int("zoom", &zoom).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(bbox) != 4 || bbox[1] > bbox[3] {
+		return echo.NewHTTPError(http.StatusBadRequest, "bbox must be formatted as west,south,east,north")
+	}
+	if zoom < 0 || zoom > GeoIndexZoom {
+		return echo.NewHTTPError(http.StatusBadRequest, "zoom must be between 0 and "+strconv.Itoa(GeoIndexZoom))
+	}
+
+	clusters, err := MapClusters(visibleCollections(CurrentUser(c)), bbox, zoom)
+	if errors.Is(err, ErrMapTooLarge) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, clusters)
+}
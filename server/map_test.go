@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMapClusters(t *testing.T) {
+	photos := newTestCollection(t)
+	others := newTestCollection(t)
+	others.Name = "Others"
+	date := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	lisbon := func(id string, lat float64, lng float64, days int) *Photo {
+		return &Photo{Id: id, Type: "image", Album: "Album", Date: date.AddDate(0, 0, days),
+			Location: GPSLocation{Present: true, Lat: lat, Long: lng}}
+	}
+	photos.cache.AddPhotoInfo(
+		lisbon("a", 38.7071, -9.1355, 0),
+		lisbon("b", 38.7072, -9.1356, 1),
+		lisbon("tokyo", 35.6762, 139.6503, 0),
+		&Photo{Id: "nowhere", Type: "image", Album: "Album"},
+	)
+	others.cache.AddPhotoInfo(lisbon("c", 38.7073, -9.1357, 2))
+	photos.cache.FinishFlush()
+	others.cache.FinishFlush()
+	collections := []*Collection{photos, others}
+
+	// Whole world
+	clusters, err := MapClusters(collections, []float64{-180, -85, 180, 85}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", len(clusters))
+	}
+	total := 0
+	for _, cluster := range clusters {
+		total += cluster.Count
+		if cluster.Count == 3 && (cluster.Photo == nil || cluster.Photo.Id != "c" || cluster.Photo.Collection != "Others") {
+			t.Errorf("Most recent photo not selected: %v", cluster.Photo)
+		}
+	}
+	if total != 4 {
+		t.Errorf("Expected 4 photos, got %d", total)
+	}
+
+	// Only Lisbon, after deleting a photo
+	photos.cache.DeletePhotoInfo(lisbon("a", 38.7071, -9.1355, 0))
+	photos.cache.FinishFlush()
+	clusters, err = MapClusters(collections, []float64{-10, 38, -9, 39}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Count != 2 {
+		t.Errorf("Unexpected clusters: %v", clusters)
+	}
+
+	if _, err := MapClusters(collections, []float64{-180, -85, 180, 85}, 20); err != ErrMapTooLarge {
+		t.Error("Expected error for large bounding box", err)
+	}
+}
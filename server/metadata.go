@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Descriptive metadata written by photo managers (Lightroom, digiKam, darktable...), read from
+// XMP sidecar files and from the XMP and IPTC embedded in the images. When present in more than
+// one place, the sidecar takes precedence over XMP, and XMP over IPTC.
+
+type Metadata struct {
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Rating      int      `json:"rating,omitempty"`  // From 1 to 5, -1 when rejected
+	Label       string   `json:"label,omitempty"`   // Colour label
+	Regions     []string `json:"regions,omitempty"` // Names of the regions, usually people
+	// Rating and label set explicitly, even if to none, are not overridden when merging
+	hasRating, hasLabel bool
+}
+
+// Sidecar file with the metadata of the photo
+type Sidecar struct {
+	Path    string
+	ModTime time.Time
+}
+
+const xmpScanLimit = 4 << 20 // Only the beginning of the file is searched for XMP
+
+// Namespaces of XMP properties
+const (
+	nsRDF        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC         = "http://purl.org/dc/elements/1.1/"
+	nsXMP        = "http://ns.adobe.com/xap/1.0/"
+	nsMWGRegions = "http://www.metadataworkinggroup.com/schemas/regions/"
+	nsMPRegion   = "http://ns.microsoft.com/photo/1.2/t/Region#"
+)
+
+// IPTC datasets of the application record
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcCaption    = 120
+)
+
+// If the file is a XMP sidecar, by its extension
+func IsSidecarFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".xmp")
+}
+
+// Check if no metadata is set
+func (m *Metadata) IsEmpty() bool {
+	return m.Title == "" && m.Description == "" && len(m.Keywords) == 0 && m.Rating == 0 && m.Label == "" && len(m.Regions) == 0
+}
+
+// Fill the fields not set yet with the values of the other metadata
+func (m *Metadata) merge(other *Metadata) {
+	if other == nil {
+		return
+	}
+	if m.Title == "" {
+		m.Title = other.Title
+	}
+	if m.Description == "" {
+		m.Description = other.Description
+	}
+	if len(m.Keywords) == 0 {
+		m.Keywords = other.Keywords
+	}
+	if !m.hasRating && m.Rating == 0 {
+		m.Rating, m.hasRating = other.Rating, other.hasRating
+	}
+	if !m.hasLabel && m.Label == "" {
+		m.Label, m.hasLabel = other.Label, other.hasLabel
+	}
+	if len(m.Regions) == 0 {
+		m.Regions = other.Regions
+	}
+}
+
+// Read the metadata from the XMP sidecar file
+func ReadSidecar(path string) (*Metadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseXMP(data)
+}
+
+// Extract the XMP and IPTC metadata embedded in the file, nil if there is none
+func ExtractMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, xmpScanLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &Metadata{}
+	// XMP packets are stored as is in all formats
+	if start := bytes.Index(data, []byte("<x:xmpmeta")); start >= 0 {
+		if end := bytes.Index(data[start:], []byte("</x:xmpmeta>")); end >= 0 {
+			xmp, err := ParseXMP(data[start : start+end+len("</x:xmpmeta>")])
+			if err != nil {
+				return nil, err
+			}
+			metadata.merge(xmp)
+		}
+	}
+	metadata.merge(parseJPEGIPTC(data))
+	if metadata.IsEmpty() {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+// Parse the properties of the XMP packet
+func ParseXMP(data []byte) (*Metadata, error) {
+	metadata := &Metadata{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []xml.Name
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			// Simple properties can be written as attributes
+			for _, attr := range t.Attr {
+				metadata.setXMPProperty(attr.Name, attr.Value)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			value := strings.TrimSpace(string(t))
+			if value == "" || len(stack) == 0 {
+				continue
+			}
+			// Items of arrays belong to the property holding the array
+			if name := stack[len(stack)-1]; name.Space == nsRDF && name.Local == "li" && len(stack) >= 3 {
+				metadata.setXMPProperty(stack[len(stack)-3], value)
+			} else {
+				metadata.setXMPProperty(name, value)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+func (m *Metadata) setXMPProperty(name xml.Name, value string) {
+	switch name {
+	case xml.Name{Space: nsDC, Local: "title"}:
+		if m.Title == "" { // Default language comes first
+			m.Title = value
+		}
+	case xml.Name{Space: nsDC, Local: "description"}:
+		if m.Description == "" {
+			m.Description = value
+		}
+	case xml.Name{Space: nsDC, Local: "subject"}:
+		if !slices.Contains(m.Keywords, value) {
+			m.Keywords = append(m.Keywords, value)
+		}
+	case xml.Name{Space: nsXMP, Local: "Rating"}:
+		if rating, err := strconv.ParseFloat(value, 64); err == nil && rating >= -1 && rating <= 5 {
+			m.Rating, m.hasRating = int(rating), true
+		}
+	case xml.Name{Space: nsXMP, Local: "Label"}:
+		m.Label, m.hasLabel = value, true
+	case xml.Name{Space: nsMWGRegions, Local: "Name"}, xml.Name{Space: nsMPRegion, Local: "PersonDisplayName"}:
+		if !slices.Contains(m.Regions, value) {
+			m.Regions = append(m.Regions, value)
+		}
+	}
+}
+
+// Parse the IPTC record stored by Photoshop in the APP13 segment of JPEG files
+func parseJPEGIPTC(data []byte) *Metadata {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xff; {
+		marker, size := data[pos+1], int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xda || size < 2 || pos+2+size > len(data) { // Start of scan
+			return nil
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xed && bytes.HasPrefix(segment, []byte("Photoshop 3.0\x00")) {
+			if iptc, err := photoshopResource(segment[14:], 0x0404); err == nil {
+				return parseIPTC(iptc)
+			}
+		}
+		pos += 2 + size
+	}
+	return nil
+}
+
+// Find the resource with the ID in the Photoshop image resource blocks
+func photoshopResource(data []byte, id uint16) ([]byte, error) {
+	for pos := 0; pos+8 <= len(data) && bytes.Equal(data[pos:pos+4], []byte("8BIM")); {
+		resource := binary.BigEndian.Uint16(data[pos+4:])
+		// Name is a Pascal string padded to an even size
+		nameSize := int(data[pos+6]) + 1
+		nameSize += nameSize % 2
+		start := pos + 6 + nameSize + 4
+		if start > len(data) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[start-4:]))
+		if size < 0 || start+size > len(data) {
+			break
+		}
+		if resource == id {
+			return data[start : start+size], nil
+		}
+		pos = start + size + size%2
+	}
+	return nil, errors.New("photoshop resource not found")
+}
+
+// Parse the datasets of the IPTC application record
+func parseIPTC(data []byte) *Metadata {
+	metadata := &Metadata{}
+	for pos := 0; pos+5 <= len(data) && data[pos] == 0x1c; {
+		record, dataset := data[pos+1], data[pos+2]
+		size := int(binary.BigEndian.Uint16(data[pos+3:]))
+		if size&0x8000 != 0 || pos+5+size > len(data) {
+			break // Extended datasets are not used for text
+		}
+		value := strings.TrimSpace(string(data[pos+5 : pos+5+size]))
+		pos += 5 + size
+		if record != 2 || value == "" {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			metadata.Title = value
+		case iptcCaption:
+			metadata.Description = value
+		case iptcKeywords:
+			if !slices.Contains(metadata.Keywords, value) {
+				metadata.Keywords = append(metadata.Keywords, value)
+			}
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmp:Rating="4">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Sunset at the beach</rdf:li></rdf:Alt></dc:description>
+   <dc:subject><rdf:Bag><rdf:li>beach</rdf:li><rdf:li>holidays</rdf:li></rdf:Bag></dc:subject>
+   <mwg-rs:Regions rdf:parseType="Resource">
+    <mwg-rs:RegionList><rdf:Bag>
+     <rdf:li><rdf:Description mwg-rs:Name="Alice" mwg-rs:Type="Face"/></rdf:li>
+     <rdf:li rdf:parseType="Resource"><mwg-rs:Name>Bob</mwg-rs:Name></rdf:li>
+    </rdf:Bag></mwg-rs:RegionList>
+   </mwg-rs:Regions>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestParseXMP(t *testing.T) {
+	metadata, err := ParseXMP([]byte(testXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Title != "Sunset" || metadata.Description != "Sunset at the beach" || metadata.Rating != 4 {
+		t.Error("Unexpected title, description or rating", metadata)
+	}
+	if !slices.Equal(metadata.Keywords, []string{"beach", "holidays"}) {
+		t.Error("Unexpected keywords", metadata.Keywords)
+	}
+	if !slices.Equal(metadata.Regions, []string{"Alice", "Bob"}) {
+		t.Error("Unexpected regions", metadata.Regions)
+	}
+}
+
+// JPEG with IPTC stored in the Photoshop segment
+func iptcJPEG(t *testing.T) []byte {
+	var iptc []byte
+	for _, dataset := range []struct {
+		id    byte
+		value string
+	}{{iptcObjectName, "Mountains"}, {iptcKeywords, "hiking"}, {iptcKeywords, "snow"}, {iptcCaption, "View from the top"}} {
+		iptc = append(iptc, 0x1c, 2, dataset.id, byte(len(dataset.value)>>8), byte(len(dataset.value)))
+		iptc = append(iptc, dataset.value...)
+	}
+	resource := append([]byte("8BIM\x04\x04\x00\x00"), 0, 0, 0, byte(len(iptc)))
+	segment := append([]byte("Photoshop 3.0\x00"), append(resource, iptc...)...)
+	marker := make([]byte, 4)
+	marker[0], marker[1] = 0xff, 0xed
+	binary.BigEndian.PutUint16(marker[2:], uint16(len(segment)+2))
+
+	var img bytes.Buffer
+	if err := EncodeImage(&img, gradient(60, 40, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{0xff, 0xd8}, marker...)
+	data = append(data, segment...)
+	return append(data, img.Bytes()[2:]...)
+}
+
+func TestExtractMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.jpg")
+	if err := os.WriteFile(path, iptcJPEG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := ExtractMetadata(path)
+	if err != nil || metadata == nil {
+		t.Fatal("No metadata extracted", err)
+	}
+	if metadata.Title != "Mountains" || metadata.Description != "View from the top" || !slices.Equal(metadata.Keywords, []string{"hiking", "snow"}) {
+		t.Error("Unexpected IPTC metadata", metadata)
+	}
+
+	// XMP is preferred to IPTC
+	data := append(iptcJPEG(t), testXMP...)
+	os.WriteFile(path, data, 0644)
+	if metadata, _ = ExtractMetadata(path); metadata == nil || metadata.Title != "Sunset" {
+		t.Error("XMP not preferred to IPTC", metadata)
+	}
+
+	os.WriteFile(path, []byte("no metadata"), 0644)
+	if metadata, err = ExtractMetadata(path); metadata != nil || err != nil {
+		t.Error("Expected no metadata", metadata, err)
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	dir := filepath.Join(collection.PhotosPath, "Album")
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.jpg"), iptcJPEG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := filepath.Join(dir, "IMG_0001.jpg.xmp")
+	if err := os.WriteFile(sidecar, []byte(testXMP), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	album, err := collection.GetAlbumWithPhotos("Album", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := album.GetPhoto("img_0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photo.Files) != 1 || len(album.photosMap) != 1 {
+		t.Error("Sidecar must not be a file of the photo", len(photo.Files), len(album.photosMap))
+	}
+	// Sidecar takes precedence over embedded metadata
+	if photo.Metadata.Title != "Sunset" || photo.Metadata.Rating != 4 {
+		t.Error("Metadata not read from sidecar", photo.Metadata)
+	}
+
+	// Changes in the sidecar are found when scanning again
+	os.WriteFile(sidecar, []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="2"/></rdf:RDF></x:xmpmeta>`), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(sidecar, later, later)
+	album, _ = collection.GetAlbumWithPhotos("Album", true, false)
+	photo, _ = album.GetPhoto("img_0001")
+	if photo.Metadata.Rating != 2 || photo.Metadata.Title != "Mountains" {
+		t.Error("Changed sidecar not read", photo.Metadata)
+	}
+	collection.cache.FinishFlush()
+
+	query := &SearchQuery{Keyword: "HIKING", Rating: 2}
+	if found, err := collection.Search(query); err != nil || len(found) != 1 {
+		t.Error("Photo not found by keyword and rating", found, err)
+	}
+	query = &SearchQuery{Text: "view from"}
+	if found, _ := collection.Search(query); len(found) != 1 {
+		t.Error("Photo not found by description", found)
+	}
+	query = &SearchQuery{Rating: 3}
+	if found, _ := collection.Search(query); len(found) != 0 {
+		t.Error("Photo found with lower rating", found)
+	}
+
+	os.Remove(sidecar)
+	album, _ = collection.GetAlbumWithPhotos("Album", true, false)
+	if photo, _ = album.GetPhoto("img_0001"); photo.Sidecar != nil || photo.Metadata.Rating != 0 {
+		t.Error("Removed sidecar still attached", photo.Sidecar, photo.Metadata)
+	}
+}
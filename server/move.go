@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type MovePhotosQuery struct {
+	Collection string   `json:"collection"` // Destination collection
+	Album      string   `json:"album"`      // Destination album
+	SubAlbum   string   `json:"subalbum"`   // Destination sub-album (optional)
+	Photos     []string `json:"photos"`
+}
+
+// Lock two albums always in the same order to avoid deadlocks
+func lockAlbums(c1 *Collection, a1 string, c2 *Collection, a2 string) (unlock func()) {
+	if c1.Name+":"+a1 > c2.Name+":"+a2 {
+		c1, a1, c2, a2 = c2, a2, c1, a1
+	}
+	c1.LockAlbum(a1)
+	c2.LockAlbum(a2)
+	return func() {
+		c2.UnlockAlbum(a2)
+		c1.UnlockAlbum(a1)
+	}
+}
+
+// Find names for the files of the photo that are free in the destination folder.
+// All files keep the same base name, so they are still grouped in the same photo (e.g. Live Photos).
+func destinationNames(photo *Photo, dir string, rename bool) ([]string, error) {
+	for i := 1; ; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = "_" + strconv.Itoa(i)
+		}
+		taken := false
+		names := make([]string, len(photo.Files))
+		for j, file := range photo.Files {
+			ext := filepath.Ext(file.Path)
+			names[j] = filepath.Join(dir, strings.TrimSuffix(filepath.Base(file.Path), ext)+suffix+ext)
+			if _, err := os.Stat(names[j]); err == nil {
+				taken = true
+			}
+		}
+		if !taken {
+			return names, nil
+		}
+		if !rename {
+			return nil, errors.New("photo already exists in destination: " + photo.Title)
+		}
+	}
+}
+
+// Move the files and the thumbnail of the photo, returns the new location of the files
+func (c *Collection) movePhoto(album *Album, dst *Collection, dstAlbum *Album, dir string, photo *Photo) ([]string, error) {
+	paths, err := destinationNames(photo, dir, dst.RenameOnReplace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, file := range photo.Files {
+		if err := moveFile(file.Path, paths[i]); err != nil {
+			// Put back files already moved
+			for j := 0; j < i; j++ {
+				if err := moveFile(paths[j], photo.Files[j].Path); err != nil {
+					log.Println(err)
+				}
+			}
+			return nil, err
+		}
+	}
+
+	// Relocate the thumbnail, its location depends on the photo
+	if hasThumb, thumbPath := photo.ThumbnailPresent(c); hasThumb {
+		newPhoto := Photo{Id: photoIdFromPath(filepath.Join(dst.PhotosPath, dstAlbum.Name), paths[0]), Album: dstAlbum.Name}
+		newThumbPath := newPhoto.ThumbnailPath(dst)
+		if err := os.MkdirAll(filepath.Dir(newThumbPath), os.ModePerm); err == nil {
+			err = moveFile(thumbPath, newThumbPath)
+		}
+		if err != nil {
+			log.Println("Thumbnail not moved:", err)
+		}
+	}
+
+	// Previews are generated again when requested
+	photo.RemovePreviews(c)
+
+	// Remove from the source album
+	album.removePhoto(photo.Id)
+	c.cache.DeletePhotoInfo(photo)
+	return paths, nil
+}
+
+// Move photos with all their files to another album, which can be in another collection.
+// References in pseudo albums are updated to the new location.
+func (c *Collection) MovePhotos(album *Album, dst *Collection, dstAlbum *Album, subAlbum string, photos ...*Photo) ([]*Photo, error) {
+	if err := c.CheckWritable(); err != nil {
+		return nil, err
+	}
+	if err := dst.CheckWritable(); err != nil {
+		return nil, err
+	}
+	if album.IsPseudo || dstAlbum.IsPseudo {
+		return nil, errors.New("photos can only be moved between regular albums")
+	}
+	if c == dst && album.Name == dstAlbum.Name {
+		return nil, errors.New("photos are already in the album " + album.Name)
+	}
+	dir, err := dst.subAlbumDir(dstAlbum, subAlbum)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := lockAlbums(c, album.Name, dst, dstAlbum.Name)
+	var paths, sidecars []string
+	var moved []*Photo
+	for _, photo := range photos {
+		photoPaths, err := c.movePhoto(album, dst, dstAlbum, dir, photo)
+		if err != nil {
+			log.Printf("Cannot move %s[%s] %s: %v", c.Name, album.Name, photo.Title, err)
+			continue
+		}
+		paths = append(paths, photoPaths...)
+		moved = append(moved, photo)
+		// The sidecar is named after the photo in the destination
+		if photo.Sidecar != nil {
+			sidecar := strings.TrimSuffix(photoPaths[0], filepath.Ext(photoPaths[0])) + ".xmp"
+			if err := moveFile(photo.Sidecar.Path, sidecar); err != nil {
+				log.Println("Sidecar not moved:", err)
+			} else {
+				sidecars = append(sidecars, sidecar)
+			}
+		}
+	}
+	c.cache.FlushInfo()
+	// Add to the destination album, sidecars after all files
+	dstPhotos := dst.addMovedPhotos(dstAlbum, append(paths, sidecars...), moved)
+	unlock()
+
+	// Update references in pseudo albums
+	var result []*Photo
+	changes := make(map[PseudoAlbumEntry]PseudoAlbumEntry)
+	for i, photo := range moved {
+		if dstPhotos[i] == nil {
+			continue
+		}
+		src := PseudoAlbumEntry{Collection: c.Name, Album: album.Name, Photo: photo.Id}
+		changes[src] = PseudoAlbumEntry{Collection: dst.Name, Album: dstAlbum.Name, Photo: dstPhotos[i].Id}
+		result = append(result, dstPhotos[i])
+	}
+	RewritePseudoAlbums(config.collections, func(_ PseudoAlbum, entry PseudoAlbumEntry) (PseudoAlbumEntry, bool) {
+		if newEntry, ok := changes[entry]; ok {
+			return newEntry, true
+		}
+		return entry, true
+	})
+
+	if len(result) < len(photos) {
+		return result, errors.New("some photos could not be moved")
+	}
+	return result, nil
+}
+
+// Add the moved files to the album keeping the data that is not extracted from files.
+// Returns the new photos in the same order as the moved ones.
+func (c *Collection) addMovedPhotos(album *Album, paths []string, moved []*Photo) []*Photo {
+	// Photos are created before adding the files, they must not be changed after being queued to the cache
+	dir := filepath.Join(c.PhotosPath, album.Name)
+	photos := make([]*Photo, len(moved))
+	i := 0
+	album.mux.Lock()
+	for n, src := range moved {
+		photo := album.photoForFile(c, dir, paths[i])
+		i += len(src.Files)
+		photo.Favorite = src.Favorite
+		photo.PHash, photo.HasPHash = src.PHash, src.HasPHash
+		photos[n] = photo
+	}
+	album.mux.Unlock()
+
+	album.AddFiles(c, paths...)
+	return photos
+}
+
+func movePhotos(c echo.Context) error {
+	var query MovePhotosQuery
+
+	// Decode body
+	if err := c.Bind(&query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	dst, err := collectionWithAccess(CurrentUser(c), query.Collection, AccessWrite)
+	if err != nil {
+		return err
+	}
+
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	dstAlbum, err := dst.GetAlbumWithPhotos(query.Album, false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var photos []*Photo
+	for _, id := range query.Photos {
+		photo, err := album.GetPhoto(id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		photos = append(photos, photo)
+	}
+
+	moved, err := collection.MovePhotos(album, dst, dstAlbum, query.SubAlbum, photos...)
+	if err != nil && len(moved) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusMultiStatus, moved)
+	}
+	return c.JSON(http.StatusOK, moved)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMovePhotos(t *testing.T) {
+	collection := newTestCollection(t, "Album 1", "Album 2")
+	// Live photo in the source album and a photo with the same name in the destination
+	for _, file := range []string{"Album 1/IMG_0001.HEIC", "Album 1/IMG_0001.MOV", "Album 2/IMG_0001.HEIC"} {
+		os.WriteFile(filepath.Join(collection.PhotosPath, file), []byte("data"), 0644)
+	}
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Favorites"+PSEUDO_ALBUM_EXT), []byte("Photos:Album 1:img_0001\n"), 0644)
+
+	album, err := collection.GetAlbumWithPhotos("Album 1", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstAlbum, err := collection.GetAlbumWithPhotos("Album 2", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := album.GetPhoto("img_0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := collection.MovePhotos(album, collection, dstAlbum, "", photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 1 || moved[0].Id != "img_0001_2" || len(moved[0].Files) != 2 {
+		t.Fatalf("Photo not moved with all its files: %v", moved)
+	}
+	for _, file := range []string{"Album 2/IMG_0001_2.HEIC", "Album 2/IMG_0001_2.MOV"} {
+		if _, err := os.Stat(filepath.Join(collection.PhotosPath, file)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := album.GetPhoto("img_0001"); err == nil {
+		t.Error("Photo still in the source album")
+	}
+
+	// Pseudo album references the new location
+	entries, err := readPseudoAlbum(collection, &Album{Name: "Favorites", IsPseudo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PseudoAlbumEntry{Collection: "Photos", Album: "Album 2", Photo: "img_0001_2"}
+	if len(entries) != 1 || entries[0] != expected {
+		t.Errorf("Pseudo album not updated: %v", entries)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"hash/fnv"
@@ -8,8 +9,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/disintegration/imaging"
 	"golang.org/x/exp/slices"
 )
 
@@ -26,8 +29,25 @@ type Photo struct {
 	Date       time.Time     `json:"date" boltholdIndex:"Date"`
 	Location   GPSLocation   `json:"location"`
 	Files      []*File       `json:"files"`
+	Metadata   Metadata      `json:"metadata"`
+	Sidecar    *Sidecar      `json:"-"`                                // XMP sidecar with metadata of the photo
 	HasThumb   bool          `json:"-"`                                // Indicates if the thumbnail was generated
 	FileSizes  []int64       `json:"-" boltholdSliceIndex:"FileSizes"` // Photo total size, used to find duplicates
+	PHash      uint64        `json:"-"`                                // Perceptual hash, used to find similar photos
+	HasPHash   bool          `json:"-"`                                // Indicates if the perceptual hash was computed
+}
+
+// Copy of the photo with its files, to be stored while the photo can still change
+func (photo *Photo) snapshot() *Photo {
+	copied := *photo
+	copied.Favorite = slices.Clone(photo.Favorite)
+	copied.FileSizes = slices.Clone(photo.FileSizes)
+	copied.Files = make([]*File, len(photo.Files))
+	for i, file := range photo.Files {
+		f := *file
+		copied.Files[i] = &f
+	}
+	return &copied
 }
 
 // Add pseudo album to the favorites list
@@ -72,6 +92,16 @@ func convertBase36(value uint32, n int) string {
 
 // Returns the path location for the thumbnail
 func (photo *Photo) ThumbnailPath(collection *Collection) string {
+	return photo.shardedPath(filepath.Join(collection.ThumbsPath, collection.Name+"-thumbs"))
+}
+
+// Location of the preview of the photo resized to the size, with the same layout of thumbnails
+func (photo *Photo) PreviewPath(collection *Collection, size int) string {
+	return photo.shardedPath(filepath.Join(collection.PreviewsPath(), strconv.Itoa(size)))
+}
+
+// Location of a file derived from the photo, spread across folders inside the base path
+func (photo *Photo) shardedPath(base string) string {
 	hasher := fnv.New32a()
 	hasher.Write([]byte(photo.Id))
 	hash1 := hasher.Sum32()
@@ -83,7 +113,7 @@ func (photo *Photo) ThumbnailPath(collection *Collection) string {
 	dir1 := convertBase36(hash2, 2) // Max of 36^2=1296 folders
 	dir2 := convertBase36(hash3, 2) // Max of 36^2=1296 sub-folders
 	name := convertBase36(hash1, 6) // 36^6 is a little more than half of uint32, 7th char only is 0 or 1
-	return filepath.Join(collection.ThumbsPath, collection.Name+"-thumbs", dir1, dir2, name+".jpg")
+	return filepath.Join(base, dir1, dir2, name+".jpg")
 }
 
 // Check if the photo has thumbnail generated
@@ -95,6 +125,15 @@ func (photo *Photo) ThumbnailPresent(collection *Collection) (has bool, path str
 	return has, path
 }
 
+// Delete the thumbnail and the previews of the photo, they are generated again when requested
+func (photo *Photo) RemoveThumbnails(collection *Collection) {
+	if hasThumb, thumbPath := photo.ThumbnailPresent(collection); hasThumb {
+		os.Remove(thumbPath)
+	}
+	photo.RemovePreviews(collection)
+	photo.HasThumb = false
+}
+
 // Gets a file from the photo
 func (photo *Photo) GetFile(id string) (*File, error) {
 	for _, file := range photo.Files {
@@ -114,6 +153,12 @@ func (photo *Photo) MainFile() *File {
 	case size == 1:
 		return photo.Files[0]
 	default:
+		// JPEG of RAW+JPEG pairs is preferred
+		for _, file := range photo.Files {
+			if file.Type == "image" && !IsRawFile(file.Path) {
+				return file
+			}
+		}
 		for _, file := range photo.Files {
 			if file.Type == "image" {
 				return file
@@ -130,8 +175,9 @@ func (photo *Photo) MainFile() *File {
 
 func (photo *Photo) GetThumbnail(collection *Collection, album *Album, w io.Writer) error {
 	// Update flag to indicate that the thumbnail was generated
+	updated := false
 	defer func() {
-		if !photo.HasThumb {
+		if !photo.HasThumb || updated {
 			photo.HasThumb = true
 			collection.cache.AddPhotoInfo(photo)
 		}
@@ -147,6 +193,15 @@ func (photo *Photo) GetThumbnail(collection *Collection, album *Album, w io.Writ
 		if w != nil {
 			w.Write(data)
 		}
+		// Perceptual hash missing (e.g. thumbnail generated by an older version)
+		if !photo.HasPHash {
+			img, err := imaging.Decode(bytes.NewReader(data))
+			if err != nil {
+				log.Println(err)
+				return nil
+			}
+			photo.PHash, photo.HasPHash, updated = PerceptualHash(img), true, true
+		}
 	} else {
 		// Select file to create the thumbnail from
 		selected := photo.MainFile()
@@ -154,12 +209,13 @@ func (photo *Photo) GetThumbnail(collection *Collection, album *Album, w io.Writ
 			return errors.New("no source file to generate thumbnail from")
 		}
 		// Create thumbnail
-		err := selected.CreateThumbnail(thumbPath, w)
+		hash, err := selected.CreateThumbnail(thumbPath, w)
 		if err != nil {
 			err := fmt.Errorf("failed to creating thumbnail for [%s] %s: %v", album.Name, photo.Title, err)
 			log.Println(err)
 			return err
 		}
+		photo.PHash, photo.HasPHash, updated = hash, true, true
 	}
 
 	return nil
@@ -168,6 +224,7 @@ func (photo *Photo) GetThumbnail(collection *Collection, album *Album, w io.Writ
 func (photo *Photo) FillInfo(collection *Collection) error {
 	var countImages = 0
 	var countVideos = 0
+	photo.FileSizes = nil
 	for _, file := range photo.Files {
 		// Type
 		switch file.Type {
@@ -216,6 +273,20 @@ func (photo *Photo) FillInfo(collection *Collection) error {
 	}
 	photo.Date = selected.Date
 	photo.Location = selected.Location
+
+	// Metadata from the sidecar takes precedence over the embedded in the files
+	photo.Metadata = Metadata{}
+	if photo.Sidecar != nil {
+		sidecar, err := ReadSidecar(photo.Sidecar.Path)
+		if err != nil {
+			log.Printf("Could not read sidecar %s: %v\n", photo.Sidecar.Path, err)
+		}
+		photo.Metadata.merge(sidecar)
+	}
+	photo.Metadata.merge(selected.Metadata)
+	for _, file := range photo.Files {
+		photo.Metadata.merge(file.Metadata)
+	}
 	return nil
 }
 
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Sizes of the previews in pixels, for the longest side of the image
+var PreviewDefaultSizes = []int{720, 1440, 2160}
+
+// Location of the previews of the collection, one folder per size
+func (c *Collection) PreviewsPath() string {
+	return filepath.Join(c.ThumbsPath, c.Name+"-previews")
+}
+
+// Select the smallest size that is at least the requested, or the largest available.
+// Sizes must be sorted.
+func previewSize(sizes []int, requested int) int {
+	for _, size := range sizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// Get the preview of the photo resized to the size, it is generated if it does not
+// exist yet or the photo was changed after. Returns the location of the preview.
+func (photo *Photo) GetPreview(collection *Collection, size int) (string, error) {
+	selected := photo.MainFile()
+	if selected == nil {
+		return "", errors.New("no source file to generate preview from")
+	}
+	source, err := os.Stat(selected.Path)
+	if err != nil {
+		return "", err
+	}
+	path := photo.PreviewPath(collection, size)
+	if stat, err := os.Stat(path); err == nil && !stat.ModTime().Before(source.ModTime()) {
+		return path, nil // Cached preview
+	}
+
+	img, err := selected.DecodeImage()
+	if err != nil {
+		return "", err
+	}
+	// Ensure the directories exist
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	return path, CreatePreviewFromImage(img, path, size)
+}
+
+// Delete the previews of the photo in all sizes
+func (photo *Photo) RemovePreviews(collection *Collection) {
+	entries, err := os.ReadDir(collection.PreviewsPath())
+	if err != nil {
+		return // No previews were generated
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			os.Remove(photo.shardedPath(filepath.Join(collection.PreviewsPath(), entry.Name())))
+		}
+	}
+}
+
+// Move the previews of the photo in all sizes to the location of the other photo
+func (photo *Photo) MovePreviews(collection *Collection, to *Photo) {
+	entries, err := os.ReadDir(collection.PreviewsPath())
+	if err != nil {
+		return // No previews were generated
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		base := filepath.Join(collection.PreviewsPath(), entry.Name())
+		oldPath, newPath := photo.shardedPath(base), to.shardedPath(base)
+		if _, err := os.Stat(oldPath); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+			log.Println("Preview not moved:", err)
+		} else if err := moveFile(oldPath, newPath); err != nil {
+			log.Println("Preview not moved:", err)
+		}
+	}
+}
+
+// Delete previews without the corresponding thumbnail in the set to keep
+func (c *Collection) cleanupPreviews(keep map[string]struct{}) {
+	// As defined in photo.PreviewPath, is exactly size/12/12/123456.jpg
+	files, err := filepath.Glob(filepath.Join(c.PreviewsPath(), "*", "??", "??", "??????.jpg"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	thumbs := filepath.Join(c.ThumbsPath, c.Name+"-thumbs")
+	for _, file := range files {
+		dir, name := filepath.Split(file)
+		dir2 := filepath.Dir(dir)
+		dir1 := filepath.Dir(dir2)
+		thumb := filepath.Join(thumbs, filepath.Base(dir1), filepath.Base(dir2), name)
+		if _, ok := keep[thumb]; !ok {
+			log.Println("Deleting preview", file)
+			if err := os.Remove(file); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func preview(c echo.Context) error {
+	sizes := config.previewSizes
+	size := sizes[len(sizes)-1]
+
+	// Decode query parameters
+	err := echo.QueryParamsBinder(c).Int("size", &size).BindError()
+	if err != nil || size < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "size must be a positive number of pixels")
+	}
+
+	collection, err := CollectionWithAccess(c, AccessRead)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	photo, err := album.GetPhoto(c.Param("photo"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	// Photos in pseudo albums share the previews of the original
+	if collection, err = photoCollection(CurrentUser(c), collection, album, photo); err != nil {
+		return err
+	}
+
+	path, err := AddPreviewForeground(collection, album, photo, previewSize(sizes, size))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, HeaderCacheControl)
+	return c.File(path)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestPreviewSize(t *testing.T) {
+	sizes := []int{720, 1440, 2160}
+	for requested, expected := range map[int]int{1: 720, 720: 720, 721: 1440, 2000: 2160, 4000: 2160} {
+		if size := previewSize(sizes, requested); size != expected {
+			t.Errorf("Size %d selected for %d, expected %d", size, requested, expected)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	if err := imaging.Save(gradient(3000, 2000, false), filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	album, err := collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := album.GetPhoto("img_0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := AddPreviewForeground(collection, album, photo, 1440)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != photo.PreviewPath(collection, 1440) {
+		t.Error("Preview not stored in the expected location", path)
+	}
+	img, err := imaging.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 1440 || img.Bounds().Dy() != 960 {
+		t.Error("Unexpected size of preview", img.Bounds())
+	}
+
+	// Cached preview is used
+	stat, _ := os.Stat(path)
+	if _, err := photo.GetPreview(collection, 1440); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.Stat(path); !again.ModTime().Equal(stat.ModTime()) {
+		t.Error("Preview generated again")
+	}
+
+	// Previews of photos without thumbnails are cleaned up
+	collection.CleanupThumbnails(context.Background())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Preview of photo without thumbnail not deleted")
+	}
+
+	photo.GetPreview(collection, 720)
+	photo.RemovePreviews(collection)
+	if _, err := os.Stat(photo.PreviewPath(collection, 720)); !os.IsNotExist(err) {
+		t.Error("Preview not removed")
+	}
+}
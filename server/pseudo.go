@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"golang.org/x/exp/slices"
@@ -49,7 +50,10 @@ func (photo *Photo) CopyForPseudoAlbum() *Photo {
 		Location:   photo.Location,
 		Favorite:   photo.Favorite,
 		Files:      photo.Files,
+		Metadata:   photo.Metadata,
 		HasThumb:   photo.HasThumb,
+		PHash:      photo.PHash,
+		HasPHash:   photo.HasPHash,
 	}
 }
 
@@ -143,10 +147,14 @@ func resolveQueryPhotos(query PseudoAlbumSaveQuery) (list []PseudoAlbumEntry, er
 	return
 }
 
-func GetPseudoAlbums(collections map[string]*Collection) []PseudoAlbum {
+func GetPseudoAlbums(collections map[string]*Collection, user *User) []PseudoAlbum {
 	pseudos := make([]PseudoAlbum, 0)
 
 	for name, collection := range collections {
+		// Skip collections not visible for the user
+		if !collection.Allows(user, AccessRead) {
+			continue
+		}
 		albums, _ := collection.GetAlbums()
 		for _, album := range albums {
 			if album.IsPseudo {
@@ -158,7 +166,88 @@ func GetPseudoAlbums(collections map[string]*Collection) []PseudoAlbum {
 	return pseudos
 }
 
+// Rewrite the entries of pseudo albums in all collections, e.g. when photos are moved or deleted.
+// The function returns the entry to be saved in place of the current one or false to remove it.
+func RewritePseudoAlbums(collections map[string]*Collection, rewrite func(pseudo PseudoAlbum, entry PseudoAlbumEntry) (PseudoAlbumEntry, bool)) {
+	for _, collection := range collections {
+		albums, err := collection.GetAlbums()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, album := range albums {
+			if !album.IsPseudo {
+				continue
+			}
+			collection.LockAlbum(album.Name)
+			entries, err := readPseudoAlbum(collection, album)
+			if err != nil {
+				log.Println(err)
+				collection.UnlockAlbum(album.Name)
+				continue
+			}
+
+			changed := false
+			pseudo := PseudoAlbum{Collection: collection.Name, Album: album.Name}
+			updated := make([]PseudoAlbumEntry, 0, len(entries))
+			for _, entry := range entries {
+				newEntry, keep := rewrite(pseudo, entry)
+				if !keep || newEntry != entry {
+					changed = true
+				}
+				if keep {
+					updated = append(updated, newEntry)
+				}
+			}
+
+			if changed {
+				if err := collection.CheckWritable(); err != nil {
+					log.Printf("Cannot update references in pseudo album %s[%s]: %v", collection.Name, album.Name, err)
+				} else if err := writePseudoAlbum(collection, album, updated...); err != nil {
+					log.Println(err)
+				}
+				// Load again with the updated entries
+				collection.cache.RemoveAlbum(album.Name)
+			}
+			collection.UnlockAlbum(album.Name)
+		}
+	}
+}
+
+// Add entries to a pseudo album, skipping the ones already there
+func addToPseudoAlbum(pseudo PseudoAlbum, add ...PseudoAlbumEntry) error {
+	collection, err := GetCollection(pseudo.Collection)
+	if err != nil {
+		return err
+	}
+	if err := collection.CheckWritable(); err != nil {
+		return err
+	}
+	album, err := collection.GetAlbum(pseudo.Album)
+	if err != nil {
+		return err
+	}
+
+	collection.LockAlbum(album.Name)
+	defer collection.UnlockAlbum(album.Name)
+	entries, err := readPseudoAlbum(collection, album)
+	if err != nil {
+		return err
+	}
+	for _, entry := range add {
+		if !slices.Contains(entries, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	// Load again with the new entries
+	collection.cache.RemoveAlbum(album.Name)
+	return writePseudoAlbum(collection, album, entries...)
+}
+
 func (album *Album) EditPseudoAlbum(collection *Collection, query PseudoAlbumSaveQuery, isAdd bool) error {
+	if err := collection.CheckWritable(); err != nil {
+		return err
+	}
 	// Lock album to avoid concurrent edits
 	collection.LockAlbum(album.Name)
 	defer collection.UnlockAlbum(album.Name)
@@ -214,6 +303,24 @@ func (album *Album) EditPseudoAlbum(collection *Collection, query PseudoAlbumSav
 	return nil
 }
 
+// Pseudo album with only the photos from collections that the user can read. The album is
+// cached with the photos of all collections, since it is shared by all users.
+func (album *Album) visibleTo(user *User) *Album {
+	visible := &Album{Name: album.Name, Date: album.Date, IsPseudo: true, photosMap: make(map[string]*Photo)}
+	subAlbums := make(map[string]bool)
+	for _, photo := range album.PhotoList() {
+		if collection, err := GetCollection(photo.Collection); err == nil && collection.Allows(user, AccessRead) {
+			visible.photosMap[photo.Id] = photo
+			subAlbums[photo.SubAlbum] = true
+		}
+	}
+	for subAlbum := range subAlbums {
+		visible.SubAlbums = append(visible.SubAlbums, subAlbum)
+	}
+	sort.Strings(visible.SubAlbums)
+	return visible
+}
+
 func (album *Album) GetPhotosForPseudo(collection *Collection, isAdd bool, runningInBackground bool, entries ...PseudoAlbumEntry) []*Photo {
 	var photos []*Photo
 	var grouped = make(map[string]map[string][]PseudoAlbumEntry)
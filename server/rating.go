@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
+)
+
+// Ratings and colour labels are saved in XMP sidecars next to the files of the photos, where
+// other photo managers can read them too. The cache is updated from the sidecars when scanning.
+
+// Colour labels, as used by Lightroom
+var Labels = []string{"Red", "Yellow", "Green", "Blue", "Purple"}
+
+type RatePhotosQuery struct {
+	Photos []string `json:"photos"`
+	Rating *int     `json:"rating"` // From 0 (unrated) to 5, unchanged if not set
+	Label  *string  `json:"label"`  // One of the labels or empty, unchanged if not set
+}
+
+const emptySidecar = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/"/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+var xmpDescription = regexp.MustCompile(`<rdf:Description\b`)
+
+// Location of the sidecar of the photo, the existing one or a new one named after the photo
+func (photo *Photo) SidecarPath() (string, error) {
+	if photo.Sidecar != nil {
+		return photo.Sidecar.Path, nil
+	}
+	selected := photo.MainFile()
+	if selected == nil {
+		return "", errors.New("no file to save the sidecar next to")
+	}
+	return strings.TrimSuffix(selected.Path, filepath.Ext(selected.Path)) + ".xmp", nil
+}
+
+// Save the rating and the label of the photo to its sidecar, nil values are left unchanged
+func (photo *Photo) SaveRating(collection *Collection, rating *int, label *string) error {
+	path, err := photo.SidecarPath()
+	if err != nil {
+		return err
+	}
+	xmp, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		xmp = []byte(emptySidecar)
+	} else if err != nil {
+		return err
+	}
+
+	if rating != nil {
+		if xmp, err = setXMPProperty(xmp, "xmp:Rating", strconv.Itoa(*rating)); err != nil {
+			return err
+		}
+	}
+	if label != nil {
+		if xmp, err = setXMPProperty(xmp, "xmp:Label", *label); err != nil {
+			return err
+		}
+	}
+	// Check that the sidecar is still valid before replacing it
+	if _, err := ParseXMP(xmp); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sidecar-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(xmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	photo.Sidecar = &Sidecar{path, stat.ModTime()}
+	photo.FillInfo(collection)
+	collection.cache.AddPhotoInfo(photo)
+	return nil
+}
+
+// Set the simple property (e.g. xmp:Rating) of the XMP packet, written either as an attribute
+// or as an element. New properties are added as attributes of the first description.
+func setXMPProperty(xmp []byte, name string, value string) ([]byte, error) {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(value))
+	prefix := name[:strings.Index(name, ":")]
+	quoted := regexp.QuoteMeta(name)
+
+	attr := regexp.MustCompile(`(\s` + quoted + `\s*=\s*)(?:"[^"]*"|'[^']*')`)
+	if attr.Match(xmp) {
+		return attr.ReplaceAllLiteral(xmp, []byte(" "+name+`="`+escaped.String()+`"`)), nil
+	}
+	element := regexp.MustCompile(`<` + quoted + `\s*>[^<]*</` + quoted + `\s*>|<` + quoted + `\s*/>`)
+	if element.Match(xmp) {
+		return element.ReplaceAllLiteral(xmp, []byte("<"+name+">"+escaped.String()+"</"+name+">")), nil
+	}
+
+	loc := xmpDescription.FindIndex(xmp)
+	if loc == nil {
+		return nil, errors.New("no description found in sidecar")
+	}
+	attrs := " " + name + `="` + escaped.String() + `"`
+	if !bytes.Contains(xmp, []byte("xmlns:"+prefix+"=")) {
+		attrs += ` xmlns:` + prefix + `="` + nsXMP + `"`
+	}
+	return append(xmp[:loc[1]:loc[1]], append([]byte(attrs), xmp[loc[1]:]...)...), nil
+}
+
+// Set the rating and label of several photos of the album
+func (c *Collection) RatePhotos(album *Album, rating *int, label *string, photos ...*Photo) ([]*Photo, error) {
+	if err := c.CheckWritable(); err != nil {
+		return nil, err
+	}
+	if album.IsPseudo {
+		return nil, errors.New("photos can only be rated in regular albums")
+	}
+
+	c.LockAlbum(album.Name)
+	defer c.UnlockAlbum(album.Name)
+	var rated []*Photo
+	for _, photo := range photos {
+		if err := photo.SaveRating(c, rating, label); err != nil {
+			log.Printf("Cannot rate %s[%s] %s: %v", c.Name, album.Name, photo.Title, err)
+			continue
+		}
+		rated = append(rated, photo)
+	}
+	c.cache.FlushInfo()
+
+	if len(rated) < len(photos) {
+		return rated, errors.New("some photos could not be rated")
+	}
+	return rated, nil
+}
+
+func ratePhotos(c echo.Context) error {
+	var query RatePhotosQuery
+
+	// Decode body
+	if err := c.Bind(&query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if query.Rating == nil && query.Label == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "rating or label must be set")
+	}
+	if query.Rating != nil && (*query.Rating < 0 || *query.Rating > 5) {
+		return echo.NewHTTPError(http.StatusBadRequest, "rating must be between 0 and 5")
+	}
+	if query.Label != nil && *query.Label != "" && !slices.Contains(Labels, *query.Label) {
+		return echo.NewHTTPError(http.StatusBadRequest, "label must be one of "+strings.Join(Labels, ", "))
+	}
+
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var photos []*Photo
+	for _, id := range query.Photos {
+		photo, err := album.GetPhoto(id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		photos = append(photos, photo)
+	}
+
+	rated, err := collection.RatePhotos(album, query.Rating, query.Label, photos...)
+	if errors.Is(err, ErrReadOnly) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil && len(rated) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusMultiStatus, rated)
+	}
+	return c.JSON(http.StatusOK, rated)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetXMPProperty(t *testing.T) {
+	for _, test := range []struct{ xmp, expected string }{
+		{`<rdf:Description xmp:Rating="1" xmp:Label="Red"/>`, `<rdf:Description xmp:Rating="5" xmp:Label="Red"/>`},
+		{`<rdf:Description><xmp:Rating>1</xmp:Rating></rdf:Description>`, `<rdf:Description><xmp:Rating>5</xmp:Rating></rdf:Description>`},
+		{`<rdf:Description xmlns:xmp="x" rdf:about=""/>`, `<rdf:Description xmp:Rating="5" xmlns:xmp="x" rdf:about=""/>`},
+		{`<rdf:Description rdf:about=""/>`, `<rdf:Description xmp:Rating="5" xmlns:xmp="` + nsXMP + `" rdf:about=""/>`},
+	} {
+		xmp, err := setXMPProperty([]byte(test.xmp), "xmp:Rating", "5")
+		if err != nil || string(xmp) != test.expected {
+			t.Errorf("Got %s from %s, expected %s (%v)", xmp, test.xmp, test.expected, err)
+		}
+	}
+	if _, err := setXMPProperty([]byte(`<x:xmpmeta/>`), "xmp:Rating", "5"); err == nil {
+		t.Error("Expected error for XMP without description")
+	}
+}
+
+func TestRatePhotos(t *testing.T) {
+	collection := newTestCollection(t, "Album", "Other")
+	dir := filepath.Join(collection.PhotosPath, "Album")
+	os.WriteFile(filepath.Join(dir, "IMG_0001.jpg"), iptcJPEG(t), 0644)
+	os.WriteFile(filepath.Join(dir, "IMG_0002.jpg"), iptcJPEG(t), 0644)
+	// Existing sidecar keeps its other properties
+	os.WriteFile(filepath.Join(dir, "IMG_0002.jpg.xmp"), []byte(testXMP), 0644)
+
+	album, err := collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo1, _ := album.GetPhoto("img_0001")
+	photo2, _ := album.GetPhoto("img_0002")
+	rating, label := 3, "Green"
+	rated, err := collection.RatePhotos(album, &rating, &label, photo1, photo2)
+	if err != nil || len(rated) != 2 {
+		t.Fatal("Photos not rated", err)
+	}
+	for _, photo := range rated {
+		if photo.Metadata.Rating != 3 || photo.Metadata.Label != "Green" {
+			t.Error("Rating not set", photo.Id, photo.Metadata)
+		}
+	}
+	if photo2.Metadata.Title != "Sunset" || len(photo2.Metadata.Regions) != 2 {
+		t.Error("Other properties of the sidecar lost", photo2.Metadata)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "IMG_0001.xmp")); err != nil {
+		t.Error("Sidecar not created", err)
+	}
+
+	// Ratings are read from the sidecars when scanning
+	album, _ = collection.GetAlbumWithPhotos("Album", true, false)
+	photo1, _ = album.GetPhoto("img_0001")
+	if photo1.Metadata.Rating != 3 || photo1.Metadata.Label != "Green" || photo1.Metadata.Title != "Mountains" {
+		t.Error("Rating not read from sidecar", photo1.Metadata)
+	}
+
+	// Clearing the label is kept in the sidecar, only the rating is changed
+	rating, label = 0, ""
+	collection.RatePhotos(album, nil, &label, photo1)
+	if photo1.Metadata.Label != "" || photo1.Metadata.Rating != 3 {
+		t.Error("Label not cleared", photo1.Metadata)
+	}
+	collection.RatePhotos(album, &rating, nil, photo1)
+	if photo1.Metadata.Rating != 0 {
+		t.Error("Rating not cleared", photo1.Metadata)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "IMG_0001.xmp"))
+	if !strings.Contains(string(content), `xmp:Rating="0"`) || !strings.Contains(string(content), `xmp:Label=""`) {
+		t.Error("Unexpected sidecar", string(content))
+	}
+
+	// Sidecar follows the photo when moved
+	other, _ := collection.GetAlbumWithPhotos("Other", false, false)
+	moved, err := collection.MovePhotos(album, collection, other, "", photo2)
+	if err != nil || len(moved) != 1 {
+		t.Fatal("Photo not moved", err)
+	}
+	if moved[0].Sidecar == nil || moved[0].Metadata.Rating != 3 {
+		t.Error("Sidecar not moved with the photo", moved[0].Sidecar, moved[0].Metadata)
+	}
+
+	collection.ReadOnly = true
+	if _, err := collection.RatePhotos(album, &rating, nil, photo1); err != ErrReadOnly {
+		t.Error("Read-only collections must not be changed", err)
+	}
+}
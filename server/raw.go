@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/golang-utils/image2/jpeg"
+)
+
+// RAW files are TIFF-based containers. Instead of developing the sensor data, the JPEG
+// preview embedded by the camera is used, the EXIF is read from the container itself.
+
+// MIME types of the supported RAW formats, by extension
+var RawFormats = map[string]string{
+	".dng": "image/x-adobe-dng", // Also Apple ProRAW
+	".cr2": "image/x-canon-cr2",
+	".nef": "image/x-nikon-nef",
+	".arw": "image/x-sony-arw",
+}
+
+// TIFF tags used to find the previews
+const (
+	tiffTagCompression     = 0x103
+	tiffTagStripOffsets    = 0x111
+	tiffTagStripByteCounts = 0x117
+	tiffTagSubIFDs         = 0x14a
+	tiffTagJPEGOffset      = 0x201
+	tiffTagJPEGLength      = 0x202
+	tiffMaxIFDs            = 32       // Limit for corrupted files
+	rawMaxPreviewSize      = 64 << 20 // Larger previews are ignored, not to exhaust memory with corrupted files
+)
+
+var ErrNoRawPreview = errors.New("no JPEG preview found in RAW file")
+
+// If the file is RAW, by its extension
+func IsRawFile(path string) bool {
+	_, ok := RawFormats[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// Location of a JPEG image inside the RAW file
+type rawPreview struct {
+	offset int64
+	length int64
+}
+
+// Extract the largest JPEG preview embedded in the RAW file opened
+func ExtractRawPreviewFile(fin *os.File) ([]byte, error) {
+	stat, err := fin.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ExtractRawPreview(fin, stat.Size())
+}
+
+// Extract the largest JPEG preview embedded in the RAW file with the size given
+func ExtractRawPreview(r io.ReaderAt, size int64) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF-based RAW file")
+	}
+
+	previews := tiffPreviews(r, order, int64(order.Uint32(header[4:])))
+	var best *rawPreview
+	bestArea := 0
+	for i, preview := range previews {
+		// Previews must be inside the file
+		if preview.offset <= 0 || preview.length <= 0 || preview.length > rawMaxPreviewSize || preview.offset+preview.length > size {
+			continue
+		}
+		// Only baseline JPEG can be decoded, lossless JPEG holds the sensor data
+		cfg, err := jpeg.DecodeConfig(io.NewSectionReader(r, preview.offset, preview.length))
+		if err == nil && cfg.Width*cfg.Height > bestArea {
+			best, bestArea = &previews[i], cfg.Width*cfg.Height
+		}
+	}
+	if best == nil {
+		return nil, ErrNoRawPreview
+	}
+	data := make([]byte, best.length)
+	_, err := r.ReadAt(data, best.offset)
+	return data, err
+}
+
+// Find the JPEG images referenced in the IFDs and sub-IFDs of the TIFF file
+func tiffPreviews(r io.ReaderAt, order binary.ByteOrder, first int64) (previews []rawPreview) {
+	pending := []int64{first}
+	visited := make(map[int64]bool)
+	for len(pending) > 0 && len(visited) < tiffMaxIFDs {
+		offset := pending[0]
+		pending = pending[1:]
+		if offset <= 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		count := make([]byte, 2)
+		if _, err := r.ReadAt(count, offset); err != nil {
+			continue
+		}
+		entries := make([]byte, 12*int(order.Uint16(count))+4)
+		if _, err := r.ReadAt(entries, offset+2); err != nil {
+			continue
+		}
+		tags := make(map[uint16][]uint32)
+		for i := 0; i+12 <= len(entries)-4; i += 12 {
+			tags[order.Uint16(entries[i:])] = tiffValues(r, order, entries[i:i+12])
+		}
+		// Next IFD in the chain
+		pending = append(pending, int64(order.Uint32(entries[len(entries)-4:])))
+		for _, sub := range tags[tiffTagSubIFDs] {
+			pending = append(pending, int64(sub))
+		}
+
+		if offsets, lengths := tags[tiffTagJPEGOffset], tags[tiffTagJPEGLength]; len(offsets) == 1 && len(lengths) == 1 {
+			previews = append(previews, rawPreview{int64(offsets[0]), int64(lengths[0])})
+		}
+		// Old-style and new-style JPEG compression, in a single strip
+		compression := tags[tiffTagCompression]
+		if len(compression) == 1 && (compression[0] == 6 || compression[0] == 7) {
+			if offsets, lengths := tags[tiffTagStripOffsets], tags[tiffTagStripByteCounts]; len(offsets) == 1 && len(lengths) == 1 {
+				previews = append(previews, rawPreview{int64(offsets[0]), int64(lengths[0])})
+			}
+		}
+	}
+	return previews
+}
+
+// Values of an IFD entry of type SHORT, LONG or IFD
+func tiffValues(r io.ReaderAt, order binary.ByteOrder, entry []byte) []uint32 {
+	size := 0
+	switch order.Uint16(entry[2:]) {
+	case 3: // SHORT
+		size = 2
+	case 4, 13: // LONG, IFD
+		size = 4
+	default:
+		return nil
+	}
+	count := int(order.Uint32(entry[4:]))
+	if count < 1 || count > 1024 {
+		return nil
+	}
+	data := entry[8:12]
+	if count*size > 4 {
+		// Values do not fit in the entry, it holds their location
+		data = make([]byte, count*size)
+		if _, err := r.ReadAt(data, int64(order.Uint32(entry[8:]))); err != nil {
+			return nil
+		}
+	}
+	values := make([]uint32, count)
+	for i := range values {
+		if size == 2 {
+			values[i] = uint32(order.Uint16(data[i*2:]))
+		} else {
+			values[i] = order.Uint32(data[i*4:])
+		}
+	}
+	return values
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/golang-utils/image2/jpeg"
+)
+
+func ifdEntry(tag uint16, typ uint16, count uint32, value uint32) []byte {
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry, tag)
+	binary.LittleEndian.PutUint16(entry[2:], typ)
+	binary.LittleEndian.PutUint32(entry[4:], count)
+	binary.LittleEndian.PutUint32(entry[8:], value)
+	return entry
+}
+
+func ifd(next uint32, entries ...[]byte) []byte {
+	data := make([]byte, 2, 2+12*len(entries)+4)
+	binary.LittleEndian.PutUint16(data, uint16(len(entries)))
+	for _, entry := range entries {
+		data = append(data, entry...)
+	}
+	return append(data, byte(next), byte(next>>8), byte(next>>16), byte(next>>24))
+}
+
+// RAW file with a small preview in IFD0 and a larger one in a sub-IFD
+func rawTestFile(t *testing.T) []byte {
+	var small, large bytes.Buffer
+	if err := jpeg.Encode(&small, gradient(160, 120, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&large, gradient(640, 480, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	ifd0Size, subSize := uint32(2+3*12+4), uint32(2+3*12+4)
+	sub := 8 + ifd0Size
+	smallOffset := sub + subSize
+	largeOffset := smallOffset + uint32(small.Len())
+
+	data := []byte("II*\x00\x08\x00\x00\x00")
+	data = append(data, ifd(0,
+		ifdEntry(tiffTagSubIFDs, 13, 1, sub),
+		ifdEntry(tiffTagJPEGOffset, 4, 1, smallOffset),
+		ifdEntry(tiffTagJPEGLength, 4, 1, uint32(small.Len())))...)
+	data = append(data, ifd(0,
+		ifdEntry(tiffTagCompression, 3, 1, 7),
+		ifdEntry(tiffTagStripOffsets, 4, 1, largeOffset),
+		ifdEntry(tiffTagStripByteCounts, 4, 1, uint32(large.Len())))...)
+	data = append(data, small.Bytes()...)
+	return append(data, large.Bytes()...)
+}
+
+func TestRawPreview(t *testing.T) {
+	data := rawTestFile(t)
+	preview, err := ExtractRawPreview(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+	if err != nil || cfg.Width != 640 || cfg.Height != 480 {
+		t.Error("Largest preview not selected", cfg, err)
+	}
+
+	// Previews beyond the end of truncated files are ignored
+	truncated := data[:len(data)-100]
+	preview, err = ExtractRawPreview(bytes.NewReader(truncated), int64(len(truncated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview)); err != nil || cfg.Width != 160 {
+		t.Error("Preview inside the file not selected", cfg, err)
+	}
+
+	if _, err := ExtractRawPreview(bytes.NewReader([]byte("II*\x00\x08\x00\x00\x00\x00\x00")), 10); err != ErrNoRawPreview {
+		t.Error("Expected error for RAW without preview", err)
+	}
+	if _, err := ExtractRawPreview(bytes.NewReader([]byte("not a raw file")), 14); err == nil {
+		t.Error("Expected error for files that are not TIFF")
+	}
+}
+
+func TestRawFile(t *testing.T) {
+	dir := t.TempDir()
+	raw := &File{Path: filepath.Join(dir, "DSC_0001.NEF"), Id: "DSC_0001.NEF"}
+	if err := os.WriteFile(raw.Path, rawTestFile(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.ExtractInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Type != "image" || raw.MIME != "image/x-nikon-nef" || raw.Width != 640 || raw.Height != 480 {
+		t.Error("Unexpected info of RAW file", raw.Type, raw.MIME, raw.Width, raw.Height)
+	}
+	if !raw.RequiresConvertion() {
+		t.Error("RAW files must be converted for the browser")
+	}
+	img, err := raw.DecodeImage()
+	if err != nil || img.Bounds().Dx() != 640 {
+		t.Error("Could not decode RAW file", err)
+	}
+
+	jpg := &File{Path: filepath.Join(dir, "DSC_0001.JPG"), Type: "image"}
+	photo := &Photo{Files: []*File{raw, jpg}}
+	if photo.MainFile() != jpg {
+		t.Error("JPEG of RAW+JPEG pair must be the main file")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"path/filepath"
@@ -8,14 +9,17 @@ import (
 	"github.com/timshannon/bolthold"
 )
 
-func (collection *Collection) Scan(fullScan bool) {
+// Scan the albums of the collection, stops when the context is cancelled
+func (collection *Collection) Scan(ctx context.Context, fullScan bool) error {
 	log.Printf("Scanning collection %s...\n", collection.Name)
+	progress := ProgressFromContext(ctx)
 
 	albums, err := collection.GetAlbums()
 	if err != nil {
 		log.Println(err)
-		return
+		return err
 	}
+	progress.AddTotalAlbums(len(albums))
 
 	defer collection.cache.FinishFlush()
 
@@ -23,10 +27,16 @@ func (collection *Collection) Scan(fullScan bool) {
 	if !fullScan {
 		for _, album := range albums {
 			if !collection.cache.IsAlbumFullyScanned(album) { // Skip album if it was already scanned
-				collection.GetAlbumWithPhotos(album.Name, true, true)
+				if _, err := collection.GetAlbumWithPhotosContext(ctx, album.Name, true, true); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					progress.AddErrors(1)
+				}
 			}
+			progress.AddAlbums(1)
 		}
-		return
+		return nil
 	}
 
 	// Full scan
@@ -36,13 +46,19 @@ func (collection *Collection) Scan(fullScan bool) {
 
 	for _, album := range albums {
 		// Load album
-		album, err = collection.GetAlbumWithPhotos(album.Name, true, true)
+		album, err = collection.GetAlbumWithPhotosContext(ctx, album.Name, true, true)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		progress.AddAlbums(1)
 		if err != nil {
 			log.Println(err)
+			progress.AddErrors(1)
+			continue
 		}
 
 		// Validate if photos have thumbnails
-		for _, photo := range album.photosMap {
+		for _, photo := range album.PhotoList() {
 			hasThumb, _ := photo.ThumbnailPresent(collection)
 
 			// Update flag if it is different than stored
@@ -54,14 +70,16 @@ func (collection *Collection) Scan(fullScan bool) {
 
 		// Validate if all entries in the cacheDB are still valid
 		var photos []*Photo
-		err = collection.cache.store.Find(&photos, bolthold.Where("Album").Eq(album.Name).Index("Album").And("Id").MatchFunc(
-			func(id string) (bool, error) {
-				p, e := album.GetPhoto(id)
-				if e == nil && p != nil {
-					return false, nil
-				}
-				return true, nil
-			}))
+		err = collection.cache.WithStore(func(store *bolthold.Store) error {
+			return store.Find(&photos, bolthold.Where("Album").Eq(album.Name).Index("Album").And("Id").MatchFunc(
+				func(id string) (bool, error) {
+					p, e := album.GetPhoto(id)
+					if e == nil && p != nil {
+						return false, nil
+					}
+					return true, nil
+				}))
+		})
 		if err == nil {
 			collection.cache.DeletePhotoInfo(photos...)
 		}
@@ -70,79 +88,122 @@ func (collection *Collection) Scan(fullScan bool) {
 	// Clean entries in the cacheDB of deleted albums
 	log.Printf("Cleaning entries of deleted albums in %s...\n", collection.Name)
 	var photos []*Photo
-	err = collection.cache.store.Find(&photos, bolthold.Where("Album").MatchFunc(
-		func(album string) (bool, error) {
-			return !collection.cache.IsAlbum(album), nil
-		}))
+	err = collection.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Find(&photos, bolthold.Where("Album").MatchFunc(
+			func(album string) (bool, error) {
+				return !collection.cache.IsAlbum(album), nil
+			}))
+	})
 	if err == nil {
 		collection.cache.DeletePhotoInfo(photos...)
 	} else {
 		log.Println(err)
 	}
+	return nil
 }
 
-func (collection *Collection) CreateThumbnails() {
+// Generate the thumbnails missing, stops when the context is cancelled
+func (collection *Collection) CreateThumbnails(ctx context.Context) error {
 	log.Printf("Creating thumbnails for %s...\n", collection.Name)
+	progress := ProgressFromContext(ctx)
 
 	// List albums with photos missing thumbnails
 	var albums []*AlbumThumbs
 	q := bolthold.Query{}
-	err := collection.cache.store.Find(&albums, q.SortBy("Name"))
+	err := collection.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Find(&albums, q.SortBy("Name"))
+	})
 	if err != nil {
 		log.Println(err)
-		return
+		return err
 	}
+	progress.AddTotalAlbums(len(albums))
+	defer collection.cache.FinishFlush()
 
 	// For each album
 	for _, albumThumb := range albums {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// Get album
 		album, err := collection.GetAlbum(albumThumb.Name)
 		if err != nil {
 			log.Println(err)
+			progress.AddErrors(1)
 			continue
 		}
 
 		// Get photos to be processed
 		var photos []*Photo
-		err = collection.cache.store.Find(&photos,
-			bolthold.Where("Album").Eq(album.Name).Index("Album").And("HasThumb").Eq(false).SortBy("Title"))
+		err = collection.cache.WithStore(func(store *bolthold.Store) error {
+			return store.Find(&photos,
+				bolthold.Where("Album").Eq(album.Name).Index("Album").And("HasThumb").Eq(false).SortBy("Title"))
+		})
 		if err != nil {
 			log.Println(err)
+			progress.AddErrors(1)
 			continue
 		}
 
-		// Add work to generate thumbnails in background
-		wg := AddThumbsBackground(collection, album, photos...)
+		// Add work to generate thumbnails in background and wait to complete
+		AddThumbsBackground(ctx, collection, album, photos...).Wait()
+		// Update flag to indicate that the thumbnail was generated
+		collection.cache.FlushInfo()
+		progress.AddAlbums(1)
+		if ctx.Err() != nil {
+			return ctx.Err() // Album not completed
+		}
+		// Thumbnails created, remove album from the queue
+		err = collection.cache.UnsetAlbumFullyScanned(albumThumb.Name)
+		if err != nil {
+			log.Println(err)
+		}
+	}
 
-		// Wait to complete creating thumbnails without blocking the process
-		go func(collection *Collection, albumThumb *AlbumThumbs) {
-			wg.Wait()
-			// Update flag to indicate that the thumbnail was generated
+	// Photos with thumbnail but without perceptual hash (e.g. generated by older versions),
+	// the hash is computed from the thumbnail
+	var unhashed []*Photo
+	err = collection.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Find(&unhashed, bolthold.Where("HasThumb").Eq(true).And("HasPHash").Eq(false).SortBy("Album"))
+	})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for len(unhashed) > 0 && ctx.Err() == nil {
+		n := 1
+		for n < len(unhashed) && unhashed[n].Album == unhashed[0].Album {
+			n++
+		}
+		if album, err := collection.GetAlbum(unhashed[0].Album); err != nil {
+			log.Println(err)
+			progress.AddErrors(n)
+		} else {
+			AddThumbsBackground(ctx, collection, album, unhashed[:n]...).Wait()
 			collection.cache.FlushInfo()
-			// Thumbnails created, remove album from the queue
-			err = collection.cache.UnsetAlbumFullyScanned(albumThumb.Name)
-			if err != nil {
-				log.Println(err)
-			}
-		}(collection, albumThumb)
+		}
+		unhashed = unhashed[n:]
 	}
+	return ctx.Err()
 }
 
-func (collection *Collection) CleanupThumbnails() {
+func (collection *Collection) CleanupThumbnails(ctx context.Context) error {
 	log.Printf("Cleaning up thumbnails for %s...\n", collection.Name)
 
 	// Step 1: Create a map of files to keep
 	keep := map[string]struct{}{}
 
 	// Get path for the thumbnail for each photo
-	err := collection.cache.store.ForEach(bolthold.Where("HasThumb").Eq(true), func(photo *Photo) error {
-		path := photo.ThumbnailPath(collection)
-		keep[path] = struct{}{}
-		return nil
+	err := collection.cache.WithStore(func(store *bolthold.Store) error {
+		return store.ForEach(bolthold.Where("HasThumb").Eq(true), func(photo *Photo) error {
+			path := photo.ThumbnailPath(collection)
+			keep[path] = struct{}{}
+			return nil
+		})
 	})
 	if err != nil {
 		log.Println(err)
-		return
+		return err
 	}
 
 	// Step 2: Traverse the thumbnails directory
@@ -153,11 +214,14 @@ func (collection *Collection) CleanupThumbnails() {
 	folder, err := filepath.Glob(path)
 	if err != nil {
 		log.Println(err)
-		return
+		return err
 	}
 
 	// Files in ThumbsPath that match the pattern
 	for _, file := range folder {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// but does not have the corresponding photo
 		if _, ok := keep[file]; !ok {
 			// Delete the file
@@ -165,7 +229,14 @@ func (collection *Collection) CleanupThumbnails() {
 			err := os.Remove(file)
 			if err != nil {
 				log.Println(err)
+				ProgressFromContext(ctx).AddErrors(1)
+			} else {
+				ProgressFromContext(ctx).AddFiles(1)
 			}
 		}
 	}
+
+	// Step 3: Previews of photos without thumbnail
+	collection.cleanupPreviews(keep)
+	return nil
 }
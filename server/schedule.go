@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/robfig/cron/v3"
+)
+
+// Maintenance jobs run periodically for each collection, as scheduled with cron expressions.
+// The last run of each job is kept in the cache DB, to be known after restarting too.
+
+type Schedule map[string]string // Cron expression for each job
+
+type JobStatus struct {
+	Job      string    `json:"job"`
+	Schedule string    `json:"schedule,omitempty"`
+	NextRun  time.Time `json:"nextrun"`
+	LastRun  *JobRun   `json:"lastrun"`
+	Active   *Job      `json:"active"` // Queued or running
+}
+
+// Parse schedule formatted as job:spec;job:spec (e.g. full-scan:0 3 * * 0;cleanup-thumbs:@weekly)
+func ParseSchedule(value string) (Schedule, error) {
+	schedule := make(Schedule)
+	for _, entry := range strings.Split(value, ";") {
+		if entry == "" {
+			continue
+		}
+		split := strings.SplitN(entry, ":", 2)
+		if len(split) != 2 {
+			return nil, errors.New(entry + " must be formatted as job:spec")
+		}
+		if _, ok := Jobs[split[0]]; !ok {
+			return nil, errors.New("invalid job: " + split[0])
+		}
+		if _, err := cron.ParseStandard(split[1]); err != nil {
+			return nil, fmt.Errorf("invalid schedule for %s: %v", split[0], err)
+		}
+		schedule[split[0]] = split[1]
+	}
+	return schedule, nil
+}
+
+// Starts the jobs as scheduled
+type Scheduler struct {
+	cron    *cron.Cron
+	mux     sync.Mutex
+	entries map[string]cron.EntryID // By collection:job
+}
+
+var scheduler Scheduler
+
+// Start running the jobs as scheduled for the collections
+func (s *Scheduler) Start(collections map[string]*Collection) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cron = cron.New()
+	s.entries = make(map[string]cron.EntryID)
+	for _, c := range collections {
+		for job, spec := range c.Schedule {
+			c, job := c, job
+			id, err := s.cron.AddFunc(spec, func() {
+				if _, err := jobManager.Start(c, job); err != nil {
+					log.Printf("Job %s for %s: %v", job, c.Name, err)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			s.entries[c.Name+":"+job] = id
+			log.Printf("Scheduled %s for %s: %s", job, c.Name, spec)
+		}
+	}
+	s.cron.Start()
+	return nil
+}
+
+// Stop scheduling jobs, those running are not interrupted
+func (s *Scheduler) Stop() {
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+}
+
+// Status of all jobs of the collection, scheduled or not
+func (s *Scheduler) Status(c *Collection) []JobStatus {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	list := make([]JobStatus, 0, len(Jobs))
+	for job := range Jobs {
+		status := JobStatus{Job: job, Schedule: c.Schedule[job]}
+		if id, ok := s.entries[c.Name+":"+job]; ok {
+			status.NextRun = s.cron.Entry(id).Next
+		}
+		if run, err := c.cache.GetJobRun(job); err == nil {
+			status.LastRun = run
+		}
+		if active, ok := jobManager.Active(c, job); ok {
+			status.Active = &active
+		}
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Job < list[j].Job
+	})
+	return list
+}
+
+func collectionJobs(c echo.Context) error {
+	collection, err := CollectionToMaintain(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, scheduler.Status(collection))
+}
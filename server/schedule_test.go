@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("full-scan:0 3 * * 0;compact-db:@monthly;")
+	if err != nil || len(schedule) != 2 || schedule["full-scan"] != "0 3 * * 0" || schedule["compact-db"] != "@monthly" {
+		t.Error("Unexpected schedule", schedule, err)
+	}
+	for _, value := range []string{"full-scan", "unknown:@daily", "full-scan:0 3 * *", "full-scan:@sometimes"} {
+		if _, err := ParseSchedule(value); err == nil {
+			t.Error("Expected error for", value)
+		}
+	}
+
+	// Lists in cron expressions are quoted in the collection options
+	collection, err := parseCollectionOptions(`name=Photos,path=/photos,"schedule=full-scan:0 3 * * 1,3"`, 0, "/tmp")
+	if err != nil || collection.Schedule["full-scan"] != "0 3 * * 1,3" {
+		t.Error("Unexpected schedule in collection options", err)
+	}
+	if _, err := parseCollectionOptions("name=Photos,path=/photos,schedule=full-scan:0 3 * * 1,3", 0, "/tmp"); err == nil {
+		t.Error("Expected error for unquoted list")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	collection.Schedule = Schedule{"quick-scan": "@hourly"}
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg"), iptcJPEG(t), 0644)
+	if _, err := collection.GetAlbumWithPhotos("Album", false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Scheduler
+	if err := s.Start(map[string]*Collection{collection.Name: collection}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	run, err := jobManager.Run(collection, "compact-db")
+	if err != nil || run.State != JobCompleted || run.Result == "" || run.Finished.Before(run.Started) {
+		t.Fatal("Compaction failed", run, err)
+	}
+	// Cache is still usable after being replaced
+	if photo, err := collection.cache.GetPhotoInfo("Album", "img_0001"); err != nil || photo.Metadata.Title != "Mountains" {
+		t.Error("Photo info lost after compaction", err)
+	}
+	if _, err := jobManager.Run(collection, "unknown"); err == nil {
+		t.Error("Expected error for unknown job")
+	}
+
+	status := s.Status(collection)
+	if len(status) != len(Jobs) {
+		t.Fatal("Unexpected number of jobs", len(status))
+	}
+	for _, job := range status {
+		switch job.Job {
+		case "compact-db":
+			if job.LastRun == nil || job.LastRun.Result != run.Result || !job.NextRun.IsZero() {
+				t.Error("Unexpected status of compaction", job, job.LastRun)
+			}
+		case "quick-scan":
+			if job.Schedule != "@hourly" || job.NextRun.Before(time.Now()) || job.LastRun != nil || job.Active != nil {
+				t.Error("Unexpected status of scheduled job", job)
+			}
+		}
+	}
+}
+
+func TestCompactWhileWriting(t *testing.T) {
+	collection := newTestCollection(t)
+
+	// Writes running during the compaction are kept
+	var wg, started sync.WaitGroup
+	done := make(chan struct{})
+	written := make([]int, 4)
+	for i := range written {
+		wg.Add(1)
+		started.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := collection.cache.SaveJobRun(&JobRun{Job: fmt.Sprintf("job-%d-%d", i, written[i])}); err != nil {
+					t.Error("Write failed during compaction:", err)
+					if written[i] == 0 {
+						started.Done()
+					}
+					return
+				}
+				if written[i]++; written[i] == 1 {
+					started.Done()
+				}
+			}
+		}(i)
+	}
+	started.Wait()
+	for i := 0; i < 3; i++ {
+		if _, _, err := collection.cache.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	for i, n := range written {
+		for j := 0; j < n; j++ {
+			if _, err := collection.cache.GetJobRun(fmt.Sprintf("job-%d-%d", i, j)); err != nil {
+				t.Fatalf("Write job-%d-%d lost: %v", i, j, err)
+			}
+		}
+	}
+}
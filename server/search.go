@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	SearchDefaultLimit = 100
+	SearchMaxLimit     = 1000
+)
+
+// Filters for searching photos, empty fields are not used
+type SearchQuery struct {
+	Collections []string  // Search only in these collections
+	From        time.Time // Taken after
+	To          time.Time // Taken before
+	Type        string    // image, video or live
+	Text        string    // Part of the title, of a filename or of the metadata
+	Keyword     string    // One of the keywords, ignoring case
+	Rating      int       // Minimum rating
+	Album       string
+	SubAlbum    string
+	HasLocation *bool
+	BBox        []float64 // Bounding box: west,south,east,north
+	Offset      int
+	Limit       int
+}
+
+type SearchResult struct {
+	Total  int      `json:"total"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+	Photos []*Photo `json:"photos"`
+}
+
+// Check if the photo satisfies all filters of the query
+func (q *SearchQuery) Match(photo *Photo) bool {
+	// Photos not processed yet
+	if photo.Type == "" {
+		return false
+	}
+	if !q.From.IsZero() && photo.Date.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && photo.Date.After(q.To) {
+		return false
+	}
+	if q.Type != "" && photo.Type != q.Type {
+		return false
+	}
+	if q.Album != "" && photo.Album != q.Album {
+		return false
+	}
+	if q.SubAlbum != "" && photo.SubAlbum != q.SubAlbum {
+		return false
+	}
+	if q.HasLocation != nil && photo.Location.Present != *q.HasLocation {
+		return false
+	}
+	if len(q.BBox) == 4 {
+		west, south, east, north := q.BBox[0], q.BBox[1], q.BBox[2], q.BBox[3]
+		lat, lng := photo.Location.Lat, photo.Location.Long
+		if !photo.Location.Present || lat < south || lat > north {
+			return false
+		}
+		// Boxes crossing the antimeridian have west greater than east
+		if west <= east && (lng < west || lng > east) || west > east && lng < west && lng > east {
+			return false
+		}
+	}
+	if q.Keyword != "" && !slices.ContainsFunc(photo.Metadata.Keywords, func(keyword string) bool {
+		return strings.EqualFold(keyword, q.Keyword)
+	}) {
+		return false
+	}
+	if q.Rating > 0 && photo.Metadata.Rating < q.Rating {
+		return false
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		found := strings.Contains(strings.ToLower(photo.Title), text)
+		for _, file := range photo.Files {
+			found = found || strings.Contains(strings.ToLower(file.Name()), text)
+		}
+		metadata := photo.Metadata
+		values := append([]string{metadata.Title, metadata.Description}, metadata.Keywords...)
+		for _, value := range append(values, metadata.Regions...) {
+			found = found || strings.Contains(strings.ToLower(value), text)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Find photos in the cache of the collection, using the indexes when possible
+func (c *Collection) Search(query *SearchQuery) ([]*Photo, error) {
+	q := &bolthold.Query{}
+	if query.Album != "" {
+		q = bolthold.Where("Album").Eq(query.Album).Index("Album")
+	} else if !query.From.IsZero() {
+		q = bolthold.Where("Date").Ge(query.From).Index("Date")
+	} else if !query.To.IsZero() {
+		q = bolthold.Where("Date").Le(query.To).Index("Date")
+	}
+
+	var photos []*Photo
+	err := c.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Find(&photos, q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Photo, 0)
+	for _, photo := range photos {
+		if query.Match(photo) {
+			result = append(result, photo)
+		}
+	}
+	return result, nil
+}
+
+// Search photos in several collections, results are merged with the most recent first
+func Search(collections []*Collection, query *SearchQuery) (*SearchResult, error) {
+	photos := make([]*Photo, 0)
+	for _, collection := range collections {
+		found, err := collection.Search(query)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, found...)
+	}
+	sort.Slice(photos, func(i, j int) bool {
+		a, b := photos[i], photos[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+		return a.Collection+":"+a.Key() < b.Collection+":"+b.Key()
+	})
+
+	result := &SearchResult{Total: len(photos), Offset: query.Offset, Limit: query.Limit}
+	start, end := query.Offset, query.Offset+query.Limit
+	if start > len(photos) {
+		start = len(photos)
+	}
+	if end > len(photos) {
+		end = len(photos)
+	}
+	result.Photos = photos[start:end]
+	return result, nil
+}
+
+// Parse a date and time (RFC 3339) or just a date, in which case the end of the day can be used
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, errors.New("invalid date, use YYYY-MM-DD or RFC 3339: " + value)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+func search(c echo.Context) error {
+	var from, to, location string
+	query := SearchQuery{Limit: SearchDefaultLimit}
+
+	// Decode query parameters
+	err := echo.QueryParamsBinder(c).
+		Strings("collection", &query.Collections).
+		String("from", &from).
+		String("to", &to).
+		String("type", &query.Type).
+		String("q", &query.Text).
+		String("album", &query.Album).
+		String("subalbum", &query.SubAlbum).
+		String("keyword", &query.Keyword).
+		Int("rating", &query.Rating).
+		String("location", &location).
+		BindWithDelimiter("bbox", &query.BBox, ",").
+		Int("offset", &query.Offset).
+		Int("limit", &query.Limit).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if from != "" {
+		if query.From, err = parseSearchTime(from, false); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if to != "" {
+		if query.To, err = parseSearchTime(to, true); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if location != "" {
+		hasLocation, err := strconv.ParseBool(location)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid value for location: "+location)
+		}
+		query.HasLocation = &hasLocation
+	}
+	switch query.Type {
+	case "", "image", "video", "live":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be image, video or live")
+	}
+	if query.BBox != nil && len(query.BBox) != 4 {
+		return echo.NewHTTPError(http.StatusBadRequest, "bbox must be formatted as west,south,east,north")
+	}
+	if query.Rating < 0 || query.Rating > 5 {
+		return echo.NewHTTPError(http.StatusBadRequest, "rating must be between 0 and 5")
+	}
+	if query.Offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "offset must not be negative")
+	}
+	if query.Limit < 1 || query.Limit > SearchMaxLimit {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(SearchMaxLimit))
+	}
+
+	// Collections to search, by default all the user can see
+	var collections []*Collection
+	user := CurrentUser(c)
+	if len(query.Collections) > 0 {
+		for _, name := range query.Collections {
+			collection, err := collectionWithAccess(user, name, AccessRead)
+			if err != nil {
+				return err
+			}
+			collections = append(collections, collection)
+		}
+	} else {
+		collections = visibleCollections(user)
+	}
+
+	result, err := Search(collections, &query)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSearch(t *testing.T) {
+	collection := newTestCollection(t)
+	date := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	collection.cache.AddPhotoInfo(
+		&Photo{Id: "img_0001", Title: "IMG_0001", Type: "image", Album: "Trip", Date: date,
+			Location: GPSLocation{Present: true, Lat: 38.7, Long: -9.1}},
+		&Photo{Id: "img_0002", Title: "IMG_0002", Type: "live", Album: "Trip", Date: date.Add(time.Hour)},
+		&Photo{Id: "beach", Title: "Beach", Type: "video", Album: "Summer", SubAlbum: "July", Date: date.AddDate(0, 1, 0)},
+		&Photo{Id: "pending", Title: "Pending", Album: "Summer"},
+	)
+	collection.cache.FinishFlush()
+
+	tests := []struct {
+		url      string
+		expected []string
+	}{
+		{"/api/search", []string{"beach", "img_0002", "img_0001"}},
+		{"/api/search?from=2023-06-01&to=2023-06-01", []string{"img_0002", "img_0001"}},
+		{"/api/search?type=live", []string{"img_0002"}},
+		{"/api/search?q=img_", []string{"img_0002", "img_0001"}},
+		{"/api/search?album=Summer&subalbum=July", []string{"beach"}},
+		{"/api/search?location=false", []string{"beach", "img_0002"}},
+		{"/api/search?bbox=-10,38,-9,39", []string{"img_0001"}},
+		{"/api/search?bbox=170,38,-170,39", []string{}},
+		{"/api/search?collection=Photos&offset=1&limit=1", []string{"img_0002"}},
+	}
+	e := echo.New()
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		if err := search(e.NewContext(httptest.NewRequest(http.MethodGet, test.url, nil), rec)); err != nil {
+			t.Errorf("%s: %v", test.url, err)
+			continue
+		}
+		var result SearchResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		ids := []string{}
+		for _, photo := range result.Photos {
+			ids = append(ids, photo.Id)
+		}
+		if len(ids) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.url, test.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.url, test.expected, ids)
+				break
+			}
+		}
+	}
+
+	for _, url := range []string{"/api/search?type=raw", "/api/search?bbox=1,2", "/api/search?from=yesterday", "/api/search?collection=None"} {
+		if err := search(e.NewContext(httptest.NewRequest(http.MethodGet, url, nil), httptest.NewRecorder())); err == nil {
+			t.Errorf("%s: expected error", url)
+		}
+	}
+}
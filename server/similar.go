@@ -0,0 +1,231 @@
+package main
+
+import (
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
+)
+
+const (
+	SimilarDefaultDistance = 10 // Photos that look alike
+	ClusterDefaultDistance = 6  // Photos that are almost the same (e.g. resized or re-encoded)
+	MaxHashDistance        = 32
+)
+
+type SimilarPhoto struct {
+	Photo    *Photo `json:"photo"`
+	Distance int    `json:"distance"` // Number of different bits between hashes, 0 looks the same
+}
+
+// Node of a BK-tree, photos are indexed by their perceptual hash
+// so the ones within a Hamming distance can be found quickly
+type bkNode struct {
+	hash     uint64
+	photos   []*Photo
+	children map[int]*bkNode
+}
+
+func hashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func (node *bkNode) add(photo *Photo) {
+	for {
+		distance := hashDistance(node.hash, photo.PHash)
+		if distance == 0 {
+			node.photos = append(node.photos, photo)
+			return
+		}
+		child, ok := node.children[distance]
+		if !ok {
+			node.children[distance] = &bkNode{hash: photo.PHash, photos: []*Photo{photo}, children: make(map[int]*bkNode)}
+			return
+		}
+		node = child
+	}
+}
+
+func (node *bkNode) find(hash uint64, maxDistance int, found func(photo *Photo, distance int)) {
+	distance := hashDistance(node.hash, hash)
+	if distance <= maxDistance {
+		for _, photo := range node.photos {
+			found(photo, distance)
+		}
+	}
+	// Only children within the range can have matches (triangle inequality)
+	for d, child := range node.children {
+		if d >= distance-maxDistance && d <= distance+maxDistance {
+			child.find(hash, maxDistance, found)
+		}
+	}
+}
+
+// Photos of the collection with a perceptual hash, computed when their thumbnails are generated
+func (c *Collection) hashedPhotos() ([]*Photo, error) {
+	var photos []*Photo
+	err := c.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Find(&photos, bolthold.Where("HasPHash").Eq(true))
+	})
+	if err != nil {
+		return nil, err
+	}
+	hashed := make([]*Photo, 0, len(photos))
+	for _, photo := range photos {
+		if photo.Type != "" {
+			photo.Collection = c.Name
+			hashed = append(hashed, photo)
+		}
+	}
+	return hashed, nil
+}
+
+// Find photos that look like the photo in the collections, the most similar first
+func FindSimilar(collections []*Collection, photo *Photo, maxDistance int) ([]SimilarPhoto, error) {
+	similar := make([]SimilarPhoto, 0)
+	for _, collection := range collections {
+		photos, err := collection.hashedPhotos()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range photos {
+			if p.Collection == photo.Collection && p.Key() == photo.Key() {
+				continue // Same photo
+			}
+			if distance := hashDistance(photo.PHash, p.PHash); distance <= maxDistance {
+				similar = append(similar, SimilarPhoto{p, distance})
+			}
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].Photo.Collection+":"+similar[i].Photo.Key() < similar[j].Photo.Collection+":"+similar[j].Photo.Key()
+	})
+	return similar, nil
+}
+
+// Group photos that are near-duplicates, a photo joins a cluster if it is within
+// the distance of any photo already there. The largest clusters come first.
+func ClusterSimilar(photos []*Photo, maxDistance int) [][]*Photo {
+	if len(photos) == 0 {
+		return [][]*Photo{}
+	}
+	root := &bkNode{hash: photos[0].PHash, children: make(map[int]*bkNode)}
+	index := make(map[*Photo]int, len(photos))
+	for i, photo := range photos {
+		root.add(photo)
+		index[photo] = i
+	}
+
+	// Union-find of photos within the distance
+	parent := make([]int, len(photos))
+	for i := range parent {
+		parent[i] = i
+	}
+	var findRoot func(i int) int
+	findRoot = func(i int) int {
+		if parent[i] != i {
+			parent[i] = findRoot(parent[i])
+		}
+		return parent[i]
+	}
+	for i, photo := range photos {
+		root.find(photo.PHash, maxDistance, func(other *Photo, _ int) {
+			a, b := findRoot(i), findRoot(index[other])
+			if a != b {
+				parent[a] = b
+			}
+		})
+	}
+
+	grouped := make(map[int][]*Photo)
+	for i, photo := range photos {
+		r := findRoot(i)
+		grouped[r] = append(grouped[r], photo)
+	}
+	clusters := make([][]*Photo, 0)
+	for _, cluster := range grouped {
+		if len(cluster) > 1 {
+			sortDuplicates(cluster)
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i]) != len(clusters[j]) {
+			return len(clusters[i]) > len(clusters[j])
+		}
+		return clusters[i][0].Key() < clusters[j][0].Key()
+	})
+	return clusters
+}
+
+// Get the maximum distance from the query parameters
+func distanceParam(c echo.Context, defaultDistance int) (int, error) {
+	distance := defaultDistance
+	err := echo.QueryParamsBinder(c).Int("distance", &distance).BindError()
+	if err != nil || distance < 0 || distance > MaxHashDistance {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "distance must be between 0 and "+strconv.Itoa(MaxHashDistance))
+	}
+	return distance, nil
+}
+
+func similarPhotos(c echo.Context) error {
+	distance, err := distanceParam(c, SimilarDefaultDistance)
+	if err != nil {
+		return err
+	}
+	collection, err := CollectionWithAccess(c, AccessRead)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	photo, err := album.GetPhoto(c.Param("photo"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	// Photos in pseudo albums are copies, use the original
+	if album.IsPseudo {
+		if collection, err = photoCollection(CurrentUser(c), collection, album, photo); err != nil {
+			return err
+		}
+		if album, err = collection.GetAlbumWithPhotos(photo.Album, false, false); err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if photo, err = album.GetPhoto(photo.Id); err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+	}
+	if !photo.HasPHash {
+		return echo.NewHTTPError(http.StatusConflict, "thumbnail of the photo was not generated yet")
+	}
+
+	similar, err := FindSimilar(visibleCollections(CurrentUser(c)), photo, distance)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, similar)
+}
+
+func similarClusters(c echo.Context) error {
+	distance, err := distanceParam(c, ClusterDefaultDistance)
+	if err != nil {
+		return err
+	}
+	collection, err := CollectionWithAccess(c, AccessRead)
+	if err != nil {
+		return err
+	}
+	photos, err := collection.hashedPhotos()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, ClusterSimilar(photos, distance))
+}
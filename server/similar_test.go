@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func gradient(width int, height int, reverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			if reverse {
+				v = 255 - v
+			}
+			img.Set(x, y, color.Gray{v})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := PerceptualHash(gradient(400, 300, false))
+	resized := PerceptualHash(imaging.Resize(gradient(400, 300, false), 100, 75, imaging.Lanczos))
+	different := PerceptualHash(gradient(400, 300, true))
+
+	if d := hashDistance(original, resized); d > ClusterDefaultDistance {
+		t.Errorf("Resized image has distance %d", d)
+	}
+	if d := hashDistance(original, different); d <= SimilarDefaultDistance {
+		t.Errorf("Different image has distance %d", d)
+	}
+}
+
+func TestClusterSimilar(t *testing.T) {
+	photos := []*Photo{
+		{Id: "a", PHash: 0b1111_0000},
+		{Id: "b", PHash: 0b1111_0001}, // Close to a
+		{Id: "c", PHash: 0b1111_0011}, // Close to b
+		{Id: "d", PHash: 0xFFFF_0000_0000_0000},
+		{Id: "e", PHash: 0xFFFF_0000_0000_0000}, // Same as d
+		{Id: "f", PHash: 0x0000_FFFF_0000_0000},
+	}
+	clusters := ClusterSimilar(photos, 1)
+	if len(clusters) != 2 || len(clusters[0]) != 3 || len(clusters[1]) != 2 {
+		t.Fatalf("Unexpected clusters: %v", clusters)
+	}
+	if clusters[0][0].Id != "a" || clusters[1][0].Id != "d" {
+		t.Errorf("Unexpected clusters: %v", clusters)
+	}
+}
+
+func TestPerceptualHashOfThumbnails(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	fout, err := os.Create(filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	EncodeImage(fout, gradient(300, 200, false), nil)
+	fout.Close()
+	if _, err := collection.GetAlbumWithPhotos("Album", false, false); err != nil {
+		t.Fatal(err)
+	}
+	collection.cache.FinishFlush()
+	if photos, _ := collection.hashedPhotos(); len(photos) != 0 {
+		t.Error("Photo hashed before generating thumbnail")
+	}
+
+	if job, err := jobManager.Run(collection, "create-thumbs"); err != nil || job.State != JobCompleted {
+		t.Fatal("Creating thumbnails failed", job, err)
+	}
+	photos, _ := collection.hashedPhotos()
+	if len(photos) != 1 {
+		t.Fatal("Photo not hashed with thumbnail")
+	}
+	hash := photos[0].PHash
+
+	// Hash missing for thumbnails already generated
+	photos[0].PHash, photos[0].HasPHash = 0, false
+	collection.cache.AddPhotoInfo(photos[0])
+	collection.cache.FinishFlush()
+	if job, err := jobManager.Run(collection, "create-thumbs"); err != nil || job.Progress.Files != 1 {
+		t.Fatal("Hashing thumbnails failed", job, err)
+	}
+	if photos, _ := collection.hashedPhotos(); len(photos) != 1 || hashDistance(photos[0].PHash, hash) > ClusterDefaultDistance {
+		t.Error("Photo not hashed from its thumbnail", photos)
+	}
+}
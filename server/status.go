@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
 	"go.etcd.io/bbolt"
 )
 
@@ -24,6 +25,7 @@ func ViewStatusInit(status *echo.Group) {
 	status.GET("/run-full/", runActionFullScan)
 	status.GET("/run-clean-thumbs/", runActionCleanupThumbnails)
 	status.GET("/run-create-thumbs/", runActionCreateThumbnails)
+	status.GET("/run-duplicates/", runActionFindDuplicates)
 	// DB
 	status.GET("/db/:collection/", dbViewBuckets)
 	status.GET("/db/:collection/:bucket/", dbViewBucket)
@@ -40,7 +42,8 @@ func mainStatusView(c echo.Context) error {
 	html += "<ul><li><a href=\"run-quick/\">Quick Scan</a></li>"
 	html += "<li><a href=\"run-full/\">Full Scan</a></li>"
 	html += "<li><a href=\"run-clean-thumbs/\">Cleanup Thumbnails</a></li>"
-	html += "<li><a href=\"run-create-thumbs/\">Create Thumbnails</a></li></ul>"
+	html += "<li><a href=\"run-create-thumbs/\">Create Thumbnails</a></li>"
+	html += "<li><a href=\"run-duplicates/\">Find Duplicates</a></li></ul>"
 
 	// Workers
 	html += "<h2>Workers active</h2>"
@@ -61,29 +64,41 @@ func mainStatusView(c echo.Context) error {
 	return c.HTML(http.StatusOK, html)
 }
 
-func runActionQuickScan(c echo.Context) error {
+// Start the job for all collections, with links to follow them
+func runActionJob(c echo.Context, name string) error {
+	html := ""
 	for _, collection := range config.collections {
-		collection.Scan(false)
+		job, err := jobManager.Start(collection, name)
+		if err != nil {
+			html += collection.Name + ": " + err.Error() + "<br>"
+			continue
+		}
+		html += collection.Name + ": <a href=\"/api/jobs/" + job.Id + "\">" + job.Id + "</a><br>"
 	}
-	return c.HTML(http.StatusOK, "OK<br><a href=\"..\">&larr; Back</a>")
+	return c.HTML(http.StatusOK, html+"OK<br><a href=\"..\">&larr; Back</a>")
+}
+func runActionQuickScan(c echo.Context) error {
+	return runActionJob(c, "quick-scan")
 }
 func runActionFullScan(c echo.Context) error {
-	for _, collection := range config.collections {
-		collection.Scan(true)
-	}
-	return c.HTML(http.StatusOK, "OK<br><a href=\"..\">&larr; Back</a>")
+	return runActionJob(c, "full-scan")
 }
 func runActionCleanupThumbnails(c echo.Context) error {
-	for _, collection := range config.collections {
-		collection.CleanupThumbnails()
-	}
-	return c.HTML(http.StatusOK, "OK<br><a href=\"..\">&larr; Back</a>")
+	return runActionJob(c, "cleanup-thumbs")
 }
 func runActionCreateThumbnails(c echo.Context) error {
+	return runActionJob(c, "create-thumbs")
+}
+func runActionFindDuplicates(c echo.Context) error {
+	var collections []*Collection
 	for _, collection := range config.collections {
-		collection.CreateThumbnails()
+		collections = append(collections, collection)
+	}
+	job, err := duplicates.Start(collections)
+	if err != nil {
+		return c.HTML(http.StatusOK, err.Error()+"<br><a href=\"..\">&larr; Back</a>")
 	}
-	return c.HTML(http.StatusOK, "OK<br><a href=\"..\">&larr; Back</a>")
+	return c.HTML(http.StatusOK, "<a href=\"/api/jobs/"+job.Id+"\">"+job.Id+"</a><br>OK<br><a href=\"..\">&larr; Back</a>")
 }
 
 func dbViewBuckets(c echo.Context) error {
@@ -98,19 +113,21 @@ func dbViewBuckets(c echo.Context) error {
 	html += "Batcher update: " + strconv.Itoa(len(collection.cache.addInfoCh)) + "<br>"
 	html += "Batcher delete: " + strconv.Itoa(len(collection.cache.delInfoCh)) + "<br>"
 
-	db := collection.cache.store.Bolt()
-
+	var stats bbolt.Stats
 	html += "<h2>Buckets</h2>"
-	db.View(func(tx *bbolt.Tx) error {
-		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
-			sname := string(name)
-			html += "<a href=\"" + sname + "/\">" + sname + "</a><br>"
-			return nil
+	collection.cache.WithStore(func(store *bolthold.Store) error {
+		stats = store.Bolt().Stats()
+		return store.Bolt().View(func(tx *bbolt.Tx) error {
+			return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+				sname := string(name)
+				html += "<a href=\"" + sname + "/\">" + sname + "</a><br>"
+				return nil
+			})
 		})
 	})
 
 	html += "<h2>Stats</h2>"
-	json, _ := json.MarshalIndent(db.Stats(), "", "    ")
+	json, _ := json.MarshalIndent(stats, "", "    ")
 	html += "<pre>" + string(json) + "</pre>"
 
 	return c.HTML(http.StatusOK, html)
@@ -123,8 +140,6 @@ func dbViewBucket(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 
-	db := collection.cache.store.Bolt()
-
 	var html string = "<h1>Viewing " + collection.Name + ", bucket: " + bucket + "</h1>"
 	html += "<a href=\"..\">&larr; Back</a><br><br>"
 	html += `<style>
@@ -140,32 +155,34 @@ func dbViewBucket(c echo.Context) error {
 	}
 	</style>`
 
-	db.View(func(tx *bbolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte(bucket))
-
-		if b != nil {
-			html += "<table><tr><th>#</th><th>Key</th><th>Value</th></tr>"
-			c := b.Cursor()
+	collection.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Bolt().View(func(tx *bbolt.Tx) error {
+			// Assume bucket exists and has keys
+			b := tx.Bucket([]byte(bucket))
+
+			if b != nil {
+				html += "<table><tr><th>#</th><th>Key</th><th>Value</th></tr>"
+				c := b.Cursor()
+
+				count := 0
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					if count > 2000 {
+						break
+					}
+					html += fmt.Sprintf("<tr><td>%d</td><td>%s</td><td>%s</td></tr>", count, string(k), string(v))
+					count++
+				}
+				html += "</table>"
 
-			count := 0
-			for k, v := c.First(); k != nil; k, v = c.Next() {
 				if count > 2000 {
-					break
+					html += "<b>More than 2000 records found, stopping..."
 				}
-				html += fmt.Sprintf("<tr><td>%d</td><td>%s</td><td>%s</td></tr>", count, string(k), string(v))
-				count++
+			} else {
+				html += "no such bucket available"
 			}
-			html += "</table>"
 
-			if count > 2000 {
-				html += "<b>More than 2000 records found, stopping..."
-			}
-		} else {
-			html += "no such bucket available"
-		}
-
-		return nil
+			return nil
+		})
 	})
 
 	return c.HTML(http.StatusOK, html)
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/timshannon/bolthold"
+)
+
+const (
+	TimelineDefaultLimit = 100
+	TimelineMaxLimit     = 1000
+)
+
+// Position of a photo in the timeline. Photos are ordered by date, the most recent first,
+// and photos taken at the same time are ordered by collection and then by key.
+type TimelineEntry struct {
+	Date       time.Time `json:"d"`
+	Collection string    `json:"c"`
+	Key        string    `json:"k"`
+}
+
+type TimelinePage struct {
+	Photos []*Photo `json:"photos"`
+	Next   string   `json:"next,omitempty"` // Cursor for the next page, empty at the end
+}
+
+type TimelineBucket struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// Check if the entry comes before the other in the timeline
+func (e TimelineEntry) Before(other TimelineEntry) bool {
+	if !e.Date.Equal(other.Date) {
+		return e.Date.After(other.Date)
+	}
+	if e.Collection != other.Collection {
+		return e.Collection < other.Collection
+	}
+	return e.Key < other.Key
+}
+
+// Opaque representation of the entry to be used as cursor
+func (e TimelineEntry) Cursor() string {
+	content, _ := json.Marshal(e)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func ParseTimelineCursor(cursor string) (TimelineEntry, error) {
+	var entry TimelineEntry
+	content, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(content, &entry) != nil {
+		return entry, errors.New("invalid cursor")
+	}
+	return entry, nil
+}
+
+// Photos of the collection sorted for the timeline. The list is built from
+// the Date index and kept in memory, updated as the cached info changes.
+func (c *Cache) Timeline(collection string) ([]TimelineEntry, error) {
+	c.muxTimeline.Lock()
+	defer c.muxTimeline.Unlock()
+	if c.timeline != nil {
+		return c.timeline, nil
+	}
+
+	timeline := make([]TimelineEntry, 0)
+	err := c.WithStore(func(store *bolthold.Store) error {
+		return store.ForEach(bolthold.Where("Date").Ge(time.Time{}).Index("Date"), func(photo *Photo) error {
+			// Skip photos not processed yet
+			if photo.Type != "" {
+				timeline = append(timeline, TimelineEntry{photo.Date, collection, photo.Key()})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(timeline, func(i, j int) bool {
+		return timeline[i].Before(timeline[j])
+	})
+	c.timeline = timeline
+	c.timelineCollection = collection
+	return timeline, nil
+}
+
+// Update the timeline with the photos saved or deleted in a batch. A new list
+// is merged, since the current one may be in use by pages being served.
+func (c *Cache) updateTimeline(photos []*Photo, deleted bool) {
+	c.muxTimeline.Lock()
+	defer c.muxTimeline.Unlock()
+	if c.timeline == nil || len(photos) == 0 {
+		return // Built when requested
+	}
+
+	// Last info of each photo in the batch
+	changed := make(map[string]*Photo, len(photos))
+	for _, photo := range photos {
+		changed[photo.Key()] = photo
+	}
+	added := make([]TimelineEntry, 0, len(changed))
+	for key, photo := range changed {
+		if !deleted && photo.Type != "" {
+			added = append(added, TimelineEntry{photo.Date, c.timelineCollection, key})
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].Before(added[j])
+	})
+
+	timeline := make([]TimelineEntry, 0, len(c.timeline)+len(added))
+	for _, entry := range c.timeline {
+		if _, ok := changed[entry.Key]; ok {
+			continue // Replaced or deleted
+		}
+		for len(added) > 0 && added[0].Before(entry) {
+			timeline = append(timeline, added[0])
+			added = added[1:]
+		}
+		timeline = append(timeline, entry)
+	}
+	c.timeline = append(timeline, added...)
+}
+
+// Timeline must be built again, e.g. after changes to a whole album
+func (c *Cache) ResetTimeline() {
+	c.muxTimeline.Lock()
+	c.timeline = nil
+	c.muxTimeline.Unlock()
+}
+
+// Get the page of the timeline with the photos after the cursor, merged from all collections
+func Timeline(collections []*Collection, after *TimelineEntry, limit int) (*TimelinePage, error) {
+	// Position in the timeline of each collection
+	timelines := make([][]TimelineEntry, len(collections))
+	for i, collection := range collections {
+		timeline, err := collection.cache.Timeline(collection.Name)
+		if err != nil {
+			return nil, err
+		}
+		if after != nil {
+			timeline = timeline[sort.Search(len(timeline), func(j int) bool {
+				return after.Before(timeline[j])
+			}):]
+		}
+		timelines[i] = timeline
+	}
+
+	page := &TimelinePage{Photos: make([]*Photo, 0, limit)}
+	var last TimelineEntry
+	for len(page.Photos) < limit {
+		// Take the first entry from all collections
+		next := -1
+		for i, timeline := range timelines {
+			if len(timeline) > 0 && (next < 0 || timeline[0].Before(timelines[next][0])) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return page, nil // End of the timeline
+		}
+		last = timelines[next][0]
+		timelines[next] = timelines[next][1:]
+
+		var photo Photo
+		err := collections[next].cache.WithStore(func(store *bolthold.Store) error {
+			return store.Get(last.Key, &photo)
+		})
+		if err != nil {
+			continue // Deleted in the meanwhile
+		}
+		page.Photos = append(page.Photos, &photo)
+	}
+	page.Next = last.Cursor()
+	return page, nil
+}
+
+// Count photos in the timeline per month or per day, the most recent first
+func TimelineBuckets(collections []*Collection, byDay bool) ([]TimelineBucket, error) {
+	layout := "2006-01"
+	if byDay {
+		layout = "2006-01-02"
+	}
+	counts := make(map[string]int)
+	for _, collection := range collections {
+		timeline, err := collection.cache.Timeline(collection.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range timeline {
+			counts[entry.Date.Format(layout)]++
+		}
+	}
+
+	buckets := make([]TimelineBucket, 0, len(counts))
+	for date, count := range counts {
+		buckets = append(buckets, TimelineBucket{date, count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Date > buckets[j].Date
+	})
+	return buckets, nil
+}
+
+func timeline(c echo.Context) error {
+	var cursor, from string
+	limit := TimelineDefaultLimit
+
+	// Decode query parameters
+	err := echo.QueryParamsBinder(c).
+		String("cursor", &cursor).
+		String("from", &from).
+		Int("limit", &limit).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if limit < 1 || limit > TimelineMaxLimit {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(TimelineMaxLimit))
+	}
+
+	var after *TimelineEntry
+	if cursor != "" {
+		entry, err := ParseTimelineCursor(cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		after = &entry
+	} else if from != "" {
+		// Start with the photos taken until the date, e.g. when jumping to a bucket
+		date, err := parseSearchTime(from, true)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		after = &TimelineEntry{Date: date}
+	}
+
+	page, err := Timeline(visibleCollections(CurrentUser(c)), after, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func timelineBuckets(c echo.Context) error {
+	group := c.QueryParam("group")
+	if group != "" && group != "month" && group != "day" {
+		return echo.NewHTTPError(http.StatusBadRequest, "group must be month or day")
+	}
+	buckets, err := TimelineBuckets(visibleCollections(CurrentUser(c)), group == "day")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, buckets)
+}
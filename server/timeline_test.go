@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeline(t *testing.T) {
+	photos := newTestCollection(t)
+	others := newTestCollection(t)
+	others.Name = "Others"
+	date := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	photos.cache.AddPhotoInfo(
+		&Photo{Id: "a", Type: "image", Album: "Album", Date: date},
+		&Photo{Id: "b", Type: "image", Album: "Album", Date: date},
+		&Photo{Id: "c", Type: "image", Album: "Album", Date: date.AddDate(0, -1, 0)},
+		&Photo{Id: "pending", Album: "Album", Date: date},
+	)
+	others.cache.AddPhotoInfo(
+		&Photo{Id: "d", Type: "video", Album: "Album", Date: date},
+		&Photo{Id: "e", Type: "image", Album: "Album", Date: date.AddDate(0, 0, 1)},
+	)
+	photos.cache.FinishFlush()
+	others.cache.FinishFlush()
+	collections := []*Collection{others, photos}
+
+	// Go through all pages
+	var ids []string
+	var after *TimelineEntry
+	for {
+		page, err := Timeline(collections, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, photo := range page.Photos {
+			ids = append(ids, photo.Id)
+		}
+		if page.Next == "" {
+			break
+		}
+		entry, err := ParseTimelineCursor(page.Next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after = &entry
+	}
+	expected := []string{"e", "d", "a", "b", "c"}
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, ids)
+		}
+	}
+
+	// New photos are shown once the info is saved
+	photos.cache.AddPhotoInfo(&Photo{Id: "f", Type: "image", Album: "Album", Date: date.AddDate(1, 0, 0)})
+	photos.cache.FinishFlush()
+	page, _ := Timeline(collections, nil, 1)
+	if len(page.Photos) != 1 || page.Photos[0].Id != "f" {
+		t.Errorf("Timeline not updated: %v", page.Photos)
+	}
+
+	buckets, err := TimelineBuckets(collections, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 3 || buckets[1] != (TimelineBucket{"2023-06", 4}) {
+		t.Errorf("Unexpected buckets: %v", buckets)
+	}
+
+	// Changes are merged into the timeline without building it again
+	photos.cache.AddPhotoInfo(
+		&Photo{Id: "c", Type: "image", Album: "Album", Date: date.AddDate(2, 0, 0)},
+		&Photo{Id: "pending", Type: "image", Album: "Album", Date: date.AddDate(0, 0, 1)},
+	)
+	photos.cache.DeletePhotoInfo(&Photo{Id: "a", Album: "Album"})
+	photos.cache.FinishFlush()
+	updated := photos.cache.timeline
+	if updated == nil {
+		t.Fatal("Timeline built again")
+	}
+	photos.cache.ResetTimeline()
+	rebuilt, err := photos.cache.Timeline(photos.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, rebuilt) {
+		t.Errorf("Expected %v, got %v", rebuilt, updated)
+	}
+	if len(rebuilt) != 4 || rebuilt[0].Key != PhotoKey("Album", "c") {
+		t.Errorf("Unexpected timeline: %v", rebuilt)
+	}
+}
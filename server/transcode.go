@@ -0,0 +1,31 @@
+package main
+
+// Codecs supported by browsers in MP4 containers, named as the decoders of libav
+var (
+	browserVideoCodecs = map[string]bool{"h264": true, "vp9": true, "libvpx-vp9": true, "av1": true, "libdav1d": true}
+	browserAudioCodecs = map[string]bool{"": true, "aac": true, "mp3": true, "mp3float": true, "opus": true, "libopus": true}
+)
+
+// Progress of a video being transcoded
+type TranscodeStatus struct {
+	Ready    bool    `json:"ready"`    // File can be played
+	Progress float64 `json:"progress"` // From 0 to 1
+}
+
+// Check if browsers can play the video without transcoding
+func (file *File) BrowserPlayable() bool {
+	// Containers not supported, regardless of the codecs
+	switch file.Ext() {
+	case ".avi", ".mpeg":
+		return false
+	}
+	// Codecs are not known for info extracted by older versions
+	if file.VideoCodec == "" {
+		return true
+	}
+	// HEVC is only supported by some browsers
+	if file.VideoCodec == "hevc" && !config.transcodeHEVC {
+		return browserAudioCodecs[file.AudioCodec]
+	}
+	return browserVideoCodecs[file.VideoCodec] && browserAudioCodecs[file.AudioCodec]
+}
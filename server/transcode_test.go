@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestBrowserPlayable(t *testing.T) {
+	config.transcodeHEVC = true
+	files := map[*File]bool{
+		{Type: "video", Path: "a.mov", VideoCodec: "h264", AudioCodec: "aac"}:        true,
+		{Type: "video", Path: "a.mp4", VideoCodec: "h264"}:                           true,
+		{Type: "video", Path: "a.mov"}:                                               true, // Codecs not known
+		{Type: "video", Path: "a.mov", VideoCodec: "hevc", AudioCodec: "aac"}:        false,
+		{Type: "video", Path: "a.mpeg", VideoCodec: "mpeg2video", AudioCodec: "mp2"}: false,
+		{Type: "video", Path: "a.avi", VideoCodec: "h264", AudioCodec: "mp3float"}:   false,
+		{Type: "video", Path: "a.mp4", VideoCodec: "h264", AudioCodec: "pcm_s16le"}:  false,
+	}
+	for file, playable := range files {
+		if file.BrowserPlayable() != playable {
+			t.Errorf("%s with %s/%s playable should be %v", file.Path, file.VideoCodec, file.AudioCodec, playable)
+		}
+		if file.RequiresConvertion() == playable {
+			t.Errorf("%s with %s/%s requires conversion should be %v", file.Path, file.VideoCodec, file.AudioCodec, !playable)
+		}
+	}
+
+	config.transcodeHEVC = false
+	if !(&File{Type: "video", Path: "a.mov", VideoCodec: "hevc", AudioCodec: "aac"}).BrowserPlayable() {
+		t.Error("HEVC must be played when not transcoded")
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Hidden folder in the collection where deleted photos are kept until they are purged
+const TRASH_FOLDER = ".trash"
+
+// Manifest of a photo in the trash, saved together with its files
+type TrashEntry struct {
+	Id       string        `json:"id"`
+	Album    string        `json:"album"`
+	Photo    string        `json:"photo"`
+	Title    string        `json:"title"`
+	Type     string        `json:"type"`
+	Files    []TrashFile   `json:"files"`
+	Pseudos  []PseudoAlbum `json:"pseudos"` // Pseudo albums where the photo was referenced
+	Favorite []PseudoAlbum `json:"favorite"`
+	Deleted  time.Time     `json:"deleted"`
+	User     string        `json:"user"`
+}
+
+type TrashFile struct {
+	Name string `json:"name"` // Name in the trash
+	Path string `json:"path"` // Original location relative to the collection
+}
+
+type DeletePhotosQuery struct {
+	Photos []string `json:"photos"`
+}
+
+func (c *Collection) TrashPath() string {
+	return filepath.Join(c.PhotosPath, TRASH_FOLDER)
+}
+
+func (c *Collection) trashEntryPath(id string) string {
+	// Clean the id to never get out of the trash
+	return filepath.Join(c.TrashPath(), filepath.Base(filepath.Clean("/"+id)))
+}
+
+func (c *Collection) GetTrashEntry(id string) (*TrashEntry, error) {
+	var entry TrashEntry
+	content, err := os.ReadFile(filepath.Join(c.trashEntryPath(id), "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &entry, json.Unmarshal(content, &entry)
+}
+
+// List photos in the trash, the most recently deleted first
+func (c *Collection) ListTrash() ([]*TrashEntry, error) {
+	entries := make([]*TrashEntry, 0)
+	dirs, err := os.ReadDir(c.TrashPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		entry, err := c.GetTrashEntry(dir.Name())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Deleted.After(entries[j].Deleted)
+	})
+	return entries, nil
+}
+
+// Move the files of the photo to the trash, returns the entry created
+func (c *Collection) trashPhoto(album *Album, photo *Photo, user *User) (*TrashEntry, error) {
+	id, err := randomId(8)
+	if err != nil {
+		return nil, err
+	}
+	entry := &TrashEntry{
+		Id:       id,
+		Album:    album.Name,
+		Photo:    photo.Id,
+		Title:    photo.Title,
+		Type:     photo.Type,
+		Pseudos:  []PseudoAlbum{},
+		Favorite: photo.Favorite,
+		Deleted:  time.Now(),
+	}
+	if user != nil {
+		entry.User = user.Name
+	}
+	dir := c.trashEntryPath(id)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// The sidecar is kept with the files, to be restored too
+	files := photo.Files
+	if photo.Sidecar != nil {
+		files = append(files[:len(files):len(files)], &File{Path: photo.Sidecar.Path})
+	}
+	for i, file := range files {
+		rel, err := filepath.Rel(c.PhotosPath, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		trashFile := TrashFile{Name: filepath.Base(file.Path), Path: rel}
+		if err := moveFile(file.Path, filepath.Join(dir, trashFile.Name)); err != nil {
+			// Put back files already moved
+			for j := 0; j < i; j++ {
+				if err := moveFile(filepath.Join(dir, entry.Files[j].Name), files[j].Path); err != nil {
+					log.Println(err)
+				}
+			}
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		entry.Files = append(entry.Files, trashFile)
+	}
+	if err := entry.save(c); err != nil {
+		log.Println(err)
+	}
+
+	// Drop thumbnail, previews and cached info
+	photo.RemoveThumbnails(c)
+	album.removePhoto(photo.Id)
+	c.cache.DeletePhotoInfo(photo)
+	return entry, nil
+}
+
+func (e *TrashEntry) save(c *Collection) error {
+	content, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.trashEntryPath(e.Id), "manifest.json"), content, 0644)
+}
+
+// Move photos to the trash of the collection. References in pseudo albums are removed
+// and saved in the trash, so they can be restored later.
+func (c *Collection) DeletePhotos(album *Album, user *User, photos ...*Photo) ([]*TrashEntry, error) {
+	if err := c.CheckWritable(); err != nil {
+		return nil, err
+	}
+	if album.IsPseudo {
+		return nil, errors.New("photos can only be deleted from regular albums")
+	}
+
+	c.LockAlbum(album.Name)
+	entries := make(map[PseudoAlbumEntry]*TrashEntry)
+	var deleted []*TrashEntry
+	for _, photo := range photos {
+		entry, err := c.trashPhoto(album, photo, user)
+		if err != nil {
+			log.Printf("Cannot delete %s[%s] %s: %v", c.Name, album.Name, photo.Title, err)
+			continue
+		}
+		entries[PseudoAlbumEntry{Collection: c.Name, Album: album.Name, Photo: photo.Id}] = entry
+		deleted = append(deleted, entry)
+	}
+	c.cache.FlushInfo()
+	c.UnlockAlbum(album.Name)
+
+	// Remove references in pseudo albums
+	RewritePseudoAlbums(config.collections, func(pseudo PseudoAlbum, entry PseudoAlbumEntry) (PseudoAlbumEntry, bool) {
+		if trashEntry, ok := entries[entry]; ok {
+			trashEntry.Pseudos = append(trashEntry.Pseudos, pseudo)
+			return entry, false
+		}
+		return entry, true
+	})
+	for _, entry := range deleted {
+		if err := entry.save(c); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if len(deleted) < len(photos) {
+		return deleted, errors.New("some photos could not be deleted")
+	}
+	return deleted, nil
+}
+
+// Move the files back to their original location, returns the photo restored
+func (c *Collection) RestoreTrash(id string) (*Photo, error) {
+	if err := c.CheckWritable(); err != nil {
+		return nil, err
+	}
+	entry, err := c.GetTrashEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(entry.Files) == 0 {
+		return nil, errors.New("no files to restore")
+	}
+	dir := c.trashEntryPath(entry.Id)
+
+	// Find free names in the original folder, the album may not exist anymore
+	photo := &Photo{Title: entry.Title}
+	for _, file := range entry.Files {
+		photo.Files = append(photo.Files, &File{Path: filepath.Join(dir, file.Name)})
+	}
+	originalDir := filepath.Dir(filepath.Join(c.PhotosPath, entry.Files[0].Path))
+	if err := os.MkdirAll(originalDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	paths, err := destinationNames(photo, originalDir, true)
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range photo.Files {
+		if err := moveFile(file.Path, paths[i]); err != nil {
+			return nil, err
+		}
+	}
+	os.RemoveAll(dir)
+
+	// Add back to the album
+	c.cache.AddToListAlbums(&Album{Name: entry.Album})
+	album, err := c.GetAlbumWithPhotos(entry.Album, false, false)
+	if err != nil {
+		return nil, err
+	}
+	c.LockAlbum(album.Name)
+	// Favorites are set before adding the files, the photo must not be changed after being queued to the cache
+	album.mux.Lock()
+	restored := album.photoForFile(c, filepath.Join(c.PhotosPath, album.Name), paths[0])
+	restored.Favorite = entry.Favorite
+	album.mux.Unlock()
+	album.AddFiles(c, paths...)
+	c.UnlockAlbum(album.Name)
+
+	// Add back references in pseudo albums
+	ref := PseudoAlbumEntry{Collection: c.Name, Album: album.Name, Photo: restored.Id}
+	for _, pseudo := range entry.Pseudos {
+		if err := addToPseudoAlbum(pseudo, ref); err != nil {
+			log.Println(err)
+		}
+	}
+	return restored, nil
+}
+
+// Delete permanently the photo in the trash
+func (c *Collection) PurgeTrash(id string) error {
+	if err := c.CheckWritable(); err != nil {
+		return err
+	}
+	if _, err := c.GetTrashEntry(id); err != nil {
+		return err
+	}
+	log.Printf("Purging %s from trash of %s", id, c.Name)
+	return os.RemoveAll(c.trashEntryPath(id))
+}
+
+// Delete permanently photos deleted before the retention period
+func (c *Collection) PurgeExpiredTrash(retention time.Duration) {
+	if c.ReadOnly {
+		return
+	}
+	entries, err := c.ListTrash()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, entry := range entries {
+		if time.Since(entry.Deleted) > retention {
+			if err := c.PurgeTrash(entry.Id); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Background job that purges the trash of all collections periodically
+func PurgeTrashPeriodically(collections map[string]*Collection, retention time.Duration) {
+	if retention <= 0 {
+		return // Keep forever
+	}
+	for {
+		for _, collection := range collections {
+			collection.PurgeExpiredTrash(retention)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func deletePhotos(c echo.Context) error {
+	var query DeletePhotosQuery
+
+	// Decode body
+	if err := c.Bind(&query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var photos []*Photo
+	for _, id := range query.Photos {
+		photo, err := album.GetPhoto(id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		photos = append(photos, photo)
+	}
+
+	deleted, err := collection.DeletePhotos(album, CurrentUser(c), photos...)
+	if err != nil && len(deleted) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusMultiStatus, deleted)
+	}
+	return c.JSON(http.StatusOK, deleted)
+}
+
+func trash(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	entries, err := collection.ListTrash()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+func restoreTrash(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	photo, err := collection.RestoreTrash(c.Param("entry"))
+	if os.IsNotExist(err) {
+		return echo.NewHTTPError(http.StatusNotFound, "entry not found in trash")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, photo)
+}
+
+func purgeTrash(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessAdmin)
+	if err != nil {
+		return err
+	}
+
+	// Empty the whole trash if no entry is specified
+	ids := []string{c.Param("entry")}
+	if ids[0] == "" {
+		entries, err := collection.ListTrash()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		ids = ids[:0]
+		for _, entry := range entries {
+			ids = append(ids, entry.Id)
+		}
+	}
+
+	for _, id := range ids {
+		err := collection.PurgeTrash(id)
+		if os.IsNotExist(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "entry not found in trash")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"ok": true})
+}
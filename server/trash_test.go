@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteAndRestorePhotos(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	for _, file := range []string{"Album/IMG_0001.HEIC", "Album/IMG_0001.MOV"} {
+		os.WriteFile(filepath.Join(collection.PhotosPath, file), []byte("data"), 0644)
+	}
+	os.WriteFile(filepath.Join(collection.PhotosPath, "Favorites"+PSEUDO_ALBUM_EXT), []byte("Photos:Album:img_0001\n"), 0644)
+	favorites := &Album{Name: "Favorites", IsPseudo: true}
+
+	album, err := collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := album.GetPhoto("img_0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete
+	deleted, err := collection.DeletePhotos(album, nil, photo)
+	if err != nil || len(deleted) != 1 {
+		t.Fatal("Photo not deleted", err)
+	}
+	if _, err := os.Stat(filepath.Join(collection.PhotosPath, "Album/IMG_0001.HEIC")); !os.IsNotExist(err) {
+		t.Error("File still in the album")
+	}
+	if entries, _ := readPseudoAlbum(collection, favorites); len(entries) != 0 {
+		t.Error("Reference not removed from pseudo album", entries)
+	}
+	trash, err := collection.ListTrash()
+	if err != nil || len(trash) != 1 || len(trash[0].Files) != 2 || len(trash[0].Pseudos) != 1 {
+		t.Fatal("Photo not found in trash", trash, err)
+	}
+
+	// Restore
+	restored, err := collection.RestoreTrash(trash[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Id != "img_0001" || len(restored.Files) != 2 {
+		t.Error("Photo not restored with all its files", restored)
+	}
+	if entries, _ := readPseudoAlbum(collection, favorites); len(entries) != 1 {
+		t.Error("Reference not restored in pseudo album", entries)
+	}
+	if trash, _ := collection.ListTrash(); len(trash) != 0 {
+		t.Error("Trash not empty after restore", trash)
+	}
+
+	// Purge after retention period
+	album, _ = collection.GetAlbumWithPhotos("Album", false, false)
+	photo, _ = album.GetPhoto("img_0001")
+	collection.DeletePhotos(album, nil, photo)
+	collection.PurgeExpiredTrash(time.Hour)
+	if trash, _ := collection.ListTrash(); len(trash) != 1 {
+		t.Error("Photo purged before retention period")
+	}
+	collection.PurgeExpiredTrash(0)
+	if trash, _ := collection.ListTrash(); len(trash) != 0 {
+		t.Error("Photo not purged after retention period")
+	}
+}
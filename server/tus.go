@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Resumable uploads implementing the tus protocol (https://tus.io/protocols/resumable-upload)
+// with the extensions creation and termination. Partial uploads are kept in a staging
+// folder next to the thumbnails, so they survive restarts of the server.
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination"
+	// Incomplete uploads are deleted after this time
+	TusExpiration = 7 * 24 * time.Hour
+)
+
+type TusUpload struct {
+	Id       string    `json:"id"`
+	Album    string    `json:"album"`
+	SubAlbum string    `json:"subalbum"`
+	Filename string    `json:"filename"`
+	Length   int64     `json:"length"`
+	User     string    `json:"user"`
+	Created  time.Time `json:"created"`
+}
+
+// Avoid concurrent writes to the same upload
+var tusLocks sync.Map
+
+func (c *Collection) UploadsPath() string {
+	return filepath.Join(c.ThumbsPath, c.Name+"-uploads")
+}
+
+// Location of the info and the data for the upload
+func (u *TusUpload) paths(c *Collection) (info string, data string) {
+	dir := c.UploadsPath()
+	return filepath.Join(dir, u.Id+".info"), filepath.Join(dir, u.Id+".bin")
+}
+
+// Number of bytes already received, which is the size of the data in the staging folder
+func (u *TusUpload) Offset(c *Collection) (int64, error) {
+	_, data := u.paths(c)
+	stat, err := os.Stat(data)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (c *Collection) NewUpload(upload *TusUpload) error {
+	id, err := randomId(16)
+	if err != nil {
+		return err
+	}
+	upload.Id = id
+	upload.Created = time.Now()
+
+	if err := os.MkdirAll(c.UploadsPath(), os.ModePerm); err != nil {
+		return err
+	}
+	info, data := upload.paths(c)
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(data, nil, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(info, content, 0600)
+}
+
+func (c *Collection) GetUpload(id string) (*TusUpload, error) {
+	// Ids are generated in hex, anything else is invalid
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, os.ErrNotExist
+	}
+	var upload TusUpload
+	info, _ := (&TusUpload{Id: id}).paths(c)
+	content, err := os.ReadFile(info)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, json.Unmarshal(content, &upload)
+}
+
+func (c *Collection) DeleteUpload(upload *TusUpload) error {
+	tusLocks.Delete(upload.Id)
+	info, data := upload.paths(c)
+	os.Remove(data)
+	return os.Remove(info)
+}
+
+// Move the completed upload into the album and add it to the cache
+func (c *Collection) FinishUpload(upload *TusUpload) ([]*Photo, error) {
+	album, err := c.GetAlbumWithPhotos(upload.Album, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var target string
+	if c.RenameOnReplace {
+		// Reserve the filename in the album, then replace it with the upload
+		file, err := c.CreateFile(album, upload.SubAlbum, upload.Filename)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		target = file.Name()
+	} else if target, err = c.albumFilePath(album, upload.SubAlbum, upload.Filename); err != nil {
+		return nil, err
+	}
+
+	// Moved next to the target first, an existing file is only replaced once the upload is in place
+	if err := moveUpload(c, upload, target); err != nil {
+		if c.RenameOnReplace {
+			os.Remove(target)
+		}
+		return nil, err
+	}
+	log.Printf("Uploaded %s[%s]: %s", c.Name, album.Name, target)
+
+	c.DeleteUpload(upload)
+
+	c.LockAlbum(album.Name)
+	defer c.UnlockAlbum(album.Name)
+	return album.AddFiles(c, target), nil
+}
+
+// Move the data of the upload to a temporary file in the folder of the target and rename it over the target
+func moveUpload(c *Collection, upload *TusUpload, target string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	_, data := upload.paths(c)
+	err = moveFile(data, tmp.Name())
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Delete incomplete uploads that were abandoned
+func (c *Collection) CleanupUploads() {
+	infos, err := filepath.Glob(filepath.Join(c.UploadsPath(), "*.info"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, info := range infos {
+		upload, err := c.GetUpload(strings.TrimSuffix(filepath.Base(info), ".info"))
+		if err != nil || time.Since(upload.Created) > TusExpiration {
+			log.Println("Deleting expired upload", info)
+			if upload == nil {
+				upload = &TusUpload{Id: strings.TrimSuffix(filepath.Base(info), ".info")}
+			}
+			// Wait for data still being appended
+			mux, _ := tusLocks.LoadOrStore(upload.Id, new(sync.Mutex))
+			mux.(*sync.Mutex).Lock()
+			c.DeleteUpload(upload)
+			mux.(*sync.Mutex).Unlock()
+		}
+	}
+}
+
+// Background job that deletes the abandoned uploads of all collections periodically
+func CleanupUploadsPeriodically(collections map[string]*Collection) {
+	for {
+		for _, collection := range collections {
+			collection.CleanupUploads()
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// Decode the header Upload-Metadata, formatted as: key base64value,key2 base64value2
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if kv[0] == "" {
+			continue
+		}
+		value := ""
+		if len(kv) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		metadata[kv[0]] = value
+	}
+	return metadata
+}
+
+// Middleware that adds the tus headers and validates the version requested
+func TusMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", TusVersion)
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		if c.Request().Method != http.MethodOptions && c.Request().Header.Get("Tus-Resumable") != TusVersion {
+			c.Response().Header().Set("Tus-Version", TusVersion)
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "unsupported tus version")
+		}
+		return next(c)
+	}
+}
+
+func tusOptions(c echo.Context) error {
+	c.Response().Header().Set("Tus-Version", TusVersion)
+	c.Response().Header().Set("Tus-Extension", TusExtensions)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Create a new upload, the file is sent afterwards with PATCH requests
+func tusCreate(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+	album, err := collection.GetAlbum(c.Param("album"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if album.IsPseudo {
+		return echo.NewHTTPError(http.StatusBadRequest, "album must be of type regular")
+	}
+
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid Upload-Length")
+	}
+	metadata := parseTusMetadata(c.Request().Header.Get("Upload-Metadata"))
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	if filename == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "filename is required in Upload-Metadata")
+	}
+
+	upload := &TusUpload{
+		Album:    album.Name,
+		SubAlbum: metadata["subalbum"],
+		Filename: filename,
+		Length:   length,
+	}
+	if user := CurrentUser(c); user != nil {
+		upload.User = user.Name
+	}
+	if err := collection.NewUpload(upload); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// Empty files are already completed
+	if length == 0 {
+		if _, err := collection.FinishUpload(upload); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, strings.TrimSuffix(c.Request().URL.Path, "/")+"/"+upload.Id)
+	return c.NoContent(http.StatusCreated)
+}
+
+// Find the upload for the request, it can only be used by the same user who created it
+func tusUpload(c echo.Context) (*Collection, *TusUpload, error) {
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	upload, err := collection.GetUpload(c.Param("upload"))
+	if err != nil || upload.Album != c.Param("album") {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "upload not found")
+	}
+	if user := CurrentUser(c); user != nil && user.Name != upload.User {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "upload not found")
+	}
+	return collection, upload, nil
+}
+
+func tusHead(c echo.Context) error {
+	collection, upload, err := tusUpload(c)
+	if err != nil {
+		return err
+	}
+	offset, err := upload.Offset(collection)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "upload not found")
+	}
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	return c.NoContent(http.StatusOK)
+}
+
+// Append data to the upload, once completed the file is moved into the album
+func tusPatch(c echo.Context) error {
+	if c.Request().Header.Get(echo.HeaderContentType) != "application/offset+octet-stream" {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+	}
+	collection, upload, err := tusUpload(c)
+	if err != nil {
+		return err
+	}
+
+	mux, _ := tusLocks.LoadOrStore(upload.Id, new(sync.Mutex))
+	mux.(*sync.Mutex).Lock()
+	defer mux.(*sync.Mutex).Unlock()
+
+	offset, err := upload.Offset(collection)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "upload not found")
+	}
+	requested, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requested != offset {
+		return echo.NewHTTPError(http.StatusConflict, "Upload-Offset does not match, current is "+strconv.FormatInt(offset, 10))
+	}
+
+	// Append data, everything received is kept even if the connection drops
+	_, data := upload.paths(collection)
+	file, err := os.OpenFile(data, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	n, copyErr := io.Copy(file, io.LimitReader(c.Request().Body, upload.Length-offset))
+	if err := file.Close(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	offset += n
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at %d/%d: %v", upload.Id, offset, upload.Length, copyErr)
+		return echo.NewHTTPError(http.StatusInternalServerError, copyErr.Error())
+	}
+
+	// Upload completed
+	if offset == upload.Length {
+		if _, err := collection.FinishUpload(upload); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func tusDelete(c echo.Context) error {
+	collection, upload, err := tusUpload(c)
+	if err != nil {
+		return err
+	}
+	if err := collection.DeleteUpload(upload); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
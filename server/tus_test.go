@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestTusUpload(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+
+	e := echo.New()
+	uploads := e.Group("/collections/:collection/albums/:album/uploads", TusMiddleware)
+	uploads.POST("", tusCreate)
+	uploads.HEAD("/:upload", tusHead)
+	uploads.PATCH("/:upload", tusPatch)
+	request := func(method string, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", TusVersion)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Create upload (filename is "notes.txt" in base64)
+	rec := request(http.MethodPost, "/collections/Photos/albums/Album/uploads", "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename bm90ZXMudHh0",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Create: expected %d, got %d %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+	location := rec.Header().Get(echo.HeaderLocation)
+
+	// Send first part
+	patch := map[string]string{echo.HeaderContentType: "application/offset+octet-stream", "Upload-Offset": "0"}
+	if rec = request(http.MethodPatch, location, "hello", patch); rec.Code != http.StatusNoContent {
+		t.Fatalf("Patch: expected %d, got %d %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+	// Wrong offset
+	if rec = request(http.MethodPatch, location, "world", patch); rec.Code != http.StatusConflict {
+		t.Errorf("Patch with wrong offset: expected %d, got %d", http.StatusConflict, rec.Code)
+	}
+	// Resume from the current offset
+	rec = request(http.MethodHead, location, "", nil)
+	if offset := rec.Header().Get("Upload-Offset"); offset != "5" {
+		t.Fatalf("Head: expected offset 5, got %s", offset)
+	}
+	patch["Upload-Offset"] = "5"
+	if rec = request(http.MethodPatch, location, " world", patch); rec.Code != http.StatusNoContent {
+		t.Fatalf("Patch: expected %d, got %d %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+
+	// Completed upload is moved into the album
+	data, err := os.ReadFile(filepath.Join(collection.PhotosPath, "Album", "notes.txt"))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("Uploaded file not found in album: %v %s", err, data)
+	}
+	if rec = request(http.MethodHead, location, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Completed upload still in staging: %d", rec.Code)
+	}
+}
+
+func TestTusReplace(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	collection.RenameOnReplace = false
+	album, err := collection.GetAlbum("Album")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(collection.PhotosPath, "Album", "notes.txt")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing file is kept when the upload cannot be moved
+	upload := &TusUpload{Album: album.Name, Filename: "notes.txt"}
+	if err := collection.NewUpload(upload); err != nil {
+		t.Fatal(err)
+	}
+	tusLocks.Store(upload.Id, new(sync.Mutex))
+	_, data := upload.paths(collection)
+	os.Remove(data)
+	os.Mkdir(data, os.ModePerm)
+	if _, err := collection.FinishUpload(upload); err == nil {
+		t.Error("Upload moved without data")
+	}
+	if content, err := os.ReadFile(existing); err != nil || string(content) != "old" {
+		t.Errorf("Existing file changed by failed upload: %v %s", err, content)
+	}
+	os.Remove(data)
+	os.WriteFile(data, []byte("new"), 0600)
+
+	// Replaced once completed, without temporary files left
+	if _, err := collection.FinishUpload(upload); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(existing); err != nil || string(content) != "new" {
+		t.Errorf("Existing file not replaced: %v %s", err, content)
+	}
+	if files, _ := os.ReadDir(filepath.Join(collection.PhotosPath, "Album")); len(files) != 1 {
+		t.Error("Unexpected files in album:", len(files))
+	}
+	if _, ok := tusLocks.Load(upload.Id); ok {
+		t.Error("Lock of finished upload not deleted")
+	}
+
+	// Expired uploads are deleted with their locks
+	expired := &TusUpload{Album: album.Name, Filename: "expired.txt"}
+	if err := collection.NewUpload(expired); err != nil {
+		t.Fatal(err)
+	}
+	tusLocks.Store(expired.Id, new(sync.Mutex))
+	expired.Created = time.Now().Add(-TusExpiration - time.Hour)
+	info, _ := expired.paths(collection)
+	content, _ := json.Marshal(expired)
+	os.WriteFile(info, content, 0600)
+	collection.CleanupUploads()
+	if _, err := collection.GetUpload(expired.Id); !os.IsNotExist(err) {
+		t.Error("Expired upload not deleted:", err)
+	}
+	if _, ok := tusLocks.Load(expired.Id); ok {
+		t.Error("Lock of expired upload not deleted")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Folder of the sub-album inside the album, it is created if does not exist
+func (c *Collection) subAlbumDir(album *Album, subAlbum string) (string, error) {
+	dir := filepath.Join(c.PhotosPath, album.Name)
+	// Sub-albums are cleaned as absolute paths, so they are always inside the album
+	if subAlbum = filepath.Clean("/" + subAlbum); subAlbum != "/" {
+		dir = filepath.Join(dir, subAlbum)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// Location for a file written to the album folder, which may already exist
+func (c *Collection) albumFilePath(album *Album, subAlbum string, filename string) (string, error) {
+	if err := c.CheckWritable(); err != nil {
+		return "", err
+	}
+	if album.IsPseudo {
+		return "", errors.New("cannot add files to pseudo albums")
+	}
+
+	// Only the name of the file is used, never allow to write outside the album
+	name := filepath.Base(filepath.Clean("/" + filename))
+	if name == "/" || name == "." || strings.HasPrefix(name, ".") {
+		return "", errors.New("invalid filename: " + filename)
+	}
+	dir, err := c.subAlbumDir(album, subAlbum)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Create a file in the album folder to write to. If the collection is set to rename on
+// replace, a new name is used when the file already exists, otherwise it is overwritten.
+func (c *Collection) CreateFile(album *Album, subAlbum string, filename string) (*os.File, error) {
+	path, err := c.albumFilePath(album, subAlbum, filename)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Split(path)
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if c.RenameOnReplace {
+		// If file exists, create a new file instead with an increment
+		name = uniqueFilename(name, func(f string) bool {
+			_, err := os.Stat(filepath.Join(dir, f))
+			return err == nil
+		})
+		flag |= os.O_EXCL
+	}
+	return os.OpenFile(filepath.Join(dir, name), flag, 0644)
+}
+
+// Write the contents of the reader to a new file in the album, returns its path
+func (c *Collection) WriteFile(album *Album, subAlbum string, filename string, r io.Reader) (string, error) {
+	file, err := c.CreateFile(album, subAlbum, filename)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Do not leave incomplete files behind
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func upload(c echo.Context) error {
+	collection, err := CollectionWithAccess(c, AccessWrite)
+	if err != nil {
+		return err
+	}
+
+	album, err := collection.GetAlbumWithPhotos(c.Param("album"), false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if album.IsPseudo {
+		return echo.NewHTTPError(http.StatusBadRequest, "album must be of type regular")
+	}
+
+	// Stream files in the multipart form directly to the disk
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	var paths []string
+	err = func() error {
+		var subAlbum string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+
+			// Sub-album where the next files are saved
+			if part.FormName() == "subalbum" {
+				value, err := io.ReadAll(io.LimitReader(part, 1024))
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+				}
+				subAlbum = string(value)
+				continue
+			}
+			if part.FileName() == "" {
+				continue // Not a file
+			}
+
+			path, err := collection.WriteFile(album, subAlbum, part.FileName(), part)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			log.Printf("Uploaded %s[%s]: %s", collection.Name, album.Name, path)
+			paths = append(paths, path)
+		}
+	}()
+	if len(paths) == 0 && err == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "no files uploaded")
+	}
+
+	// Extract info and update the album in cache, even for the files
+	// that were completely written before an error
+	collection.LockAlbum(album.Name)
+	photos := album.AddFiles(collection, paths...)
+	collection.UnlockAlbum(album.Name)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, photos)
+}
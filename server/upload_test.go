@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	collection := &Collection{
+		Name:            "Photos",
+		PhotosPath:      t.TempDir(),
+		RenameOnReplace: true,
+	}
+	album := &Album{Name: "Album 1"}
+	os.Mkdir(filepath.Join(collection.PhotosPath, album.Name), os.ModePerm)
+
+	// Files are renamed if they already exist
+	expected := []string{"IMG_0001.JPG", "IMG_0001_2.JPG", "IMG_0001_3.JPG"}
+	for _, name := range expected {
+		path, err := collection.WriteFile(album, "", "IMG_0001.JPG", strings.NewReader("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(path) != name {
+			t.Errorf("Expected %s, got %s", name, filepath.Base(path))
+		}
+	}
+
+	// Files are not written outside of the album
+	path, err := collection.WriteFile(album, "../..", "../../IMG_0002.JPG", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(collection.PhotosPath, album.Name, "IMG_0002.JPG") {
+		t.Errorf("File written outside of the album: %s", path)
+	}
+
+	// Read-only collection
+	collection.ReadOnly = true
+	if _, err := collection.WriteFile(album, "", "IMG_0003.JPG", strings.NewReader("data")); err != ErrReadOnly {
+		t.Errorf("Expected %v, got %v", ErrReadOnly, err)
+	}
+}
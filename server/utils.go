@@ -1,5 +1,89 @@
 package main
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Random identifier with n bytes encoded in hex
+func randomId(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Find a name that is not taken by adding an increment, e.g. name.jpg -> name_2.jpg
+func uniqueFilename(name string, exists func(string) bool) string {
+	for i := 1; ; i++ {
+		f := name
+		if i > 1 {
+			ext := path.Ext(name)
+			f = strings.TrimSuffix(name, ext) + "_" + strconv.Itoa(i) + ext
+		}
+
+		// Check if file doesnt exist
+		if !exists(f) {
+			return f
+		}
+	}
+}
+
+// Move a file, also across file systems. Destination is replaced atomically.
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	// Rename failed (e.g. different file systems), copy to a temporary file next to the destination
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Remove the folder if it contains nothing else than empty folders
+func removeEmptyDirs(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := removeEmptyDirs(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Remove(dir)
+}
+
 /**
  * Source: https://elliotchance.medium.com/batch-a-channel-by-size-or-time-in-go-92fa3098f65
  */
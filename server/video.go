@@ -1,9 +1,56 @@
 package main
 
+/*
+#cgo pkg-config: libavformat libavcodec libavutil
+#include <stdlib.h>
+#include <libavformat/avformat.h>
+#include <libavutil/display.h>
+
+// Rotation of the best video stream in degrees clockwise, from its display matrix or rotate tag
+static double video_rotation(const char *filename) {
+	AVFormatContext *ctx = NULL;
+	double rotation = 0;
+	if (avformat_open_input(&ctx, filename, NULL, NULL) < 0) {
+		return 0;
+	}
+	if (avformat_find_stream_info(ctx, NULL) >= 0) {
+		int index = av_find_best_stream(ctx, AVMEDIA_TYPE_VIDEO, -1, -1, NULL, 0);
+		if (index >= 0) {
+			AVStream *st = ctx->streams[index];
+			const uint8_t *matrix = NULL;
+#if LIBAVFORMAT_VERSION_MAJOR >= 61
+			const AVPacketSideData *sd = av_packet_side_data_get(st->codecpar->coded_side_data,
+				st->codecpar->nb_coded_side_data, AV_PKT_DATA_DISPLAYMATRIX);
+			if (sd) {
+				matrix = sd->data;
+			}
+#else
+			matrix = av_stream_get_side_data(st, AV_PKT_DATA_DISPLAYMATRIX, NULL);
+#endif
+			AVDictionaryEntry *tag = av_dict_get(st->metadata, "rotate", NULL, 0);
+			if (matrix) {
+				rotation = -av_display_rotation_get((const int32_t *)matrix); // Counterclockwise
+			} else if (tag) {
+				rotation = atof(tag->value);
+			}
+		}
+	}
+	avformat_close_input(&ctx);
+	return rotation;
+}
+*/
+import "C"
+
 import (
 	"errors"
+	"fmt"
 	"image"
+	"io"
 	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"unsafe"
 
 	"github.com/3d0c/gmf"
 )
@@ -154,3 +201,440 @@ func GetVideoFrame(srcFileName string) (image.Image, error) {
 
 	return img, nil
 }
+
+// Metadata of the video from its streams, for containers not parsed in Go
+func ProbeVideo(srcFileName string) (*VideoInfo, error) {
+	inputCtx, err := gmf.NewInputCtx(srcFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer inputCtx.Free()
+	defer freeStreams(inputCtx)
+
+	info := &VideoInfo{
+		Format:   strings.TrimPrefix(strings.ToLower(filepath.Ext(srcFileName)), "."),
+		Duration: inputCtx.Duration(),
+		Bitrate:  inputCtx.BitRate(),
+	}
+	if stream, err := inputCtx.GetBestStream(gmf.AVMEDIA_TYPE_VIDEO); err == nil {
+		info.VideoCodec = stream.CodecCtx().Codec().Name()
+		info.Rotation = videoRotation(srcFileName)
+		info.Width, info.Height = stream.CodecCtx().Width(), stream.CodecCtx().Height()
+		if info.Rotation == 90 || info.Rotation == 270 {
+			info.Width, info.Height = info.Height, info.Width
+		}
+		if frameRate := stream.GetAvgFrameRate().AVR(); frameRate.Den > 0 {
+			info.FrameRate = float64(frameRate.Num) / float64(frameRate.Den)
+		}
+	}
+	if stream, err := inputCtx.GetBestStream(gmf.AVMEDIA_TYPE_AUDIO); err == nil {
+		info.AudioCodec = stream.CodecCtx().Codec().Name()
+	}
+	return info, nil
+}
+
+// Rotation of the video in degrees clockwise (0, 90, 180 or 270)
+func videoRotation(srcFileName string) int {
+	filename := C.CString(srcFileName)
+	defer C.free(unsafe.Pointer(filename))
+	angle := float64(C.video_rotation(filename))
+	if math.IsNaN(angle) {
+		return 0
+	}
+	rotation := int(math.Round(angle/90)) * 90 % 360
+	if rotation < 0 {
+		rotation += 360
+	}
+	return rotation
+}
+
+// Format of the transcoded video
+type videoOutput struct {
+	format  string       // Name of the muxer
+	options []gmf.Option // Options of the muxer
+	height  int          // Scale down to the height, 0 keeps the size of the source
+	bitrate int          // Bitrate of the video, 0 uses a constant quality
+}
+
+// Width and height of the video stream as displayed, after rotation
+func GetVideoSize(srcFileName string) (width int, height int, err error) {
+	inputCtx, err := gmf.NewInputCtx(srcFileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer inputCtx.Free()
+	defer freeStreams(inputCtx)
+
+	stream, err := inputCtx.GetBestStream(gmf.AVMEDIA_TYPE_VIDEO)
+	if err != nil {
+		return 0, 0, errors.New("no video stream found in " + srcFileName)
+	}
+	width, height = stream.CodecCtx().Width(), stream.CodecCtx().Height()
+	if rotation := videoRotation(srcFileName); rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	return width, height, nil
+}
+
+// Transcode the video to H.264 and AAC in a MP4 container. The index is placed at the
+// beginning of the file (faststart), so browsers can play it before downloading completely.
+// Progress is reported from 0 to 1 as the source is read.
+func TranscodeVideo(srcFileName string, dstFileName string, progress func(done float64)) error {
+	return transcodeVideo(srcFileName, dstFileName, videoOutput{
+		format:  "mp4",
+		options: []gmf.Option{{Key: "movflags", Val: "faststart"}},
+	}, progress)
+}
+
+// Transcode the video to a HLS rendition scaled to the height, segments of about 6 seconds
+// are written next to the playlist. The playlist is updated as segments are written, so
+// the video can be played while it is segmented.
+func SegmentVideo(srcFileName string, playlist string, height int, bitrate int, progress func(done float64)) error {
+	return transcodeVideo(srcFileName, playlist, videoOutput{
+		format: "hls",
+		options: []gmf.Option{
+			{Key: "hls_time", Val: "6"},
+			{Key: "hls_playlist_type", Val: "event"},
+			{Key: "hls_segment_filename", Val: filepath.Join(filepath.Dir(playlist), "segment%03d.ts")},
+		},
+		height:  height,
+		bitrate: bitrate,
+	}, progress)
+}
+
+func transcodeVideo(srcFileName string, dstFileName string, output videoOutput, progress func(done float64)) error {
+	// Input
+	inputCtx, err := gmf.NewInputCtx(srcFileName)
+	if err != nil {
+		return err
+	}
+	defer inputCtx.Free()
+	defer freeStreams(inputCtx)
+
+	// Output
+	outputFmt := gmf.FindOutputFmt(output.format, dstFileName, "")
+	if outputFmt == nil {
+		return errors.New(output.format + " format not available")
+	}
+	outputCtx, err := gmf.NewOutputCtx(outputFmt, output.options)
+	if err != nil {
+		return err
+	}
+	defer outputCtx.Free()
+
+	// Map input streams to output streams
+	streams := make(map[int]*gmf.Stream)
+	srcVideoStream, err := inputCtx.GetBestStream(gmf.AVMEDIA_TYPE_VIDEO)
+	if err != nil {
+		return errors.New("no video stream found in " + srcFileName)
+	}
+	ost, rotate, err := addVideoStream(outputCtx, srcVideoStream, output.height, output.bitrate, videoRotation(srcFileName))
+	if err != nil {
+		return err
+	}
+	defer ost.Free()
+	if rotate != nil {
+		defer rotate.Release()
+	}
+	streams[srcVideoStream.Index()] = ost
+
+	if srcAudioStream, err := inputCtx.GetBestStream(gmf.AVMEDIA_TYPE_AUDIO); err == nil {
+		ost, err := addAudioStream(outputCtx, srcAudioStream)
+		if err != nil {
+			return err
+		}
+		defer ost.Free()
+		streams[srcAudioStream.Index()] = ost
+	}
+
+	if err := outputCtx.WriteHeader(); err != nil {
+		return err
+	}
+
+	duration := inputCtx.Duration()
+	for {
+		packet, err := inputCtx.GetNextPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		ost, ok := streams[packet.StreamIndex()]
+		if !ok {
+			packet.Free()
+			continue
+		}
+		ist, err := inputCtx.GetStream(packet.StreamIndex())
+		if err != nil {
+			packet.Free()
+			return err
+		}
+		if duration > 0 && progress != nil {
+			progress(float64(packet.Time(ist.TimeBase())) / duration)
+		}
+
+		frames, err := ist.CodecCtx().Decode(packet)
+		packet.Free()
+		if err != nil {
+			return err
+		}
+		if rotate != nil && ist.Index() == srcVideoStream.Index() {
+			if frames, err = filterFrames(rotate, frames, false); err != nil {
+				return err
+			}
+		}
+		if err := encodeFrames(outputCtx, ost, frames, false); err != nil {
+			return err
+		}
+	}
+
+	// Flush decoders and encoders
+	for index, ost := range streams {
+		ist, _ := inputCtx.GetStream(index)
+		frames, err := ist.CodecCtx().Decode(nil)
+		if err != nil {
+			return err
+		}
+		if rotate != nil && index == srcVideoStream.Index() {
+			if frames, err = filterFrames(rotate, frames, true); err != nil {
+				return err
+			}
+		}
+		if err := encodeFrames(outputCtx, ost, frames, true); err != nil {
+			return err
+		}
+	}
+
+	outputCtx.WriteTrailer()
+	if progress != nil {
+		progress(1)
+	}
+	return nil
+}
+
+// Add the H.264 stream for the video. Rotated videos are returned with a filter that scales and
+// rotates the frames, since the display matrix is not kept by every format (e.g. MPEG-TS of HLS).
+func addVideoStream(outputCtx *gmf.FmtCtx, ist *gmf.Stream, height int, bitrate int, rotation int) (*gmf.Stream, *gmf.Filter, error) {
+	codec, err := gmf.FindEncoder("libx264")
+	if err != nil {
+		return nil, nil, err
+	}
+	icc := ist.CodecCtx()
+	cc := gmf.NewCodecCtx(codec)
+	if cc == nil {
+		return nil, nil, errors.New("unable to create codec context for H.264")
+	}
+
+	// Keep the frame rate of the source, frames are numbered sequentially
+	frameRate := ist.GetAvgFrameRate().AVR()
+	if frameRate.Num <= 0 || frameRate.Den <= 0 {
+		frameRate = gmf.AVR{Num: 30, Den: 1}
+	}
+	// Scale down keeping the aspect ratio, dimensions must be even
+	srcWidth, srcHeight := icc.Width(), icc.Height()
+	if rotation == 90 || rotation == 270 {
+		srcWidth, srcHeight = srcHeight, srcWidth
+	}
+	width := srcWidth
+	if height > 0 && height < srcHeight {
+		width = (srcWidth*height/srcHeight + 1) &^ 1
+	} else {
+		height = srcHeight
+	}
+	options := []gmf.Option{
+		{Key: "time_base", Val: frameRate.Invert()},
+		{Key: "pixel_format", Val: gmf.AV_PIX_FMT_YUV420P},
+		{Key: "video_size", Val: fmt.Sprintf("%dx%d", width, height)},
+		{Key: "preset", Val: "veryfast"},
+		// Keyframe every 2 seconds, where segments can be split
+		{Key: "g", Val: 2 * frameRate.Num / frameRate.Den},
+	}
+	if bitrate > 0 {
+		options = append(options,
+			gmf.Option{Key: "b", Val: bitrate},
+			gmf.Option{Key: "maxrate", Val: bitrate * 3 / 2},
+			gmf.Option{Key: "bufsize", Val: bitrate * 2})
+	} else {
+		options = append(options, gmf.Option{Key: "crf", Val: 23})
+	}
+	cc.SetOptions(options)
+	if outputCtx.IsGlobalHeader() {
+		cc.SetFlag(gmf.CODEC_FLAG_GLOBAL_HEADER)
+	}
+	if err := cc.Open(nil); err != nil {
+		return nil, nil, err
+	}
+
+	ost, err := outputCtx.AddStreamWithCodeCtx(cc)
+	if err != nil {
+		return nil, nil, err
+	}
+	ost.SetCodecCtx(cc)
+	ost.SetTimeBase(frameRate.Invert())
+	ost.SetAvgFrameRate(frameRate)
+
+	var transpose string
+	switch rotation {
+	case 90:
+		transpose = "transpose=clock"
+	case 180:
+		transpose = "hflip,vflip"
+	case 270:
+		transpose = "transpose=cclock"
+	default:
+		// Convert the source pixel format, only YUV 4:2:0 is widely supported by browsers
+		ost.SwsCtx, err = gmf.NewSwsCtx(icc.Width(), icc.Height(), icc.PixFmt(), cc.Width(), cc.Height(), cc.PixFmt(), gmf.SWS_BICUBIC)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ost, nil, nil
+	}
+	// Scaled before rotating, the filter converts the frames to YUV 4:2:0 as well
+	scaleWidth, scaleHeight := width, height
+	if rotation != 180 {
+		scaleWidth, scaleHeight = height, width
+	}
+	filter, err := gmf.NewFilter(fmt.Sprintf("scale=%d:%d:flags=bicubic,%s", scaleWidth, scaleHeight, transpose), []*gmf.Stream{ist}, ost, nil)
+	if err != nil {
+		if filter != nil {
+			filter.Release()
+		}
+		return nil, nil, err
+	}
+	return ost, filter, nil
+}
+
+// Pass the decoded frames through the filter, flushing returns the frames still in the filter
+func filterFrames(filter *gmf.Filter, frames []*gmf.Frame, flush bool) ([]*gmf.Frame, error) {
+	for i, frame := range frames {
+		err := filter.AddFrame(frame, 0, gmf.AV_BUFFERSRC_FLAG_PUSH)
+		frame.Free()
+		if err != nil {
+			for _, f := range frames[i+1:] {
+				f.Free()
+			}
+			return nil, err
+		}
+	}
+	if flush {
+		if err := filter.Close(0); err != nil {
+			return nil, err
+		}
+	}
+	// An error is also returned when no more frames are available yet, only failures return no list
+	filtered, err := filter.GetFrame()
+	if filtered == nil {
+		return nil, err
+	}
+	return filtered, nil
+}
+
+func addAudioStream(outputCtx *gmf.FmtCtx, ist *gmf.Stream) (*gmf.Stream, error) {
+	codec, err := gmf.FindEncoder("aac")
+	if err != nil {
+		return nil, err
+	}
+	icc := ist.CodecCtx()
+	cc := gmf.NewCodecCtx(codec)
+	if cc == nil {
+		return nil, errors.New("unable to create codec context for AAC")
+	}
+
+	inLayout := icc.ChannelLayout()
+	if inLayout == 0 {
+		inLayout = icc.GetDefaultChannelLayout(icc.Channels())
+	}
+	cc.SetSampleFmt(gmf.AV_SAMPLE_FMT_FLTP)
+	cc.SetOptions([]gmf.Option{
+		{Key: "time_base", Val: gmf.AVR{Num: 1, Den: icc.SampleRate()}},
+		{Key: "ar", Val: icc.SampleRate()},
+		{Key: "ac", Val: icc.Channels()},
+		{Key: "channel_layout", Val: inLayout},
+	})
+	if outputCtx.IsGlobalHeader() {
+		cc.SetFlag(gmf.CODEC_FLAG_GLOBAL_HEADER)
+	}
+	if codec.IsExperimental() {
+		cc.SetStrictCompliance(gmf.FF_COMPLIANCE_EXPERIMENTAL)
+	}
+	if err := cc.Open(nil); err != nil {
+		return nil, err
+	}
+
+	ost, err := outputCtx.AddStreamWithCodeCtx(cc)
+	if err != nil {
+		return nil, err
+	}
+	ost.SetCodecCtx(cc)
+
+	// Resample to the format of the encoder, in chunks of the size of its frames
+	ost.SwrCtx, err = gmf.NewSwrCtx([]*gmf.Option{
+		{Key: "in_channel_layout", Val: inLayout},
+		{Key: "out_channel_layout", Val: cc.ChannelLayout()},
+		{Key: "in_sample_rate", Val: icc.SampleRate()},
+		{Key: "out_sample_rate", Val: cc.SampleRate()},
+		{Key: "in_sample_fmt", Val: gmf.SampleFormat(icc.SampleFmt())},
+		{Key: "out_sample_fmt", Val: gmf.SampleFormat(cc.SampleFmt())},
+	}, cc.Channels(), cc.SampleFmt())
+	if err != nil {
+		return nil, err
+	}
+	ost.AvFifo = gmf.NewAVAudioFifo(icc.SampleFmt(), icc.Channels(), 1024)
+	return ost, nil
+}
+
+// Encode the decoded frames and write them to the output stream
+func encodeFrames(outputCtx *gmf.FmtCtx, ost *gmf.Stream, frames []*gmf.Frame, flush bool) error {
+	var err error
+	if ost.IsVideo() {
+		// Frames of rotated videos are already scaled by the filter
+		if ost.SwsCtx != nil {
+			if frames, err = gmf.DefaultRescaler(ost.SwsCtx, frames); err != nil {
+				return err
+			}
+		}
+		for _, frame := range frames {
+			frame.SetPts(ost.Pts)
+			ost.Pts++
+		}
+	} else {
+		frames = gmf.DefaultResampler(ost, frames, flush)
+	}
+
+	packets, err := ost.CodecCtx().Encode(frames, -1)
+	if err != nil {
+		return err
+	}
+	if flush {
+		// Drain the packets still in the encoder
+		rest, err := ost.CodecCtx().Encode(nil, 0)
+		if err != nil {
+			return err
+		}
+		packets = append(packets, rest...)
+	}
+	for i, packet := range packets {
+		gmf.RescaleTs(packet, ost.CodecCtx().TimeBase(), ost.TimeBase())
+		packet.SetStreamIndex(ost.Index())
+		err = outputCtx.WritePacket(packet)
+		packet.Free()
+		if err != nil {
+			for _, p := range packets[i+1:] {
+				p.Free()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func freeStreams(ctx *gmf.FmtCtx) {
+	for i := 0; i < ctx.StreamsCnt(); i++ {
+		st, _ := ctx.GetStream(i)
+		if st.CodecCtx() != nil {
+			st.CodecCtx().Free()
+		}
+		st.Free()
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata of videos. MP4 and QuickTime files are parsed directly from their atoms,
+// other containers are probed with libav.
+
+type VideoInfo struct {
+	Format     string      `json:"format"`     // Container format
+	Width      int         `json:"width"`      // Displayed width, with rotation applied
+	Height     int         `json:"height"`     // Displayed height, with rotation applied
+	Rotation   int         `json:"rotation"`   // Degrees clockwise
+	Duration   float64     `json:"duration"`   // Seconds
+	VideoCodec string      `json:"videoCodec"` // Named as the decoders of libav
+	AudioCodec string      `json:"audioCodec"`
+	Bitrate    int64       `json:"bitrate"`   // Bits per second
+	FrameRate  float64     `json:"frameRate"` // Frames per second
+	Date       time.Time   `json:"date"`      // Creation date, zero if unknown
+	Location   GPSLocation `json:"location"`
+	ContentID  string      `json:"contentId,omitempty"` // Identifier shared with the image of Live Photos
+}
+
+const mp4MaxMetadataSize = 1 << 20 // Limit for atoms read into memory
+
+var (
+	errNotMP4  = errors.New("not a MP4 or QuickTime file")
+	iso6709    = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+	mp4Epoch   = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	mp4Codecs  = map[string]string{"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "vp09": "vp9", "av01": "av1", "mp4v": "mpeg4", "jpeg": "mjpeg", "mp4a": "aac", ".mp3": "mp3", "Opus": "opus", "ac-3": "ac3", "ec-3": "eac3", "alac": "alac", "sowt": "pcm_s16le", "twos": "pcm_s16be", "lpcm": "pcm_s16le"}
+	appleDates = []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05Z07:00"}
+)
+
+// Extract the metadata of the video
+func ExtractVideoInfo(path string) (*VideoInfo, error) {
+	info, err := ParseMP4(path)
+	if errors.Is(err, errNotMP4) {
+		return ProbeVideo(path)
+	}
+	return info, err
+}
+
+// Track of a MP4 file
+type mp4Track struct {
+	handler   string
+	codec     string
+	width     float64
+	height    float64
+	rotation  int
+	timescale uint32
+	duration  uint64
+	samples   uint64
+}
+
+// Parse the metadata from the atoms of MP4 and QuickTime files
+func ParseMP4(path string) (*VideoInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &VideoInfo{}
+	found := false
+	err = mp4Boxes(f, 0, stat.Size(), func(typ string, offset int64, size int64) error {
+		switch typ {
+		case "ftyp":
+			brand := make([]byte, 4)
+			if _, err := f.ReadAt(brand, offset); err != nil {
+				return err
+			}
+			info.Format = strings.TrimSpace(string(brand))
+			found = true
+		case "moov":
+			found = true
+			return parseMoov(f, offset, size, info)
+		}
+		return nil
+	})
+	if !found {
+		return nil, errNotMP4
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Duration > 0 {
+		info.Bitrate = int64(float64(stat.Size()*8) / info.Duration)
+	}
+	return info, nil
+}
+
+// Iterate over the atoms in the range, the function gets the position of the contents
+func mp4Boxes(r io.ReaderAt, offset int64, end int64, fn func(typ string, offset int64, size int64) error) error {
+	header := make([]byte, 16)
+	for offset+8 <= end {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		typ := string(header[4:8])
+		switch size {
+		case 0: // Until the end
+			size = end - offset
+		case 1: // 64 bits size
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || offset+size > end {
+			return errors.New("invalid size of atom " + typ)
+		}
+		if err := fn(typ, offset+headerSize, size-headerSize); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+func readMP4Box(r io.ReaderAt, offset int64, size int64) ([]byte, error) {
+	if size > mp4MaxMetadataSize {
+		return nil, errors.New("atom is too large")
+	}
+	data := make([]byte, size)
+	_, err := r.ReadAt(data, offset)
+	return data, err
+}
+
+func parseMoov(r io.ReaderAt, offset int64, size int64, info *VideoInfo) error {
+	var tracks []*mp4Track
+	err := mp4Boxes(r, offset, offset+size, func(typ string, offset int64, size int64) error {
+		switch typ {
+		case "mvhd":
+			data, err := readMP4Box(r, offset, size)
+			if err != nil {
+				return err
+			}
+			created, timescale, duration := parseMP4Header(data)
+			if timescale > 0 {
+				info.Duration = float64(duration) / float64(timescale)
+			}
+			if created > 0 {
+				info.Date = mp4Epoch.Add(time.Duration(created) * time.Second)
+			}
+		case "trak":
+			track := &mp4Track{}
+			tracks = append(tracks, track)
+			return parseTrak(r, offset, size, track)
+		case "udta":
+			return mp4Boxes(r, offset, offset+size, func(typ string, offset int64, size int64) error {
+				if typ != "\xa9xyz" {
+					return nil
+				}
+				data, err := readMP4Box(r, offset, size)
+				if err != nil || len(data) < 4 {
+					return err
+				}
+				// Length and language precede the text
+				info.Location = parseISO6709(string(data[4:]))
+				return nil
+			})
+		case "meta":
+			return parseMP4Meta(r, offset, size, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, track := range tracks {
+		switch track.handler {
+		case "vide":
+			if info.VideoCodec != "" {
+				continue // First video track
+			}
+			info.VideoCodec = mp4Codec(track.codec)
+			info.Rotation = track.rotation
+			info.Width, info.Height = int(track.width), int(track.height)
+			if info.Rotation == 90 || info.Rotation == 270 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+			if track.timescale > 0 && track.duration > 0 {
+				info.FrameRate = float64(track.samples) / (float64(track.duration) / float64(track.timescale))
+			}
+		case "soun":
+			if info.AudioCodec == "" {
+				info.AudioCodec = mp4Codec(track.codec)
+			}
+		}
+	}
+	return nil
+}
+
+// Creation time, time scale and duration from the mvhd and mdhd atoms
+func parseMP4Header(data []byte) (created uint64, timescale uint32, duration uint64) {
+	if len(data) >= 32 && data[0] == 1 {
+		return binary.BigEndian.Uint64(data[4:]), binary.BigEndian.Uint32(data[20:]), binary.BigEndian.Uint64(data[24:])
+	}
+	if len(data) >= 20 {
+		return uint64(binary.BigEndian.Uint32(data[4:])), binary.BigEndian.Uint32(data[12:]), uint64(binary.BigEndian.Uint32(data[16:]))
+	}
+	return 0, 0, 0
+}
+
+func parseTrak(r io.ReaderAt, offset int64, size int64, track *mp4Track) error {
+	return mp4Boxes(r, offset, offset+size, func(typ string, offset int64, size int64) error {
+		switch typ {
+		case "tkhd":
+			data, err := readMP4Box(r, offset, size)
+			if err != nil {
+				return err
+			}
+			// Transformation matrix and dimensions follow the durations
+			matrix := 40
+			if len(data) > 0 && data[0] == 1 {
+				matrix = 52
+			}
+			if len(data) < matrix+44 {
+				return nil
+			}
+			a := int32(binary.BigEndian.Uint32(data[matrix:]))
+			b := int32(binary.BigEndian.Uint32(data[matrix+4:]))
+			rotation := int(math.Round(math.Atan2(float64(b), float64(a)) * 180 / math.Pi))
+			track.rotation = (rotation + 360) % 360
+			track.width = float64(binary.BigEndian.Uint32(data[matrix+36:])) / 65536
+			track.height = float64(binary.BigEndian.Uint32(data[matrix+40:])) / 65536
+		case "mdia", "minf", "stbl":
+			return parseTrak(r, offset, size, track)
+		case "mdhd":
+			data, err := readMP4Box(r, offset, size)
+			if err != nil {
+				return err
+			}
+			_, track.timescale, track.duration = parseMP4Header(data)
+		case "hdlr":
+			data := make([]byte, 12)
+			if _, err := r.ReadAt(data, offset); err == nil {
+				track.handler = string(data[8:12])
+			}
+		case "stsd":
+			// Format of the first sample description
+			data := make([]byte, 16)
+			if _, err := r.ReadAt(data, offset); err == nil {
+				track.codec = string(data[12:16])
+			}
+		case "stts":
+			data, err := readMP4Box(r, offset, size)
+			if err != nil || len(data) < 8 {
+				return err
+			}
+			count := int(binary.BigEndian.Uint32(data[4:]))
+			for i := 0; i < count && 8+i*8+8 <= len(data); i++ {
+				track.samples += uint64(binary.BigEndian.Uint32(data[8+i*8:]))
+			}
+		}
+		return nil
+	})
+}
+
+// Metadata of QuickTime files, as keys and values in separate atoms
+func parseMP4Meta(r io.ReaderAt, offset int64, size int64, info *VideoInfo) error {
+	// ISO files have version and flags before the atoms
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil
+	}
+	if binary.BigEndian.Uint32(header) == 0 {
+		offset, size = offset+4, size-4
+	}
+
+	var keys []string
+	return mp4Boxes(r, offset, offset+size, func(typ string, offset int64, size int64) error {
+		switch typ {
+		case "keys":
+			data, err := readMP4Box(r, offset, size)
+			if err != nil || len(data) < 8 {
+				return err
+			}
+			for pos := 8; pos+8 <= len(data); {
+				keySize := int(binary.BigEndian.Uint32(data[pos:]))
+				if keySize < 8 || pos+keySize > len(data) {
+					break
+				}
+				keys = append(keys, string(data[pos+8:pos+keySize]))
+				pos += keySize
+			}
+		case "ilst":
+			return mp4Boxes(r, offset, offset+size, func(typ string, offset int64, size int64) error {
+				index := int(binary.BigEndian.Uint32([]byte(typ)))
+				if index < 1 || index > len(keys) {
+					return nil
+				}
+				data, err := readMP4Box(r, offset, size)
+				// Value is in a data atom, after the type and locale
+				if err != nil || len(data) < 16 || string(data[4:8]) != "data" {
+					return err
+				}
+				value := string(data[16:])
+				switch keys[index-1] {
+				case "com.apple.quicktime.creationdate":
+					for _, layout := range appleDates {
+						if date, err := time.Parse(layout, value); err == nil {
+							info.Date = date
+							break
+						}
+					}
+				case "com.apple.quicktime.location.ISO6709":
+					info.Location = parseISO6709(value)
+				case "com.apple.quicktime.content.identifier":
+					info.ContentID = value
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// Parse locations like +37.3349-122.0090+020.000/
+func parseISO6709(value string) (location GPSLocation) {
+	match := iso6709.FindStringSubmatch(value)
+	if match == nil {
+		return
+	}
+	lat, err1 := strconv.ParseFloat(match[1], 64)
+	lng, err2 := strconv.ParseFloat(match[2], 64)
+	if err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return
+	}
+	return GPSLocation{Present: true, Lat: lat, Long: lng}
+}
+
+func mp4Codec(fourcc string) string {
+	if codec, ok := mp4Codecs[fourcc]; ok {
+		return codec
+	}
+	return string(bytes.TrimRight([]byte(fourcc), " \x00"))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func box(typ string, contents ...[]byte) []byte {
+	data := make([]byte, 8)
+	copy(data[4:], typ)
+	for _, c := range contents {
+		data = append(data, c...)
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func be32(values ...uint32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[i*4:], v)
+	}
+	return data
+}
+
+func TestParseMP4(t *testing.T) {
+	created := uint32(time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC).Sub(mp4Epoch).Seconds())
+	// Rotated 90 degrees: a=0, b=1, c=-1, d=0
+	matrix := be32(0, 0x10000, 0xffff0000, 0, 0, 0, 0, 0, 0x40000000)
+	tkhd := box("tkhd", be32(0, 0, 0, 1, 0, 0, 0, 0, 0, 0), matrix, be32(1920<<16, 1080<<16))
+	videoTrack := box("trak", tkhd, box("mdia",
+		box("mdhd", be32(0, 0, 0, 600, 6000, 0)),
+		box("hdlr", be32(0, 0), []byte("vide"), be32(0, 0, 0)),
+		box("minf", box("stbl",
+			box("stsd", be32(0, 1), box("hvc1")),
+			box("stts", be32(0, 1, 300, 20))))))
+	audioTrack := box("trak", box("mdia",
+		box("hdlr", be32(0, 0), []byte("soun"), be32(0, 0, 0)),
+		box("minf", box("stbl", box("stsd", be32(0, 1), box("mp4a"))))))
+	location := append([]byte{0, 18, 0x15, 0xc7}, "+37.3349-122.0090+020.000/"...)
+	keys := box("keys", be32(0, 1), box("mdta", []byte("com.apple.quicktime.creationdate")))
+	ilst := box("ilst", box("\x00\x00\x00\x01", box("data", be32(1, 0), []byte("2023-05-01T14:00:00+0200"))))
+
+	data := append(box("ftyp", []byte("qt  "), be32(0)), box("moov",
+		box("mvhd", be32(0, created, created, 600, 6000)),
+		videoTrack, audioTrack,
+		box("udta", box("\xa9xyz", location)),
+		box("meta", box("hdlr", be32(0, 0, 0, 0, 0, 0)), keys, ilst))...)
+	data = append(data, box("mdat", make([]byte, 1000))...)
+	path := filepath.Join(t.TempDir(), "video.mov")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ExtractVideoInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "qt" || info.Duration != 10 || info.FrameRate != 30 {
+		t.Error("Unexpected format, duration or frame rate", info)
+	}
+	if info.Width != 1080 || info.Height != 1920 || info.Rotation != 90 {
+		t.Error("Unexpected size or rotation", info.Width, info.Height, info.Rotation)
+	}
+	if info.VideoCodec != "hevc" || info.AudioCodec != "aac" {
+		t.Error("Unexpected codecs", info.VideoCodec, info.AudioCodec)
+	}
+	if info.Bitrate != int64(len(data)*8/10) {
+		t.Error("Unexpected bitrate", info.Bitrate)
+	}
+	// Date from the metadata is preferred, it keeps the time zone
+	if !info.Date.Equal(time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)) || info.Date.Format("-0700") != "+0200" {
+		t.Error("Unexpected date", info.Date)
+	}
+	if !info.Location.Present || info.Location.Lat != 37.3349 || info.Location.Long != -122.009 {
+		t.Error("Unexpected location", info.Location)
+	}
+
+	os.WriteFile(path, []byte("not a video at all"), 0644)
+	if _, err := ParseMP4(path); err != errNotMP4 {
+		t.Error("Expected error for files that are not MP4", err)
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	for value, expected := range map[string]GPSLocation{
+		"+37.3349-122.0090+020.000/": {true, 37.3349, -122.009},
+		"-33.8688+151.2093/":         {true, -33.8688, 151.2093},
+		"+91.0000+000.0000/":         {},
+		"invalid":                    {},
+	} {
+		if location := parseISO6709(value); location != expected {
+			t.Errorf("Location %v parsed from %s, expected %v", location, value, expected)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/timshannon/bolthold"
+)
+
+// Changes made to the collection outside of the application (e.g. by other apps or directly in
+// the disk) are watched and applied to the cache incrementally, without scanning the albums.
+// Events are gathered until no more changes happen for a while and then the paths changed are
+// compared with the disk, so the order and the type of the events do not matter.
+
+// Time to wait for more changes before updating the cache
+var WatchDelay = 2 * time.Second
+
+type Watcher struct {
+	collection *Collection
+	fsWatcher  *fsnotify.Watcher
+	timer      *time.Timer
+	changed    map[string]struct{} // Paths changed since the last update
+	mux        sync.Mutex
+	muxUpdate  sync.Mutex
+}
+
+// Start watching the folders of the collection for changes
+func (c *Collection) Watch() error {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &Watcher{
+		collection: c,
+		fsWatcher:  fsWatcher,
+		changed:    make(map[string]struct{}),
+	}
+	if err := w.addFolder(c.PhotosPath); err != nil {
+		fsWatcher.Close()
+		return err
+	}
+	c.watcher = w
+	go w.run()
+	log.Printf("Watching collection %s for changes", c.Name)
+	return nil
+}
+
+// Stop watching the collection, pending changes are discarded
+func (c *Collection) StopWatching() {
+	if c.watcher == nil {
+		return
+	}
+	c.watcher.fsWatcher.Close()
+	c.watcher.mux.Lock()
+	if c.watcher.timer != nil {
+		c.watcher.timer.Stop()
+	}
+	c.watcher.mux.Unlock()
+	c.watcher = nil
+}
+
+// Files and folders ignored, hidden ones are used internally (e.g. trash or temporary files)
+func ignoredPath(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), ".")
+}
+
+// Watch the folder and all its sub-folders
+func (w *Watcher) addFolder(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && ignoredPath(path) {
+			return filepath.SkipDir
+		}
+		return w.fsWatcher.Add(path)
+	})
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if ignoredPath(event.Name) {
+				continue
+			}
+			// New folders are watched right away, not to miss changes inside them
+			if event.Op&fsnotify.Create != 0 {
+				if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+					if err := w.addFolder(event.Name); err != nil {
+						log.Println("Cannot watch", event.Name, err)
+					}
+				}
+			}
+			w.mux.Lock()
+			w.changed[event.Name] = struct{}{}
+			if w.timer == nil {
+				w.timer = time.AfterFunc(WatchDelay, w.update)
+			} else {
+				w.timer.Reset(WatchDelay)
+			}
+			w.mux.Unlock()
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watching collection %s: %v", w.collection.Name, err)
+		}
+	}
+}
+
+// Apply the pending changes to the cache, grouped by album
+func (w *Watcher) update() {
+	w.mux.Lock()
+	changed := w.changed
+	w.changed = make(map[string]struct{})
+	w.mux.Unlock()
+
+	w.muxUpdate.Lock()
+	defer w.muxUpdate.Unlock()
+	c := w.collection
+	albums := make(map[string][]string)
+	for path := range changed {
+		rel, err := filepath.Rel(c.PhotosPath, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		parts := strings.SplitN(rel, string(filepath.Separator), 2)
+		if len(parts) == 1 {
+			c.albumChanged(parts[0])
+		} else {
+			albums[parts[0]] = append(albums[parts[0]], path)
+		}
+	}
+	for name, paths := range albums {
+		c.albumFilesChanged(name, paths)
+	}
+	c.cache.FinishFlush()
+}
+
+// Album (folder or pseudo album file) created, removed or modified
+func (c *Collection) albumChanged(name string) {
+	stat, err := os.Stat(filepath.Join(c.PhotosPath, name))
+	if err != nil {
+		// Removed, as folder or as pseudo album
+		albumName := name
+		if strings.HasSuffix(strings.ToUpper(name), PSEUDO_ALBUM_EXT) {
+			albumName = name[:len(name)-len(PSEUDO_ALBUM_EXT)]
+		}
+		if !c.cache.IsAlbum(albumName) {
+			return
+		}
+		if _, err := c.GetAlbum(albumName); err == nil {
+			return // Still present with the other type
+		}
+		log.Printf("Album removed %s[%s]", c.Name, albumName)
+		c.removeAlbumInfo(albumName)
+		return
+	}
+
+	album, err := readAlbum(stat)
+	if err != nil {
+		return // Not an album
+	}
+	if album.IsPseudo {
+		// Links are read again when requested
+		c.cache.RemoveAlbum(album.Name)
+	}
+	if c.cache.IsAlbum(album.Name) {
+		return
+	}
+	log.Printf("Album added %s[%s]", c.Name, album.Name)
+	c.cache.AddToListAlbums(album)
+	if _, err := c.GetAlbumWithPhotos(album.Name, true, true); err != nil {
+		log.Println(err)
+	}
+}
+
+// Drop the album from the cache together with the info and the thumbnails of its photos
+func (c *Collection) removeAlbumInfo(name string) {
+	c.LockAlbum(name)
+	defer c.UnlockAlbum(name)
+	c.cache.FinishFlush()
+	var photos []*Photo
+	err := c.cache.WithStore(func(store *bolthold.Store) error {
+		return store.Find(&photos, bolthold.Where("Album").Eq(name).Index("Album"))
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	for _, photo := range photos {
+		photo.RemoveThumbnails(c)
+	}
+	c.cache.DeletePhotoInfo(photos...)
+	c.cache.RemoveAlbum(name)
+	c.cache.RemoveFromListAlbums(name)
+	c.cache.UnsetAlbumFullyScanned(name)
+	c.cache.UnsetAlbumFromThumbQueue(name)
+}
+
+// Files or folders inside the album created, removed or modified
+func (c *Collection) albumFilesChanged(name string, paths []string) {
+	if !c.IsAlbum(name) {
+		return
+	}
+	// Albums not cached yet are scanned, picking up the changes too
+	album, err := c.GetAlbumWithPhotos(name, false, true)
+	if err != nil || album.IsPseudo {
+		return
+	}
+
+	var added, removed []string
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		switch {
+		case err != nil:
+			removed = append(removed, path)
+		case stat.IsDir():
+			// Files in new folders might not have events
+			filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && d.IsDir() && ignoredPath(path) {
+					return filepath.SkipDir
+				}
+				if err == nil && !d.IsDir() && !ignoredPath(path) {
+					added = append(added, path)
+				}
+				return nil
+			})
+		default:
+			added = append(added, path)
+		}
+	}
+
+	c.LockAlbum(album.Name)
+	defer c.UnlockAlbum(album.Name)
+	var photos []*Photo
+	if len(removed) > 0 {
+		photos = append(photos, album.RemoveFiles(c, removed...)...)
+	}
+	if len(added) > 0 {
+		photos = append(photos, album.AddFiles(c, added...)...)
+	}
+	if len(photos) > 0 {
+		log.Printf("Updated %d photos of %s[%s] changed on disk", len(photos), c.Name, album.Name)
+	}
+}
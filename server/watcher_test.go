@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Wait until the condition is met, checked with the album locked
+func waitForAlbum(t *testing.T, collection *Collection, name string, condition func(album *Album) bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		collection.LockAlbum(name)
+		album, _ := collection.cache.GetAlbum(name)
+		ok := condition(album)
+		collection.UnlockAlbum(name)
+		if ok {
+			return
+		}
+	}
+	t.Fatal("Change not applied to album", name)
+}
+
+func TestWatch(t *testing.T) {
+	defer func(delay time.Duration) { WatchDelay = delay }(WatchDelay)
+	WatchDelay = 100 * time.Millisecond
+
+	collection := newTestCollection(t, "Album")
+	if _, err := collection.GetAlbumWithPhotos("Album", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := collection.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer collection.StopWatching()
+	dir := filepath.Join(collection.PhotosPath, "Album")
+
+	// Created
+	path := filepath.Join(dir, "IMG_0001.jpg")
+	if err := os.WriteFile(path, iptcJPEG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForAlbum(t, collection, "Album", func(album *Album) bool {
+		photo, err := album.GetPhoto("img_0001")
+		return err == nil && photo.Metadata.Title == "Mountains" && photo.Width == 60
+	})
+
+	// Modified
+	fout, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	EncodeImage(fout, gradient(300, 200, false), nil)
+	fout.Close()
+	waitForAlbum(t, collection, "Album", func(album *Album) bool {
+		photo, err := album.GetPhoto("img_0001")
+		return err == nil && photo.Width == 300 && len(photo.Files) == 1
+	})
+
+	// Sub-album moved in with files
+	other := filepath.Join(t.TempDir(), "Trip")
+	os.Mkdir(other, os.ModePerm)
+	os.WriteFile(filepath.Join(other, "IMG_0002.jpg"), iptcJPEG(t), 0644)
+	if err := os.Rename(other, filepath.Join(dir, "Trip")); err != nil {
+		t.Fatal(err)
+	}
+	waitForAlbum(t, collection, "Album", func(album *Album) bool {
+		_, err := album.GetPhoto("trip|img_0002")
+		return err == nil
+	})
+
+	// Removed
+	os.Remove(path)
+	os.RemoveAll(filepath.Join(dir, "Trip"))
+	waitForAlbum(t, collection, "Album", func(album *Album) bool {
+		return len(album.photosMap) == 0 && len(album.SubAlbums) == 0
+	})
+	collection.cache.FinishFlush()
+	if photo, err := collection.cache.GetPhotoInfo("Album", "img_0001"); err == nil && photo != nil {
+		t.Error("Info of the removed photo still cached")
+	}
+
+	// New album
+	os.Mkdir(filepath.Join(collection.PhotosPath, "New"), os.ModePerm)
+	os.WriteFile(filepath.Join(collection.PhotosPath, "New", "IMG_0003.jpg"), iptcJPEG(t), 0644)
+	waitForAlbum(t, collection, "New", func(album *Album) bool {
+		return album != nil && len(album.photosMap) == 1
+	})
+	os.RemoveAll(filepath.Join(collection.PhotosPath, "New"))
+	for start := time.Now(); collection.cache.IsAlbum("New"); time.Sleep(50 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("Removed album still cached")
+		}
+	}
+}
+
+func TestChangesWhileReading(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	album, err := collection.GetAlbumWithPhotos("Album", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(collection.PhotosPath, "Album")
+
+	// Cached album is read by requests while files change on disk
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			path := filepath.Join(dir, fmt.Sprintf("IMG_%04d.jpg", i))
+			os.WriteFile(path, iptcJPEG(t), 0644)
+			album.AddFiles(collection, path)
+		}
+		collection.cache.FinishFlush()
+		for i := 0; i < 20; i += 2 {
+			path := filepath.Join(dir, fmt.Sprintf("IMG_%04d.jpg", i))
+			os.Remove(path)
+			album.RemoveFiles(collection, path)
+		}
+	}()
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		if _, err := json.Marshal(album); err != nil {
+			t.Fatal(err)
+		}
+		album.GetPhoto("img_0001")
+	}
+	if photos := album.PhotoList(); len(photos) != 10 {
+		t.Error("Unexpected number of photos", len(photos))
+	}
+}
@@ -6,13 +6,13 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
 	"golang.org/x/net/webdav"
 )
 
@@ -23,8 +23,13 @@ type collectionsNodeFS struct {
 	c  *Collection       // Used by each collection
 }
 
-func (cs webDavCollections) find(name string) (*Collection, webdav.Dir, string, error) {
+// Find the collection for the path, the user in the context must have the access level required
+func (cs webDavCollections) find(ctx context.Context, name string, level AccessLevel) (*Collection, webdav.Dir, string, error) {
+	user := UserFromContext(ctx)
 	for _, c := range cs {
+		if !c.Allows(user, AccessRead) {
+			continue // Collection not visible for the user
+		}
 		var prefix string = ""
 		if name == c.Name {
 			prefix = c.Name
@@ -36,10 +41,60 @@ func (cs webDavCollections) find(name string) (*Collection, webdav.Dir, string,
 			prefix = "/" + c.Name + "/"
 		}
 		if prefix != "" {
+			if !c.Allows(user, level) {
+				return nil, webdav.Dir(""), "", os.ErrPermission
+			}
+			// Modifications are not allowed in read-only collections
+			if level >= AccessWrite && c.CheckWritable() != nil {
+				return nil, webdav.Dir(""), "", os.ErrPermission
+			}
 			return c, webdav.Dir(c.PhotosPath), strings.TrimPrefix(name, prefix), nil
 		}
 	}
-	return nil, webdav.Dir(""), "", errors.New("collection not found")
+	return nil, webdav.Dir(""), "", os.ErrNotExist
+}
+
+// Methods that change the files, refused before being handled when the collection cannot be changed
+var webDavWriteMethods = []string{"PUT", "DELETE", "MKCOL", "MOVE", "COPY", "PROPPATCH"}
+
+// Check if the user can change the collections of the request (source and destination when copying or moving)
+func (cs webDavCollections) checkWrite(r *http.Request, prefix string) error {
+	if !slices.Contains(webDavWriteMethods, r.Method) {
+		return nil
+	}
+	var paths []string
+	if r.Method != "COPY" { // Source is only read
+		paths = append(paths, r.URL.Path)
+	}
+	if destination := r.Header.Get("Destination"); destination != "" {
+		if u, err := url.Parse(destination); err == nil {
+			paths = append(paths, u.Path)
+		}
+	}
+	for _, path := range paths {
+		name := strings.TrimPrefix(path, prefix)
+		if _, _, _, err := cs.find(r.Context(), name, AccessWrite); errors.Is(err, os.ErrPermission) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collections visible for the user in the context
+func (cs webDavCollections) visible(ctx context.Context) webDavCollections {
+	user := UserFromContext(ctx)
+	visible := make(webDavCollections)
+	for name, c := range cs {
+		if c.Allows(user, AccessRead) {
+			visible[name] = c
+		}
+	}
+	return visible
+}
+
+// Check if the flags used to open a file allow to modify it
+func isWriteFlag(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
 }
 
 func (c collectionsNodeFS) Readdir(count int) ([]fs.FileInfo, error) {
@@ -65,7 +120,7 @@ func (f collectionsNodeFS) IsDir() bool        { return true }
 func (f collectionsNodeFS) Sys() interface{}   { return nil }
 
 func (cs webDavCollections) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
-	_, dir, name, err := cs.find(name)
+	_, dir, name, err := cs.find(ctx, name, AccessWrite)
 	if err != nil {
 		return err
 	}
@@ -74,10 +129,14 @@ func (cs webDavCollections) Mkdir(ctx context.Context, name string, perm os.File
 func (cs webDavCollections) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
 	// Open root folder (i.e. list of collections)
 	if name == "" || name == "/" {
-		return collectionsNodeFS{cs: cs}, nil
+		return collectionsNodeFS{cs: cs.visible(ctx)}, nil
 	}
 
-	c, dir, name, err := cs.find(name)
+	level := AccessRead
+	if isWriteFlag(flag) {
+		level = AccessWrite
+	}
+	c, dir, name, err := cs.find(ctx, name, level)
 	if err != nil {
 		return nil, err
 	}
@@ -85,38 +144,28 @@ func (cs webDavCollections) OpenFile(ctx context.Context, name string, flag int,
 	if c.RenameOnReplace {
 		// If file exists, create a new file instead with an increment
 		if (flag & os.O_CREATE) == os.O_CREATE {
-			for i := 1; true; i++ {
-				f := name
-				if i > 1 {
-					ext := path.Ext(name)
-					f = strings.TrimSuffix(name, ext) + "_" + strconv.Itoa(i) + ext
-				}
-
-				// Check if file doesnt exist
+			name = uniqueFilename(name, func(f string) bool {
 				_, err := dir.Stat(ctx, f)
-				if err != nil {
-					name = f
-					break
-				}
-			}
+				return err == nil
+			})
 		}
 	}
 
 	return dir.OpenFile(ctx, name, flag, perm)
 }
 func (cs webDavCollections) RemoveAll(ctx context.Context, name string) error {
-	_, dir, name, err := cs.find(name)
+	_, dir, name, err := cs.find(ctx, name, AccessWrite)
 	if err != nil {
 		return err
 	}
 	return dir.RemoveAll(ctx, name)
 }
 func (cs webDavCollections) Rename(ctx context.Context, oldName, newName string) error {
-	_, oldDir, oldName, err := cs.find(oldName)
+	_, oldDir, oldName, err := cs.find(ctx, oldName, AccessWrite)
 	if err != nil {
 		return err
 	}
-	_, newDir, newName, err := cs.find(newName)
+	_, newDir, newName, err := cs.find(ctx, newName, AccessWrite)
 	if err != nil {
 		return err
 	}
@@ -128,16 +177,16 @@ func (cs webDavCollections) Rename(ctx context.Context, oldName, newName string)
 func (cs webDavCollections) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	// Stat for root folder (i.e. list of collections)
 	if name == "" || name == "/" {
-		return collectionsNodeFS{cs: cs}, nil
+		return collectionsNodeFS{cs: cs.visible(ctx)}, nil
 	}
-	_, dir, name, err := cs.find(name)
+	_, dir, name, err := cs.find(ctx, name, AccessRead)
 	if err != nil {
 		return nil, err
 	}
 	return dir.Stat(ctx, name)
 }
 
-func WebDAVWithConfig(prefix string, collections map[string]*Collection) echo.MiddlewareFunc {
+func WebDAVWithConfig(prefix string, collections map[string]*Collection, users Users) echo.MiddlewareFunc {
 	wd := webdav.Handler{
 		Prefix:     prefix,
 		FileSystem: webDavCollections(collections),
@@ -152,7 +201,21 @@ func WebDAVWithConfig(prefix string, collections map[string]*Collection) echo.Mi
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			if strings.HasPrefix(c.Request().URL.Path, wd.Prefix) {
-				wd.ServeHTTP(c.Response(), c.Request())
+				r := c.Request()
+				// Same credentials as the API, clients are asked for HTTP Basic
+				if users != nil {
+					user, err := users.AuthenticateRequest(r)
+					if err != nil {
+						c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Photo Gallery", charset="UTF-8"`)
+						return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+					}
+					r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+				}
+				// Otherwise some methods would answer as if the file was not found
+				if err := wd.FileSystem.(webDavCollections).checkWrite(r, wd.Prefix); err != nil {
+					return echo.NewHTTPError(http.StatusForbidden, "changes not allowed: "+r.URL.Path)
+				}
+				wd.ServeHTTP(c.Response(), r)
 				return nil
 			}
 			return next(c)
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestWebDAVReadOnly(t *testing.T) {
+	collection := newTestCollection(t, "Album")
+	other := newTestCollection(t, "Album")
+	other.Name = "Other"
+	collection.ReadOnly = true
+	config.collections = map[string]*Collection{collection.Name: collection, other.Name: other}
+	existing := filepath.Join(collection.PhotosPath, "Album", "IMG_0001.jpg")
+	if err := os.WriteFile(existing, []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(WebDAVWithConfig("/webdav", config.collections, nil))
+	request := func(method string, path string, destination string) int {
+		var body io.Reader
+		if method == "PUT" {
+			body = strings.NewReader("new")
+		}
+		req := httptest.NewRequest(method, path, body)
+		if destination != "" {
+			req.Header.Set("Destination", destination)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		method      string
+		path        string
+		destination string
+	}{
+		{"PUT", "/webdav/Photos/Album/IMG_0002.jpg", ""},
+		{"PUT", "/webdav/Photos/Album/IMG_0001.jpg", ""},
+		{"DELETE", "/webdav/Photos/Album/IMG_0001.jpg", ""},
+		{"MKCOL", "/webdav/Photos/New", ""},
+		{"MOVE", "/webdav/Photos/Album/IMG_0001.jpg", "/webdav/Other/Album/IMG_0001.jpg"},
+		{"COPY", "/webdav/Other/Album/IMG_0001.jpg", "/webdav/Photos/Album/IMG_0003.jpg"},
+	}
+	for _, test := range tests {
+		if code := request(test.method, test.path, test.destination); code != http.StatusForbidden {
+			t.Errorf("%s %s in read-only collection: expected 403, got %d", test.method, test.path, code)
+		}
+	}
+	if data, err := os.ReadFile(existing); err != nil || string(data) != "photo" {
+		t.Error("File of read-only collection changed:", err)
+	}
+	for _, name := range []string{"Album/IMG_0002.jpg", "Album/IMG_0003.jpg", "New"} {
+		if _, err := os.Stat(filepath.Join(collection.PhotosPath, name)); !os.IsNotExist(err) {
+			t.Error("File created in read-only collection:", name)
+		}
+	}
+
+	// Reading and copying out of the collection are still allowed
+	if code := request("PROPFIND", "/webdav/Photos/Album/", ""); code != http.StatusMultiStatus {
+		t.Error("Cannot list read-only collection:", code)
+	}
+	if code := request("COPY", "/webdav/Photos/Album/IMG_0001.jpg", "/webdav/Other/Album/IMG_0001.jpg"); code != http.StatusCreated {
+		t.Error("Cannot copy from read-only collection:", code)
+	}
+	if code := request("PUT", "/webdav/Other/Album/IMG_0002.jpg", ""); code != http.StatusCreated {
+		t.Error("Cannot write to writable collection:", code)
+	}
+}
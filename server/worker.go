@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
 	"sync"
@@ -14,11 +15,17 @@ type ThumbWork struct {
 	photo      *Photo
 	writer     io.Writer
 	wg         *sync.WaitGroup
+	progress   *JobProgress
+	// Previews
+	size int // Size of the preview, 0 for thumbnails
+	path string
+	err  error
 }
 
 type InfoWork struct {
 	file *File
 	wg   *sync.WaitGroup
+	err  error
 }
 
 type ActiveWorkers struct {
@@ -44,7 +51,16 @@ func InitWorkers(config CmdArgs) {
 		go func() {
 			for w := range chThumbs {
 				atomic.AddInt32(&counter.thumbs, 1)
-				w.photo.GetThumbnail(w.collection, w.album, w.writer)
+				if w.size > 0 {
+					w.path, w.err = w.photo.GetPreview(w.collection, w.size)
+				} else {
+					w.err = w.photo.GetThumbnail(w.collection, w.album, w.writer)
+				}
+				if w.err != nil {
+					w.progress.AddErrors(1)
+				} else {
+					w.progress.AddFiles(1)
+				}
 				atomic.AddInt32(&counter.thumbs, -1)
 				w.wg.Done()
 			}
@@ -57,7 +73,7 @@ func InitWorkers(config CmdArgs) {
 		go func() {
 			for w := range chInfo {
 				atomic.AddInt32(&counter.info, 1)
-				w.file.ExtractInfo()
+				w.err = w.file.ExtractInfo()
 				atomic.AddInt32(&counter.info, -1)
 				w.wg.Done()
 			}
@@ -86,17 +102,24 @@ func WaitBackgroundWork(runningInBackground bool) {
 	}
 }
 
-func AddExtractInfoWork(collection *Collection, album *Album, runningInBackground bool, files ...PhotoFile) <-chan string {
+// Extract info of the files, stops queueing when the context is cancelled.
+// Identifiers of the photos are sent for each file processed.
+func AddExtractInfoWork(ctx context.Context, collection *Collection, album *Album, runningInBackground bool, files ...PhotoFile) <-chan string {
 	ch := make(chan string)
 	size := len(files)
+	progress := ProgressFromContext(ctx)
 
 	go func() {
 		var wgList sync.WaitGroup
 		wgList.Add(size)
 		for i, file := range files {
+			WaitBackgroundWork(runningInBackground)
+			if ctx.Err() != nil {
+				wgList.Add(i - size) // Remaining files are skipped
+				break
+			}
 			var wg sync.WaitGroup
 			wg.Add(1)
-			WaitBackgroundWork(runningInBackground)
 			log.Printf("Extracting photo info %s[%s] %d/%d: %s", collection.Name, album.Name, i+1, size, file.file.Id)
 			w := new(InfoWork)
 			w.file = file.file
@@ -104,6 +127,11 @@ func AddExtractInfoWork(collection *Collection, album *Album, runningInBackgroun
 			chInfo <- w
 			go func(id string) {
 				wg.Wait()
+				if w.err != nil {
+					progress.AddErrors(1)
+				} else {
+					progress.AddFiles(1)
+				}
 				ch <- id
 				wgList.Done()
 			}(file.photoId)
@@ -130,13 +158,34 @@ func AddThumbForeground(collection *Collection, album *Album, photo *Photo, writ
 	collection.cache.FinishFlush()
 }
 
-func AddThumbsBackground(collection *Collection, album *Album, photos ...*Photo) *sync.WaitGroup {
+// Generate the preview of the photo if needed, returns its location
+func AddPreviewForeground(collection *Collection, album *Album, photo *Photo, size int) (string, error) {
+	var wg sync.WaitGroup
+	var w ThumbWork
+
+	wg.Add(1)
+	w.collection = collection
+	w.album = album
+	w.photo = photo
+	w.size = size
+	w.wg = &wg
+	chThumbs <- &w
+	wg.Wait()
+	return w.path, w.err
+}
+
+// Generate thumbnails in background, stops queueing when the context is cancelled
+func AddThumbsBackground(ctx context.Context, collection *Collection, album *Album, photos ...*Photo) *sync.WaitGroup {
 	var wg sync.WaitGroup
 	var size = len(photos)
 
 	wg.Add(size)
 	for i, photo := range photos {
 		WaitBackgroundWork(true)
+		if ctx.Err() != nil {
+			wg.Add(i - size) // Remaining photos are skipped
+			break
+		}
 		log.Printf("Background thumbnail %s[%s] %d/%d: %s %s", collection.Name, album.Name, i+1, size, photo.Title, photo.SubAlbum)
 		w := new(ThumbWork)
 		w.collection = collection
@@ -144,6 +193,7 @@ func AddThumbsBackground(collection *Collection, album *Album, photos ...*Photo)
 		w.photo = photo
 		w.writer = nil
 		w.wg = &wg
+		w.progress = ProgressFromContext(ctx)
 		chThumbs <- w
 	}
 	return &wg
@@ -0,0 +1,50 @@
+import { useEffect, useState } from "react";
+
+export interface ConversionStatus {
+    ready: boolean;     // File can be played
+    progress: number;   // From 0 to 1
+}
+
+const RETRY_DELAY = 5;  // Seconds, when the server does not tell
+
+/** Wait until the server converts the file for the browser. The status is only followed while active. */
+const useConversion = (url: string | undefined, active: boolean): ConversionStatus => {
+    const [status, setStatus] = useState<ConversionStatus>({ ready: url === undefined, progress: 0 });
+
+    useEffect(() => {
+        if (url === undefined || !active || status.ready)
+            return;
+
+        let cancelled = false;
+        let timeout: ReturnType<typeof setTimeout>;
+        const check = async () => {
+            try {
+                const response = await fetch(url);
+                if (!response.ok)
+                    throw new Error(response.statusText);
+                const current: ConversionStatus = await response.json();
+                if (cancelled)
+                    return;
+                setStatus(current);
+                if (!current.ready) {
+                    const delay = Number(response.headers.get("Retry-After")) || RETRY_DELAY;
+                    timeout = setTimeout(check, delay * 1000);
+                }
+            } catch {
+                // Let the player show the error of the file
+                if (!cancelled)
+                    setStatus({ ready: true, progress: 0 });
+            }
+        };
+        check();
+
+        return () => {
+            cancelled = true;
+            clearTimeout(timeout);
+        };
+    }, [url, active, status.ready]);
+
+    return status;
+}
+
+export default useConversion;
@@ -97,7 +97,8 @@ const Lightbox: FC<LightboxProps> = ({ open, photos, selected, onClose }) => {
             // Fix lightbox over snackbar
             styles={{ root: { zIndex: theme.zIndex.modal } }}
             // enable optional lightbox plugins
-            plugins={[Captions, Fullscreen, Slideshow, Info, Favorite, LivePhoto, Video, Thumbnails, Zoom]}
+            // LivePhoto after Video, so it renders the videos instead
+            plugins={[Captions, Fullscreen, Slideshow, Info, Favorite, Video, LivePhoto, Thumbnails, Zoom]}
             render={{
                 thumbnail: renderThumbnail
             }}
@@ -64,6 +64,10 @@ const PhotoInfoDialog: FC<InfoPanelProps> = ({ open, photos, selected, onClose }
 
     const hasBefore = index > 0;
     const hasNext = index < photos.length - 1;
+    const metadata = photo.metadata;
+    // Only the fields shown below
+    const hasMetadata = !!(metadata.title || metadata.description || metadata.keywords?.length ||
+        metadata.rating !== undefined || metadata.regions?.length);
 
     const handleClose = () => {
         onClose();
@@ -128,6 +132,13 @@ const PhotoInfoDialog: FC<InfoPanelProps> = ({ open, photos, selected, onClose }
                         {photo.location.present && <Suspense><Map height="200px" mark={photo.location} /></Suspense>}
                     </Grid>
                 </Grid>
+                {hasMetadata && (<StyledList>
+                    {metadata.title && (<><dt>Title</dt><dd>{metadata.title}</dd></>)}
+                    {metadata.description && (<><dt>Description</dt><dd>{metadata.description}</dd></>)}
+                    {metadata.keywords && (<><dt>Keywords</dt><dd>{metadata.keywords.join(", ")}</dd></>)}
+                    {metadata.rating !== undefined && (<><dt>Rating</dt><dd>{metadata.rating < 0 ? "Rejected" : "★".repeat(metadata.rating)}</dd></>)}
+                    {metadata.regions && (<><dt>People</dt><dd>{metadata.regions.join(", ")}</dd></>)}
+                </StyledList>)}
                 {data.map((file: any) => (
                     <Accordion key={file.filestat.name} defaultExpanded>
                         <AccordionSummary expandIcon={<ExpandMoreIcon />}>
@@ -139,12 +150,22 @@ const PhotoInfoDialog: FC<InfoPanelProps> = ({ open, photos, selected, onClose }
                             <StyledList>
                                 <dt>Type</dt><dd>{file.type}</dd>
                                 <dt>MIME</dt><dd>{file.mime}</dd>
-                                {file.imageinfo && (<>
+                                {file.type === "image" && file.imageinfo && (<>
                                     <dt>Format</dt><dd>{file.imageinfo.format.toUpperCase()}</dd>
                                     <dt>Width</dt><dd>{file.imageinfo.width}px</dd>
                                     <dt>Height</dt><dd>{file.imageinfo.height}px</dd>
                                     <dt>Date taken</dt><dd>{file.imageinfo.date}</dd>
                                 </>)}
+                                {file.type === "video" && file.videoinfo && (<>
+                                    <dt>Format</dt><dd>{file.videoinfo.format.toUpperCase()}</dd>
+                                    <dt>Width</dt><dd>{file.videoinfo.width}px</dd>
+                                    <dt>Height</dt><dd>{file.videoinfo.height}px</dd>
+                                    <dt>Duration</dt><dd>{file.videoinfo.duration.toFixed(1)}s</dd>
+                                    <dt>Codecs</dt><dd>{[file.videoinfo.videoCodec, file.videoinfo.audioCodec].filter(Boolean).join(", ")}</dd>
+                                    <dt>Bitrate</dt><dd>{Math.round(file.videoinfo.bitrate / 1000).toLocaleString()} kbps</dd>
+                                    <dt>Frame rate</dt><dd>{file.videoinfo.frameRate.toFixed(2)} fps</dd>
+                                    <dt>Date taken</dt><dd>{file.videoinfo.date}</dd>
+                                </>)}
                             </StyledList>
                             {file.imageinfo?.exif && (<>
                                 <Typography textAlign="center" variant='h6'>Metadata</Typography>
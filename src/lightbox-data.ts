@@ -55,6 +55,7 @@ export function photoToSlideVideo(photo: PhotoType, files: FileType[]): SlideVid
         description: new Date(photo.date).toLocaleString(),
         width: photo.width,
         height: photo.height,
+        conversion: files.length > 0 ? urls.conversion(photo, files[0]) : undefined,
         sources: files.map(file => ({
             src: urls.file(photo, file),
             type: file.mime,
@@ -44,6 +44,16 @@ export interface PhotoType {
         lng: number;
     }
     files: FileType[];
+    metadata: MetadataType;
+}
+
+export interface MetadataType {
+    title?: string;
+    description?: string;
+    keywords?: string[];
+    rating?: number;
+    label?: string;
+    regions?: string[];
 }
 
 export interface FileType {
@@ -60,4 +70,5 @@ export type PhotoImageType = PhotoType & Image;
 export const urls = {
     thumb: (photo: PhotoType) => `/api/collections/${photo.collection}/albums/${photo.album}/photos/${photo.id}/thumb`,
     file: (photo: PhotoType, file: FileType) => `/api/collections/${photo.collection}/albums/${photo.album}/photos/${photo.id}/files/${file.id}`,
+    conversion: (photo: PhotoType, file: FileType) => `/api/collections/${photo.collection}/albums/${photo.album}/photos/${photo.id}/files/${file.id}/conversion`,
 }
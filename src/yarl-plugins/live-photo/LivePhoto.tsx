@@ -1,5 +1,6 @@
 import { PluginProps } from "yet-another-react-lightbox";
 import { LivePhotoSlide } from "./LivePhotoSlide";
+import { VideoSlide } from "./VideoSlide";
 
 export const defaultVideoProps = {
     controls: true,
@@ -21,6 +22,16 @@ export function LivePhoto({ augment }: PluginProps) {
                         />
                     );
                 }
+                // Videos are played here too, waiting for them to be converted
+                if (slide.type === "video") {
+                    return (
+                        <VideoSlide
+                            key={slide.sources?.map((source) => source.src).join(" ")}
+                            slide={slide}
+                            offset={offset}
+                        />
+                    );
+                }
                 return renderSlide?.({ slide, offset, rect });
             },
             ...restRender,
@@ -1,4 +1,5 @@
 import * as React from "react";
+import LinearProgress from "@mui/material/LinearProgress";
 
 import {
     ACTIVE_SLIDE_COMPLETE,
@@ -9,23 +10,35 @@ import {
     cssClass,
     useContainerRect,
     useEventCallback,
+    useEvents,
     useLightboxProps,
 } from "yet-another-react-lightbox/core";
 import { SlideVideo, LightboxProps } from "yet-another-react-lightbox";
 import { defaultVideoProps } from "./LivePhoto";
 import { PublishState } from "./LivePhotoSlide";
+import useConversion from "../../conversionHook";
 
 export type VideoSlideProps = {
     slide: SlideVideo;
     offset: number;
-    publish: (state: PublishState ) => void;
+    /** defaults to the events of the lightbox */
+    publish?: (state: PublishState ) => void;
 };
 
 /** Video slide */
-export function VideoSlide({ slide, offset, publish }: VideoSlideProps) {
+export function VideoSlide({ slide, offset, publish: publishState }: VideoSlideProps) {
     const video = { ...defaultVideoProps, ...useLightboxProps().video };
+    const { publish: publishEvent } = useEvents();
+    const publish = useEventCallback((state: PublishState) => {
+        if (publishState)
+            publishState(state);
+        else
+            publishEvent(state);
+    });
     const { setContainerRef, containerRect } = useContainerRect();
     const videoRef = React.useRef<HTMLVideoElement | null>(null);
+    // Videos not supported by the browser are converted first
+    const conversion = useConversion(slide.conversion, offset === 0);
 
     React.useEffect(() => {
         if (offset !== 0 && videoRef.current && !videoRef.current.paused) {
@@ -103,7 +116,16 @@ export function VideoSlide({ slide, offset, publish }: VideoSlideProps) {
                     }}
                     className={clsx(cssClass("video_container"), cssClass(CLASS_FLEX_CENTER))}
                 >
-                    {containerRect && (
+                    {containerRect && !conversion.ready && (
+                        <div style={{ position: "relative" }}>
+                            <img alt="" src={poster} {...scaleWidthAndHeight()} />
+                            <LinearProgress
+                                variant="determinate"
+                                value={conversion.progress * 100}
+                                sx={{ position: "absolute", left: 0, right: 0, bottom: 0 }} />
+                        </div>
+                    )}
+                    {containerRect && conversion.ready && (
                         <video
                             ref={setVideoRef}
                             poster={poster}
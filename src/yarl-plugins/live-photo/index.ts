@@ -8,6 +8,11 @@ declare module "yet-another-react-lightbox" {
         live: SlideLivePhoto;
     }
 
+    interface SlideVideo {
+        /** URL with the status of the conversion, the video is played once converted */
+        conversion?: string;
+    }
+
     /** Video slide attributes */
     export interface SlideLivePhoto extends GenericSlide {
         /** live photo thumbnail URL */